@@ -0,0 +1,42 @@
+// Command healthcheck asks a running message service for its health over the grpc.health.v1
+// protocol and exits with a non-zero status unless the service is serving. It is used by the
+// healthcheck of the compose service.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func main() {
+	address := flag.String("addr", "localhost:50055", "address of the message service")
+	service := flag.String("service", health.ReadinessService, "service to check: liveness or readiness")
+	timeout := flag.Duration("timeout", health.CheckTimeout, "how long to wait for the answer")
+	flag.Parse()
+
+	slog.SetDefault(helper.NewLogger(""))
+
+	conn, err := grpc.NewClient(*address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		helper.Fatal("can't connect", "address", *address, "error", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: *service})
+	if err != nil {
+		helper.Fatal("can't check health", "address", *address, "service", *service, "error", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		helper.Fatal("not serving", "service", *service, "status", resp.GetStatus().String())
+	}
+}
@@ -1,9 +1,12 @@
 package helper
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
@@ -13,11 +16,38 @@ type EnvConfig struct {
 	TokenSecret string
 	RabbitMQ    string
 	RedisSecret string
+	// ModeratorIDs lists the users allowed to review and resolve message reports.
+	ModeratorIDs []uuid.UUID
+	// ModerationConfig is the path to the JSON config of the moderation filters.
+	ModerationConfig string
+	// RateLimitConfig is the path to the JSON config of the per method rate limits.
+	RateLimitConfig string
+	// MessageRequestLimit is how many messages a user the receiver doesn't follow can send before
+	// the receiver accepts the message request.
+	MessageRequestLimit int32
+	// PinnedMessagesLimit is how many messages can be pinned in a conversation at the same time.
+	PinnedMessagesLimit int32
+	// MaxMessageRunes is the maximum length of message content in Unicode code points.
+	MaxMessageRunes int32
+	// MaxMessageBytes is the maximum size of message content in bytes.
+	MaxMessageBytes int32
+	// MetricsPort is the address the Prometheus metrics are served on.
+	MetricsPort string
+	// TracesExporter is where spans are sent: otlp, stdout or none.
+	TracesExporter string
+	// LogLevel is the lowest level that is logged: debug, info, warn or error.
+	LogLevel string
+	// EncryptionKeys are the key-encryption keys for message content in the id:base64key,... format.
+	EncryptionKeys string
+	// EncryptionCurrentKeyID is the key that wraps the data keys of new messages.
+	EncryptionCurrentKeyID string
+	// EncryptionKeysFile is the path to a JSON file with the keys, used instead of EncryptionKeys.
+	EncryptionKeysFile string
 }
 
 func GetENVSecrets() EnvConfig {
 	if err := godotenv.Load(".env"); err != nil {
-		log.Println("Error loading .env file")
+		slog.Warn("can't load .env file", "error", err)
 	}
 
 	config := EnvConfig{
@@ -26,23 +56,75 @@ func GetENVSecrets() EnvConfig {
 		TokenSecret: os.Getenv("TOKEN_SECRET"),
 		RabbitMQ:    os.Getenv("RABBITMQ_URL"),
 		RedisSecret: os.Getenv("REDIS_SECRET"),
+		LogLevel:    os.Getenv("LOG_LEVEL"),
+		MetricsPort: os.Getenv("METRICS_PORT"),
+
+		TracesExporter: os.Getenv("OTEL_TRACES_EXPORTER"),
+
+		ModerationConfig: os.Getenv("MODERATION_CONFIG"),
+		RateLimitConfig:  os.Getenv("RATE_LIMIT_CONFIG"),
+
+		EncryptionKeys:         os.Getenv("ENCRYPTION_KEYS"),
+		EncryptionCurrentKeyID: os.Getenv("ENCRYPTION_CURRENT_KEY_ID"),
+		EncryptionKeysFile:     os.Getenv("ENCRYPTION_KEYS_FILE"),
 	}
 
 	if config.Port == "" {
-		log.Fatalf("Set Port in env")
+		Fatal("set PORT in env")
 	}
 	if config.DBURL == "" {
-		log.Fatalf("Set db connection in env")
+		Fatal("set DB_URL in env")
 	}
 	if config.TokenSecret == "" {
-		log.Fatalf("Set token secret in env")
+		Fatal("set TOKEN_SECRET in env")
 	}
 	if config.RabbitMQ == "" {
-		log.Fatalf("Set redis password in .env file")
+		Fatal("set RABBITMQ_URL in env")
 	}
 	if config.RedisSecret == "" {
-		log.Fatalf("Set redis password in .env file")
+		Fatal("set REDIS_SECRET in env")
+	}
+
+	if config.MetricsPort == "" {
+		config.MetricsPort = ":9090"
 	}
 
+	config.ModeratorIDs = parseUUIDList(os.Getenv("MODERATOR_IDS"))
+	config.MessageRequestLimit = parseInt32(os.Getenv("MESSAGE_REQUEST_LIMIT"), 3)
+	config.PinnedMessagesLimit = parseInt32(os.Getenv("PINNED_MESSAGES_LIMIT"), 5)
+	config.MaxMessageRunes = parseInt32(os.Getenv("MAX_MESSAGE_RUNES"), 4096)
+	config.MaxMessageBytes = parseInt32(os.Getenv("MAX_MESSAGE_BYTES"), 16384)
+
 	return config
 }
+
+func parseUUIDList(value string) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		id, err := uuid.Parse(field)
+		if err != nil {
+			Fatal("invalid uuid in env", "value", field, "error", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func parseInt32(value string, defaultValue int32) int32 {
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		Fatal("invalid number in env", "value", value, "error", err)
+	}
+
+	return int32(parsed)
+}
@@ -0,0 +1,183 @@
+package helper
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// Reason is a stable, machine readable cause of an error. It is sent in the errdetails.ErrorInfo
+// of every error, so clients can branch on it instead of parsing the message.
+type Reason string
+
+// Generic reasons, used for errors that have no specific reason. They are named after the code.
+const (
+	ReasonInvalidArgument    Reason = "INVALID_ARGUMENT"
+	ReasonUnauthenticated    Reason = "UNAUTHENTICATED"
+	ReasonPermissionDenied   Reason = "PERMISSION_DENIED"
+	ReasonNotFound           Reason = "NOT_FOUND"
+	ReasonAlreadyExists      Reason = "ALREADY_EXISTS"
+	ReasonFailedPrecondition Reason = "FAILED_PRECONDITION"
+	ReasonAborted            Reason = "ABORTED"
+	ReasonResourceExhausted  Reason = "RESOURCE_EXHAUSTED"
+	ReasonInternal           Reason = "INTERNAL"
+	ReasonUnavailable        Reason = "UNAVAILABLE"
+	ReasonUnknown            Reason = "UNKNOWN"
+)
+
+// Specific reasons.
+const (
+	ReasonMessageNotFound            Reason = "MESSAGE_NOT_FOUND"
+	ReasonReceiverNotFound           Reason = "RECEIVER_NOT_FOUND"
+	ReasonReceiverBlocked            Reason = "RECEIVER_BLOCKED"
+	ReasonPrivacyRestricted          Reason = "PRIVACY_RESTRICTED"
+	ReasonMessageRequestDeclined     Reason = "MESSAGE_REQUEST_DECLINED"
+	ReasonMessageRequestLimitReached Reason = "MESSAGE_REQUEST_LIMIT_REACHED"
+	ReasonRateLimited                Reason = "RATE_LIMITED"
+	ReasonContentInvalid             Reason = "CONTENT_INVALID"
+	ReasonModerationRejected         Reason = "MODERATION_REJECTED"
+	ReasonPinnedMessagesLimitReached Reason = "PINNED_MESSAGES_LIMIT_REACHED"
+	ReasonPollClosed                 Reason = "POLL_CLOSED"
+)
+
+// errorDomain is the ErrorInfo domain of the errors of the service.
+const errorDomain = "message-service"
+
+// defaultLanguage is used when the caller accepts none of the languages of a message.
+const defaultLanguage = "en"
+
+// catalogEntry is the code and the user facing messages, by language, of a reason.
+type catalogEntry struct {
+	code     codes.Code
+	messages map[string]string
+}
+
+var errorCatalog = map[Reason]catalogEntry{
+	ReasonInvalidArgument: {codes.InvalidArgument, map[string]string{
+		"en": "The request is invalid.",
+		"ru": "Некорректный запрос.",
+	}},
+	ReasonUnauthenticated: {codes.Unauthenticated, map[string]string{
+		"en": "Please sign in again.",
+		"ru": "Пожалуйста, войдите снова.",
+	}},
+	ReasonPermissionDenied: {codes.PermissionDenied, map[string]string{
+		"en": "You aren't allowed to do this.",
+		"ru": "У вас нет прав на это действие.",
+	}},
+	ReasonNotFound: {codes.NotFound, map[string]string{
+		"en": "It doesn't exist anymore.",
+		"ru": "Это больше не существует.",
+	}},
+	ReasonAlreadyExists: {codes.AlreadyExists, map[string]string{
+		"en": "It's already done.",
+		"ru": "Это уже сделано.",
+	}},
+	ReasonFailedPrecondition: {codes.FailedPrecondition, map[string]string{
+		"en": "This can't be done right now.",
+		"ru": "Сейчас это невозможно.",
+	}},
+	ReasonAborted: {codes.Aborted, map[string]string{
+		"en": "Something changed at the same time, please try again.",
+		"ru": "Данные изменились одновременно с запросом, попробуйте ещё раз.",
+	}},
+	ReasonResourceExhausted: {codes.ResourceExhausted, map[string]string{
+		"en": "Please try again later.",
+		"ru": "Пожалуйста, попробуйте позже.",
+	}},
+	ReasonInternal: {codes.Internal, map[string]string{
+		"en": "Something went wrong, please try again.",
+		"ru": "Что-то пошло не так, попробуйте ещё раз.",
+	}},
+	ReasonUnavailable: {codes.Unavailable, map[string]string{
+		"en": "The service is unavailable, please try again later.",
+		"ru": "Сервис недоступен, попробуйте позже.",
+	}},
+	ReasonUnknown: {codes.Unknown, map[string]string{
+		"en": "Something went wrong, please try again.",
+		"ru": "Что-то пошло не так, попробуйте ещё раз.",
+	}},
+	ReasonMessageNotFound: {codes.NotFound, map[string]string{
+		"en": "This message doesn't exist anymore.",
+		"ru": "Это сообщение больше не существует.",
+	}},
+	ReasonReceiverNotFound: {codes.NotFound, map[string]string{
+		"en": "This user doesn't exist.",
+		"ru": "Такого пользователя не существует.",
+	}},
+	ReasonReceiverBlocked: {codes.PermissionDenied, map[string]string{
+		"en": "You can't message this user.",
+		"ru": "Вы не можете написать этому пользователю.",
+	}},
+	ReasonPrivacyRestricted: {codes.PermissionDenied, map[string]string{
+		"en": "This user doesn't accept messages from you.",
+		"ru": "Этот пользователь не принимает от вас сообщения.",
+	}},
+	ReasonMessageRequestDeclined: {codes.PermissionDenied, map[string]string{
+		"en": "This user declined your message request.",
+		"ru": "Этот пользователь отклонил ваш запрос на переписку.",
+	}},
+	ReasonMessageRequestLimitReached: {codes.FailedPrecondition, map[string]string{
+		"en": "Wait until this user accepts your message request.",
+		"ru": "Дождитесь, пока пользователь примет ваш запрос на переписку.",
+	}},
+	ReasonRateLimited: {codes.ResourceExhausted, map[string]string{
+		"en": "You're doing this too often, please try again later.",
+		"ru": "Слишком много запросов, попробуйте позже.",
+	}},
+	ReasonContentInvalid: {codes.InvalidArgument, map[string]string{
+		"en": "The message is empty or too long.",
+		"ru": "Сообщение пустое или слишком длинное.",
+	}},
+	ReasonModerationRejected: {codes.InvalidArgument, map[string]string{
+		"en": "The message can't be sent because it breaks the rules.",
+		"ru": "Сообщение нельзя отправить, потому что оно нарушает правила.",
+	}},
+	ReasonPinnedMessagesLimitReached: {codes.FailedPrecondition, map[string]string{
+		"en": "Unpin a message before pinning another one.",
+		"ru": "Открепите сообщение, прежде чем закреплять другое.",
+	}},
+	ReasonPollClosed: {codes.FailedPrecondition, map[string]string{
+		"en": "This poll is closed.",
+		"ru": "Этот опрос закрыт.",
+	}},
+}
+
+// codeReason returns the generic reason of the code, such as INVALID_ARGUMENT for InvalidArgument.
+func codeReason(code codes.Code) Reason {
+	var reason strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			reason.WriteByte('_')
+		}
+		reason.WriteRune(unicode.ToUpper(r))
+	}
+
+	return Reason(reason.String())
+}
+
+// localize returns the message of the reason in the first language of the caller's
+// accept-language header the catalog has, falling back to English.
+func localize(ctx context.Context, reason Reason) (string, string) {
+	entry, ok := errorCatalog[reason]
+	if !ok {
+		entry = errorCatalog[ReasonUnknown]
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range md.Get("accept-language") {
+		for _, tag := range strings.Split(header, ",") {
+			tag, _, _ = strings.Cut(tag, ";")
+			language, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+			language = strings.ToLower(language)
+			if message, ok := entry.messages[language]; ok {
+				return language, message
+			}
+		}
+	}
+
+	return defaultLanguage, entry.messages[defaultLanguage]
+}
@@ -0,0 +1,54 @@
+package helper
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/imhasandl/message-service/internal/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewLogger creates a logger that writes JSON records to stdout from the level on, which is one of
+// debug, info, warn and error, info when it is empty or unknown. Records logged with a context
+// that carries a request id get a request_id attribute, and a trace_id attribute when they are
+// logged within a span.
+func NewLogger(level string) *slog.Logger {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
+	return slog.New(requestIDHandler{handler})
+}
+
+// Fatal logs an error the service can't run with and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestIDHandler adds the request and trace ids of the context to the records.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
@@ -2,34 +2,112 @@ package helper
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"log/slog"
+	"maps"
+	"path"
 
+	"github.com/imhasandl/message-service/internal/requestid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
 )
 
 // RespondWithErrorGRPC creates a standardized gRPC error response with logging.
-// It logs the error details and returns a properly formatted gRPC error with the specified code and message.
+// The error carries the generic reason of the code, see RespondWithReasonGRPC.
 func RespondWithErrorGRPC(ctx context.Context, code codes.Code, msg string, err error) error {
-	if err != nil {
-		log.Println(err)
+	return respondWithStatus(ctx, code, codeReason(code), msg, err, nil)
+}
+
+// RespondWithReasonGRPC creates a gRPC error with the code of the reason from the error catalog.
+// Besides the message, the error carries details for clients: an errdetails.ErrorInfo with the
+// reason, an errdetails.LocalizedMessage to show the user and an errdetails.RequestInfo with the
+// request id.
+func RespondWithReasonGRPC(ctx context.Context, reason Reason, msg string, err error) error {
+	entry, ok := errorCatalog[reason]
+	if !ok {
+		entry = errorCatalog[ReasonUnknown]
 	}
 
-	if code > codes.Internal { // 5XX equivalent in gRPC
-		log.Printf("Responding with 5XX gRPC error: %s", msg)
+	return respondWithStatus(ctx, entry.code, reason, msg, err, nil)
+}
+
+// RespondWithReasonMetadataGRPC works like RespondWithReasonGRPC and also adds the metadata to the
+// errdetails.ErrorInfo, so clients can tell more about why the call failed.
+func RespondWithReasonMetadataGRPC(ctx context.Context, reason Reason, msg string, err error, metadata map[string]string) error {
+	entry, ok := errorCatalog[reason]
+	if !ok {
+		entry = errorCatalog[ReasonUnknown]
 	}
 
-	type errorResponse struct {
-		MessageServiceError string `json:"error"`
+	return respondWithStatus(ctx, entry.code, reason, msg, err, metadata)
+}
+
+// RespondWithBadRequestGRPC creates an InvalidArgument error that also carries the invalid fields
+// as google.rpc.BadRequest field violations, so clients can tell which field to fix and why.
+func RespondWithBadRequestGRPC(ctx context.Context, reason Reason, msg string, violations ...*errdetails.BadRequest_FieldViolation) error {
+	return respondWithStatus(ctx, codes.InvalidArgument, reason, msg, nil, nil, &errdetails.BadRequest{
+		FieldViolations: violations,
+	})
+}
+
+func respondWithStatus(ctx context.Context, code codes.Code, reason Reason, msg string, err error, metadata map[string]string, details ...protoadapt.MessageV1) error {
+	logError(ctx, code, reason, msg, err)
+
+	errorInfo := &errdetails.ErrorInfo{
+		Reason:   string(reason),
+		Domain:   errorDomain,
+		Metadata: maps.Clone(metadata),
+	}
+	if method, ok := grpc.Method(ctx); ok {
+		if errorInfo.Metadata == nil {
+			errorInfo.Metadata = make(map[string]string, 1)
+		}
+		errorInfo.Metadata["method"] = path.Base(method)
 	}
 
-	jsonBytes, err := json.Marshal(errorResponse{MessageServiceError: msg})
+	locale, localizedMessage := localize(ctx, reason)
+	details = append(details,
+		errorInfo,
+		&errdetails.LocalizedMessage{Locale: locale, Message: localizedMessage},
+		&errdetails.RequestInfo{RequestId: requestid.FromContext(ctx)},
+	)
+
+	st, detailsErr := status.New(code, msg).WithDetails(details...)
+	if detailsErr != nil {
+		slog.ErrorContext(ctx, "can't attach error details", "error", detailsErr)
+		return status.Error(code, msg)
+	}
+
+	return st.Err()
+}
+
+// logError logs the error once. The logging interceptor records the code of every call, so client
+// errors are only logged here when they have a cause the status doesn't carry.
+func logError(ctx context.Context, code codes.Code, reason Reason, msg string, err error) {
+	level := slog.LevelInfo
+	if IsServerError(code) {
+		level = slog.LevelError
+	} else if err == nil {
+		return
+	}
+
+	attrs := []any{"code", code.String(), "reason", string(reason)}
 	if err != nil {
-		log.Printf("Error marshalling error JSON: %s", err)
-		return status.Errorf(codes.Internal, "Failed to marshal error response")
+		attrs = append(attrs, "error", err)
 	}
 
-	log.Printf("MessageServiceError: %s, Code: %s", string(jsonBytes), code.String()) // Log the error
-	return status.Errorf(code, msg)
+	slog.Log(ctx, level, msg, attrs...)
+}
+
+// IsServerError reports whether the code is a 5XX equivalent, an error of the service rather than
+// of the request.
+func IsServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) BlockUser(ctx context.Context, req *pb.BlockUserRequest) (*pb.BlockUserResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - BlockUser", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - BlockUser", err)
+	}
+
+	blockedID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse user's id to uuid - BlockUser", err)
+	}
+
+	if blockedID == userID {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't block yourself - BlockUser", nil)
+	}
+
+	_, err = s.db.GetUserByID(ctx, blockedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "user not found - BlockUser", err)
+	}
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get user by id - BlockUser", err)
+	}
+
+	blockUserParams := database.BlockUserParams{
+		BlockerID: userID,
+		BlockedID: blockedID,
+	}
+
+	err = s.db.BlockUser(ctx, blockUserParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't block user via db - BlockUser", err)
+	}
+
+	redis.InvalidateBlockedUsers(ctx, userID.String())
+
+	return &pb.BlockUserResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) UnblockUser(ctx context.Context, req *pb.UnblockUserRequest) (*pb.UnblockUserResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - UnblockUser", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - UnblockUser", err)
+	}
+
+	blockedID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse user's id to uuid - UnblockUser", err)
+	}
+
+	unblockUserParams := database.UnblockUserParams{
+		BlockerID: userID,
+		BlockedID: blockedID,
+	}
+
+	err = s.db.UnblockUser(ctx, unblockUserParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't unblock user via db - UnblockUser", err)
+	}
+
+	redis.InvalidateBlockedUsers(ctx, userID.String())
+
+	return &pb.UnblockUserResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) ListBlockedUsers(ctx context.Context, req *pb.ListBlockedUsersRequest) (*pb.ListBlockedUsersResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ListBlockedUsers", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ListBlockedUsers", err)
+	}
+
+	blocks, err := s.getBlockedUsers(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get blocked users from db - ListBlockedUsers", err)
+	}
+
+	blockedUsers := make([]*pb.BlockedUser, len(blocks))
+	for i, block := range blocks {
+		blockedUsers[i] = &pb.BlockedUser{
+			UserId:    block.BlockedID.String(),
+			BlockedAt: timestamppb.New(block.BlockedAt),
+		}
+	}
+
+	return &pb.ListBlockedUsersResponse{
+		BlockedUsers: blockedUsers,
+	}, nil
+}
+
+// getBlockedUsers returns the block list of the given user, reading it from Redis
+// when possible and falling back to the database.
+func (s *server) getBlockedUsers(ctx context.Context, userID uuid.UUID) ([]database.UserBlock, error) {
+	var blocks []database.UserBlock
+	err := redis.GetCachedBlockedUsers(ctx, userID.String(), &blocks)
+	if err == nil {
+		return blocks, nil
+	}
+
+	blocks, err = s.db.ListBlockedUsers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	redis.CacheBlockedUsers(ctx, userID.String(), blocks)
+
+	return blocks, nil
+}
+
+// checkNotBlocked returns a PermissionDenied error when either user has blocked the other.
+// The same code is returned whichever side did the blocking, so the sender can't tell who blocked whom.
+func (s *server) checkNotBlocked(ctx context.Context, senderID, receiverID uuid.UUID, method string) error {
+	blocked, err := s.isBlockedBetween(ctx, senderID, receiverID)
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't check block list - "+method, err)
+	}
+	if blocked {
+		return helper.RespondWithReasonGRPC(ctx, helper.ReasonReceiverBlocked, "can't send message to this user - "+method, nil)
+	}
+
+	return nil
+}
+
+// isBlockedBetween reports whether either of the two users has blocked the other.
+func (s *server) isBlockedBetween(ctx context.Context, firstID, secondID uuid.UUID) (bool, error) {
+	for _, pair := range [][2]uuid.UUID{{firstID, secondID}, {secondID, firstID}} {
+		blocks, err := s.getBlockedUsers(ctx, pair[0])
+		if err != nil {
+			return false, err
+		}
+
+		for _, block := range blocks {
+			if block.BlockedID == pair[1] {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockLists answers ListBlockedUsers with the users each blocker has blocked.
+func blockLists(blocked map[uuid.UUID][]uuid.UUID) fakeAnswer {
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		var rows [][]driver.Value
+		for blockerID, blockedIDs := range blocked {
+			if args[0] != blockerID.String() {
+				continue
+			}
+			for _, blockedID := range blockedIDs {
+				rows = append(rows, []driver.Value{blockerID.String(), blockedID.String(), time.Now()})
+			}
+		}
+		return rows, nil
+	}
+}
+
+func TestCheckNotBlocked(t *testing.T) {
+	senderID := uuid.New()
+	receiverID := uuid.New()
+	otherID := uuid.New()
+
+	tests := []struct {
+		name       string
+		listBlocks fakeAnswer
+		wantCode   codes.Code
+		wantReason helper.Reason
+	}{
+		{
+			name:       "no blocks",
+			listBlocks: blockLists(nil),
+			wantCode:   codes.OK,
+		},
+		{
+			name:       "blocks of other users",
+			listBlocks: blockLists(map[uuid.UUID][]uuid.UUID{senderID: {otherID}, receiverID: {otherID}}),
+			wantCode:   codes.OK,
+		},
+		{
+			name:       "sender blocked receiver",
+			listBlocks: blockLists(map[uuid.UUID][]uuid.UUID{senderID: {receiverID}}),
+			wantCode:   codes.PermissionDenied,
+			wantReason: helper.ReasonReceiverBlocked,
+		},
+		{
+			name:       "receiver blocked sender",
+			listBlocks: blockLists(map[uuid.UUID][]uuid.UUID{receiverID: {senderID}}),
+			wantCode:   codes.PermissionDenied,
+			wantReason: helper.ReasonReceiverBlocked,
+		},
+		{
+			name:       "block list unavailable",
+			listBlocks: fails(errors.New("connection reset")),
+			wantCode:   codes.Internal,
+			wantReason: helper.ReasonInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, map[string]fakeAnswer{
+				"ListBlockedUsers": tt.listBlocks,
+			}, Options{})
+
+			err := s.checkNotBlocked(context.Background(), senderID, receiverID, "SendMessage")
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("checkNotBlocked() code = %v, want %v (error %v)", got, tt.wantCode, err)
+			}
+			if got := errorReason(err); err != nil && got != tt.wantReason {
+				t.Errorf("checkNotBlocked() reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+// The sender gets the same error whichever side blocked, so it can't tell who blocked whom.
+func TestCheckNotBlockedHidesBlocker(t *testing.T) {
+	senderID := uuid.New()
+	receiverID := uuid.New()
+
+	var messages []string
+	for _, blocked := range []map[uuid.UUID][]uuid.UUID{
+		{senderID: {receiverID}},
+		{receiverID: {senderID}},
+	} {
+		s, _ := newTestServer(t, map[string]fakeAnswer{
+			"ListBlockedUsers": blockLists(blocked),
+		}, Options{})
+
+		err := s.checkNotBlocked(context.Background(), senderID, receiverID, "SendMessage")
+		messages = append(messages, status.Convert(err).Message())
+	}
+
+	if messages[0] != messages[1] {
+		t.Errorf("checkNotBlocked() messages = %q and %q, want the same message", messages[0], messages[1])
+	}
+}
+
+func TestSendMessageBlocked(t *testing.T) {
+	sender := database.User{ID: uuid.New()}
+	receiver := database.User{ID: uuid.New()}
+
+	s, db := newTestServer(t, map[string]fakeAnswer{
+		"GetUserByID":      usersByID(sender, receiver),
+		"ListBlockedUsers": blockLists(map[uuid.UUID][]uuid.UUID{receiver.ID: {sender.ID}}),
+	}, Options{})
+
+	_, err := s.SendMessage(userContext(t, sender.ID), &pb.SendMessageRequest{
+		ReceiverId: receiver.ID.String(),
+		Content:    "hello",
+	})
+	if got := errorReason(err); got != helper.ReasonReceiverBlocked {
+		t.Fatalf("SendMessage() reason = %q, want %q (error %v)", got, helper.ReasonReceiverBlocked, err)
+	}
+	if db.ran("SendMessage") {
+		t.Error("SendMessage() stored the message of a blocked sender")
+	}
+}
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// minDisappearingTTL and maxDisappearingTTL bound the disappearing messages timer, in seconds.
+	minDisappearingTTL = 5
+	maxDisappearingTTL = 90 * 24 * 60 * 60
+	// reaperInterval is how often the reaper deletes expired messages.
+	reaperInterval = 10 * time.Second
+	// reaperBatchSize is how many expired messages a reaper run deletes per query.
+	reaperBatchSize = 500
+
+	// systemEventDisappearingTimer is posted to the conversation when its timer changes.
+	systemEventDisappearingTimer = "disappearing_timer_changed"
+)
+
+func (s *server) SetDisappearingMessages(ctx context.Context, req *pb.SetDisappearingMessagesRequest) (*pb.SetDisappearingMessagesResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - SetDisappearingMessages", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - SetDisappearingMessages", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - SetDisappearingMessages", err)
+	}
+
+	upsertDisappearingSettingsParams, err := disappearingSettingsParams(ctx, userID, partnerID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The change is posted into the conversation, so it needs the same permission as a message.
+	_, err = s.checkCanMessage(ctx, userID, partnerID, "SetDisappearingMessages")
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.db.UpsertDisappearingSettings(ctx, upsertDisappearingSettingsParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't update disappearing messages settings via db - SetDisappearingMessages", err)
+	}
+
+	redis.InvalidateConversationSettings(ctx, settings.UserLow.String(), settings.UserHigh.String())
+
+	err = s.postSystemMessage(ctx, userID, partnerID, systemEventDisappearingTimer, disappearingTimerText(settings))
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't post system message - SetDisappearingMessages", err)
+	}
+
+	return &pb.SetDisappearingMessagesResponse{
+		Settings: disappearingSettingsToProto(settings, userID),
+	}, nil
+}
+
+// disappearingSettingsParams validates the new timer. Without a mode messages disappear after they are sent.
+func disappearingSettingsParams(ctx context.Context, userID, partnerID uuid.UUID, req *pb.SetDisappearingMessagesRequest) (database.UpsertDisappearingSettingsParams, error) {
+	ttl := req.GetTtlSeconds()
+	if ttl != 0 && (ttl < minDisappearingTTL || ttl > maxDisappearingTTL) {
+		return database.UpsertDisappearingSettingsParams{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, fmt.Sprintf("ttl_seconds must be 0 or between %d and %d - SetDisappearingMessages", minDisappearingTTL, maxDisappearingTTL), nil)
+	}
+
+	mode := req.GetMode()
+	if mode == pb.DisappearingMode_DISAPPEARING_MODE_UNSPECIFIED {
+		mode = pb.DisappearingMode_DISAPPEARING_MODE_AFTER_SEND
+	}
+
+	userLow, userHigh := conversationKey(userID, partnerID)
+	return database.UpsertDisappearingSettingsParams{
+		UserLow:               userLow,
+		UserHigh:              userHigh,
+		DisappearAfterSeconds: ttl,
+		DisappearMode:         disappearingModeToString(mode),
+		UpdatedBy:             userID,
+	}, nil
+}
+
+func (s *server) GetDisappearingMessages(ctx context.Context, req *pb.GetDisappearingMessagesRequest) (*pb.GetDisappearingMessagesResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - GetDisappearingMessages", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - GetDisappearingMessages", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - GetDisappearingMessages", err)
+	}
+
+	settings, err := s.getConversationSettings(ctx, userID, partnerID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get disappearing messages settings from db - GetDisappearingMessages", err)
+	}
+
+	return &pb.GetDisappearingMessagesResponse{
+		Settings: disappearingSettingsToProto(settings, userID),
+	}, nil
+}
+
+func (s *server) MarkMessagesRead(ctx context.Context, req *pb.MarkMessagesReadRequest) (*pb.MarkMessagesReadResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - MarkMessagesRead", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - MarkMessagesRead", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - MarkMessagesRead", err)
+	}
+
+	markMessagesReadParams := database.MarkMessagesReadParams{
+		SenderID:   partnerID,
+		ReceiverID: userID,
+	}
+
+	// Reading starts the timer of messages that disappear after they are read.
+	readCount, err := s.db.MarkMessagesRead(ctx, markMessagesReadParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't mark messages as read - MarkMessagesRead", err)
+	}
+
+	if readCount > 0 {
+		invalidateConversationCaches(ctx, partnerID, userID)
+	}
+
+	return &pb.MarkMessagesReadResponse{
+		ReadCount: readCount,
+	}, nil
+}
+
+// getConversationSettings returns the settings of the conversation between the two users,
+// reading them from Redis when possible. Conversations without settings have the timer off.
+func (s *server) getConversationSettings(ctx context.Context, firstID, secondID uuid.UUID) (database.ConversationSetting, error) {
+	userLow, userHigh := conversationKey(firstID, secondID)
+
+	var settings database.ConversationSetting
+	err := redis.GetCachedConversationSettings(ctx, userLow.String(), userHigh.String(), &settings)
+	if err == nil {
+		return settings, nil
+	}
+
+	getConversationSettingsParams := database.GetConversationSettingsParams{
+		UserLow:  userLow,
+		UserHigh: userHigh,
+	}
+
+	settings, err = s.db.GetConversationSettings(ctx, getConversationSettingsParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		settings = database.ConversationSetting{
+			UserLow:       userLow,
+			UserHigh:      userHigh,
+			DisappearMode: disappearingModeToString(pb.DisappearingMode_DISAPPEARING_MODE_AFTER_SEND),
+		}
+	} else if err != nil {
+		return database.ConversationSetting{}, err
+	}
+
+	redis.CacheConversationSettings(ctx, userLow.String(), userHigh.String(), settings)
+
+	return settings, nil
+}
+
+// disappearingTimer is the disappearing messages timer a new message is stored with.
+type disappearingTimer struct {
+	ExpiresAt    sql.NullTime
+	AfterSeconds sql.NullInt32
+	AfterRead    bool
+}
+
+// newDisappearingTimer applies the conversation's timer to a new message. The timer of messages
+// that disappear after they are read starts in MarkMessagesRead, and the one of scheduled
+// messages starts when they are delivered.
+func newDisappearingTimer(settings database.ConversationSetting, scheduled bool, now time.Time) disappearingTimer {
+	if settings.DisappearAfterSeconds == 0 {
+		return disappearingTimer{}
+	}
+
+	timer := disappearingTimer{
+		AfterSeconds: sql.NullInt32{Int32: settings.DisappearAfterSeconds, Valid: true},
+		AfterRead:    disappearingModeFromString(settings.DisappearMode) == pb.DisappearingMode_DISAPPEARING_MODE_AFTER_READ,
+	}
+	if !timer.AfterRead && !scheduled {
+		ttl := time.Duration(settings.DisappearAfterSeconds) * time.Second
+		timer.ExpiresAt = sql.NullTime{Time: now.Add(ttl), Valid: true}
+	}
+
+	return timer
+}
+
+// postSystemMessage stores a message written by the service into the conversation between the
+// two users. System messages aren't moderated, don't count as message requests and don't
+// notify the receiver.
+func (s *server) postSystemMessage(ctx context.Context, senderID, receiverID uuid.UUID, event, content string) error {
+	messageID := uuid.New()
+	sealed, err := s.sealContent(ctx, messageID, content, json.RawMessage("{}"))
+	if err != nil {
+		return err
+	}
+
+	message, err := s.db.SendMessage(ctx, database.SendMessageParams{
+		ID:                messageID,
+		SenderID:          senderID,
+		ReceiverID:        receiverID,
+		Content:           sealed.Content,
+		EncryptionHeaders: json.RawMessage("{}"),
+		ContentCiphertext: sealed.Ciphertext,
+		ContentDataKey:    sealed.DataKey,
+		ContentKeyID:      sealed.KeyID,
+		SystemEvent:       sql.NullString{String: event, Valid: true},
+		Kind:              messageKindToString(pb.MessageKind_MESSAGE_KIND_SYSTEM),
+		Payload:           sealed.Payload,
+		PayloadCiphertext: sealed.PayloadCiphertext,
+	})
+	if err != nil {
+		return err
+	}
+
+	invalidateConversationCaches(ctx, senderID, receiverID)
+
+	redis.CacheLastMessage(ctx, senderID.String(), receiverID.String(), message)
+
+	return nil
+}
+
+// removeExpiredMessages drops the messages whose timer ran out and that the reaper hasn't
+// deleted yet, which matters for messages served from the Redis cache.
+func removeExpiredMessages(messages []database.Message, now time.Time) []database.Message {
+	visible := messages[:0]
+	for _, message := range messages {
+		if !isExpired(message, now) {
+			visible = append(visible, message)
+		}
+	}
+
+	return visible
+}
+
+func isExpired(message database.Message, now time.Time) bool {
+	return message.ExpiresAt.Valid && !message.ExpiresAt.Time.After(now)
+}
+
+// runReaper periodically deletes expired messages.
+func (s *server) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deleteExpiredMessages(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteExpiredMessages deletes expired messages from Postgres and evicts their conversations
+// from Redis. The rows are claimed with SKIP LOCKED, so several replicas can run the reaper.
+func (s *server) deleteExpiredMessages(ctx context.Context) {
+	for ctx.Err() == nil {
+		deleted, err := s.db.DeleteExpiredMessages(ctx, reaperBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "can't delete expired messages", "error", err)
+			return
+		}
+
+		evicted := make(map[[2]uuid.UUID]bool)
+		for _, message := range deleted {
+			pair := [2]uuid.UUID{message.SenderID, message.ReceiverID}
+			if !evicted[pair] {
+				invalidateConversationCaches(ctx, message.SenderID, message.ReceiverID)
+				evicted[pair] = true
+			}
+		}
+
+		if len(deleted) < reaperBatchSize {
+			return
+		}
+	}
+}
+
+// conversationKey orders the two user ids the way conversation_settings stores them.
+func conversationKey(firstID, secondID uuid.UUID) (uuid.UUID, uuid.UUID) {
+	if bytes.Compare(firstID[:], secondID[:]) < 0 {
+		return firstID, secondID
+	}
+
+	return secondID, firstID
+}
+
+// disappearingTimerText is the content of the system message posted when the timer changes.
+func disappearingTimerText(settings database.ConversationSetting) string {
+	if settings.DisappearAfterSeconds == 0 {
+		return "Disappearing messages were turned off"
+	}
+
+	return fmt.Sprintf("Disappearing messages were set to %s %s", formatTTL(settings.DisappearAfterSeconds), strings.ReplaceAll(settings.DisappearMode, "_", " "))
+}
+
+// formatTTL formats the timer with the largest unit that divides it, such as "1 day" or "90 seconds".
+func formatTTL(seconds int32) string {
+	units := []struct {
+		name    string
+		seconds int32
+	}{
+		{"day", 24 * 60 * 60},
+		{"hour", 60 * 60},
+		{"minute", 60},
+		{"second", 1},
+	}
+
+	for _, unit := range units {
+		if seconds%unit.seconds != 0 {
+			continue
+		}
+
+		count := seconds / unit.seconds
+		if count == 1 {
+			return fmt.Sprintf("1 %s", unit.name)
+		}
+		return fmt.Sprintf("%d %ss", count, unit.name)
+	}
+
+	return fmt.Sprintf("%d seconds", seconds)
+}
+
+func disappearingModeToString(mode pb.DisappearingMode) string {
+	return strings.ToLower(strings.TrimPrefix(mode.String(), "DISAPPEARING_MODE_"))
+}
+
+func disappearingModeFromString(mode string) pb.DisappearingMode {
+	return pb.DisappearingMode(pb.DisappearingMode_value["DISAPPEARING_MODE_"+strings.ToUpper(mode)])
+}
+
+func disappearingSettingsToProto(settings database.ConversationSetting, userID uuid.UUID) *pb.DisappearingSettings {
+	partnerID := settings.UserLow
+	if partnerID == userID {
+		partnerID = settings.UserHigh
+	}
+
+	settingsResponse := &pb.DisappearingSettings{
+		PartnerId:  partnerID.String(),
+		TtlSeconds: settings.DisappearAfterSeconds,
+		Mode:       disappearingModeFromString(settings.DisappearMode),
+	}
+	if settings.UpdatedBy != uuid.Nil {
+		settingsResponse.UpdatedBy = settings.UpdatedBy.String()
+	}
+	if !settings.UpdatedAt.IsZero() {
+		settingsResponse.UpdatedAt = timestamppb.New(settings.UpdatedAt)
+	}
+
+	return settingsResponse
+}
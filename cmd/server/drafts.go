@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maxDraftSize limits the size of a draft in bytes.
+	maxDraftSize = 64 << 10
+
+	defaultConversationsLimit = 50
+	maxConversationsLimit     = 200
+)
+
+func (s *server) SaveDraft(ctx context.Context, req *pb.SaveDraftRequest) (*pb.SaveDraftResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - SaveDraft", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - SaveDraft", err)
+	}
+
+	partnerID, err := s.parseDraftPartner(ctx, userID, req.GetPartnerId(), "SaveDraft")
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetVersion() <= 0 {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "version must be positive - SaveDraft", nil)
+	}
+	if len(req.GetContent()) > maxDraftSize {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "draft is too long - SaveDraft", nil)
+	}
+
+	sealed, err := s.sealText(ctx, req.GetContent(), draftAdditionalData(userID, partnerID))
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't encrypt draft - SaveDraft", err)
+	}
+
+	saveDraftParams := database.SaveDraftParams{
+		UserID:            userID,
+		PartnerID:         partnerID,
+		Content:           sealed.Content,
+		ContentCiphertext: sealed.Ciphertext,
+		ContentDataKey:    sealed.DataKey,
+		ContentKeyID:      sealed.KeyID,
+		Version:           req.GetVersion(),
+	}
+
+	// An older version than the stored one loses and the stored draft is returned instead.
+	applied := true
+	draft, err := s.db.SaveDraft(ctx, saveDraftParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		applied = false
+		draft, err = s.db.GetDraft(ctx, database.GetDraftParams{UserID: userID, PartnerID: partnerID})
+	}
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't save draft via db - SaveDraft", err)
+	}
+
+	redis.InvalidateDrafts(ctx, userID.String())
+
+	drafts := []database.Draft{draft}
+	err = s.openDrafts(ctx, drafts)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt draft - SaveDraft", err)
+	}
+
+	return &pb.SaveDraftResponse{
+		Draft:   draftToProto(drafts[0]),
+		Applied: applied,
+	}, nil
+}
+
+func (s *server) GetDraft(ctx context.Context, req *pb.GetDraftRequest) (*pb.GetDraftResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - GetDraft", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - GetDraft", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - GetDraft", err)
+	}
+
+	drafts, err := s.getDrafts(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get drafts from db - GetDraft", err)
+	}
+
+	index := slices.IndexFunc(drafts, func(draft database.Draft) bool {
+		return draft.PartnerID == partnerID
+	})
+	if index < 0 {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "draft not found - GetDraft", nil)
+	}
+
+	return &pb.GetDraftResponse{
+		Draft: draftToProto(drafts[index]),
+	}, nil
+}
+
+func (s *server) DeleteDraft(ctx context.Context, req *pb.DeleteDraftRequest) (*pb.DeleteDraftResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - DeleteDraft", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - DeleteDraft", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - DeleteDraft", err)
+	}
+
+	if req.GetVersion() <= 0 {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "version must be positive - DeleteDraft", nil)
+	}
+
+	deleteDraftParams := database.DeleteDraftParams{
+		UserID:    userID,
+		PartnerID: partnerID,
+		Version:   req.GetVersion(),
+	}
+
+	// A draft saved with a newer version from another device is kept.
+	deleted, err := s.db.DeleteDraft(ctx, deleteDraftParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't delete draft - DeleteDraft", err)
+	}
+
+	redis.InvalidateDrafts(ctx, userID.String())
+
+	return &pb.DeleteDraftResponse{
+		Success: deleted > 0,
+	}, nil
+}
+
+func (s *server) ListConversations(ctx context.Context, req *pb.ListConversationsRequest) (*pb.ListConversationsResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ListConversations", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ListConversations", err)
+	}
+
+	limit := req.GetLimit()
+	if limit <= 0 {
+		limit = defaultConversationsLimit
+	}
+	limit = min(limit, maxConversationsLimit)
+
+	conversations, err := s.getConversations(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get conversations from db - ListConversations", err)
+	}
+
+	drafts, err := s.getDrafts(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get drafts from db - ListConversations", err)
+	}
+
+	conversationsResponse, err := s.conversationsWithDrafts(ctx, conversations, drafts)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get draft partner's data by id - ListConversations", err)
+	}
+	if len(conversationsResponse) > int(limit) {
+		conversationsResponse = conversationsResponse[:limit]
+	}
+
+	return &pb.ListConversationsResponse{
+		Conversations: conversationsResponse,
+	}, nil
+}
+
+// conversationsWithDrafts attaches the drafts to their conversations. Drafts to users the user
+// hasn't talked to yet get their own conversation. The most recently active come first.
+func (s *server) conversationsWithDrafts(ctx context.Context, conversations []database.ListConversationsRow, drafts []database.Draft) ([]*pb.Conversation, error) {
+	draftsByPartner := make(map[uuid.UUID]database.Draft, len(drafts))
+	for _, draft := range drafts {
+		draftsByPartner[draft.PartnerID] = draft
+	}
+
+	conversationsResponse := make([]*pb.Conversation, 0, len(conversations)+len(drafts))
+	activity := make(map[*pb.Conversation]time.Time)
+	for _, conversation := range conversations {
+		conversationResponse := &pb.Conversation{
+			PartnerId:       conversation.PartnerID.String(),
+			PartnerUsername: conversation.PartnerUsername,
+			LastMessageAt:   timestamppb.New(conversation.LastMessageAt),
+		}
+		activity[conversationResponse] = conversation.LastMessageAt
+
+		if draft, ok := draftsByPartner[conversation.PartnerID]; ok {
+			conversationResponse.Draft = draftToProto(draft)
+			activity[conversationResponse] = latest(conversation.LastMessageAt, draft.UpdatedAt)
+			delete(draftsByPartner, conversation.PartnerID)
+		}
+		conversationsResponse = append(conversationsResponse, conversationResponse)
+	}
+
+	for _, draft := range draftsByPartner {
+		partner, err := s.getUser(ctx, draft.PartnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		conversationResponse := &pb.Conversation{
+			PartnerId:       draft.PartnerID.String(),
+			PartnerUsername: partner.Username,
+			Draft:           draftToProto(draft),
+		}
+		activity[conversationResponse] = draft.UpdatedAt
+		conversationsResponse = append(conversationsResponse, conversationResponse)
+	}
+
+	slices.SortStableFunc(conversationsResponse, func(a, b *pb.Conversation) int {
+		return activity[b].Compare(activity[a])
+	})
+
+	return conversationsResponse, nil
+}
+
+// getConversations returns the user's most recent conversations, reading them from Redis when
+// possible. The cache is cleared whenever a message is added to one of the conversations.
+func (s *server) getConversations(ctx context.Context, userID uuid.UUID) ([]database.ListConversationsRow, error) {
+	var conversations []database.ListConversationsRow
+	err := redis.GetCachedConversationList(ctx, userID.String(), &conversations)
+	if err == nil {
+		return conversations, nil
+	}
+
+	listConversationsParams := database.ListConversationsParams{
+		SenderID: userID,
+		Limit:    maxConversationsLimit,
+	}
+
+	conversations, err = s.db.ListConversations(ctx, listConversationsParams)
+	if err != nil {
+		return nil, err
+	}
+
+	redis.CacheConversationList(ctx, userID.String(), conversations)
+
+	return conversations, nil
+}
+
+// getDrafts returns the user's decrypted drafts, reading them from Redis when possible. Redis
+// caches the drafts as stored, so they are decrypted on every read.
+func (s *server) getDrafts(ctx context.Context, userID uuid.UUID) ([]database.Draft, error) {
+	var drafts []database.Draft
+	err := redis.GetCachedDrafts(ctx, userID.String(), &drafts)
+	if err != nil {
+		drafts, err = s.db.ListDrafts(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		redis.CacheDrafts(ctx, userID.String(), drafts)
+	}
+
+	err = s.openDrafts(ctx, drafts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted and cleared drafts are kept empty to hold their version, and aren't shown.
+	drafts = slices.DeleteFunc(drafts, func(draft database.Draft) bool {
+		return draft.Content == ""
+	})
+
+	return drafts, nil
+}
+
+// clearDraft empties the user's draft to the partner once a message to them was sent. The version
+// is moved past the last save, so a device still holding the draft can't save it again. A failure
+// only leaves a stale draft behind, so it doesn't fail the send.
+func (s *server) clearDraft(ctx context.Context, userID, partnerID uuid.UUID) {
+	clearDraftParams := database.ClearDraftParams{
+		UserID:    userID,
+		PartnerID: partnerID,
+	}
+
+	err := s.db.ClearDraft(ctx, clearDraftParams)
+	if err != nil {
+		slog.WarnContext(ctx, "can't clear draft", "user_id", userID, "partner_id", partnerID, "error", err)
+		return
+	}
+
+	redis.InvalidateDrafts(ctx, userID.String())
+}
+
+// parseDraftPartner parses the partner of a draft and checks that the user exists.
+func (s *server) parseDraftPartner(ctx context.Context, userID uuid.UUID, id, method string) (uuid.UUID, error) {
+	partnerID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - "+method, err)
+	}
+	if partnerID == userID {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't save a draft to yourself - "+method, nil)
+	}
+
+	_, err = s.getUser(ctx, partnerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "partner not found - "+method, err)
+	}
+	if err != nil {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get partner's data by id - "+method, err)
+	}
+
+	return partnerID, nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+func draftToProto(draft database.Draft) *pb.Draft {
+	return &pb.Draft{
+		PartnerId: draft.PartnerID.String(),
+		Content:   draft.Content,
+		Version:   draft.Version,
+		UpdatedAt: timestamppb.New(draft.UpdatedAt),
+	}
+}
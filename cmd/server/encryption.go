@@ -0,0 +1,429 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/encryption"
+	"github.com/imhasandl/message-service/internal/redis"
+)
+
+const (
+	// reencryptionInterval is how often the re-encryption job looks for rows under an old key.
+	reencryptionInterval = time.Minute
+	// reencryptionBatchSize is how many rows the re-encryption job rewrites per query.
+	reencryptionBatchSize = 100
+)
+
+var errEncryptionDisabled = errors.New("row is encrypted at rest but no encryption keys are configured")
+
+// sealedContent is the content and payload of a message, or a single value sealed with sealText,
+// as they are stored in Postgres.
+type sealedContent struct {
+	Content           string
+	Payload           json.RawMessage
+	Ciphertext        []byte
+	PayloadCiphertext []byte
+	DataKey           []byte
+	KeyID             sql.NullString
+}
+
+// sealContent encrypts the content and the payload of a message at rest with one data key. The
+// message id is used as additional data, so a ciphertext can't be moved to another row. Without
+// an encryptor both are stored as plaintext.
+func (s *server) sealContent(ctx context.Context, messageID uuid.UUID, content string, payload json.RawMessage) (sealedContent, error) {
+	if s.options.Encryptor == nil {
+		return sealedContent{Content: content, Payload: payload}, nil
+	}
+
+	dataKey, err := s.options.Encryptor.NewDataKey(ctx)
+	if err != nil {
+		return sealedContent{}, err
+	}
+
+	ciphertext, err := dataKey.Seal([]byte(content), messageID[:])
+	if err != nil {
+		return sealedContent{}, err
+	}
+
+	payloadCiphertext, err := dataKey.Seal(payload, payloadAdditionalData(messageID))
+	if err != nil {
+		return sealedContent{}, err
+	}
+
+	return sealedContent{
+		Payload:           json.RawMessage("{}"),
+		Ciphertext:        ciphertext,
+		PayloadCiphertext: payloadCiphertext,
+		DataKey:           dataKey.WrappedKey,
+		KeyID:             sql.NullString{String: dataKey.KeyID, Valid: true},
+	}, nil
+}
+
+// payloadAdditionalData binds the payload to its row and keeps it from being swapped with the
+// content, which is sealed with the same data key.
+func payloadAdditionalData(messageID uuid.UUID) []byte {
+	return append(messageID[:], "payload"...)
+}
+
+// openMessage decrypts the content and the payload of a message encrypted at rest. Rows written
+// before encryption at rest was enabled have no key id and are returned unchanged, and rows
+// sealed before payloads were encrypted keep their plaintext payload.
+func (s *server) openMessage(ctx context.Context, message *database.Message) error {
+	if !message.ContentKeyID.Valid {
+		return nil
+	}
+	if s.options.Encryptor == nil {
+		return errEncryptionDisabled
+	}
+
+	dataKey, err := s.options.Encryptor.OpenDataKey(ctx, message.ContentDataKey, message.ContentKeyID.String)
+	if err != nil {
+		return err
+	}
+
+	content, err := dataKey.Open(message.ContentCiphertext, message.ID[:])
+	if err != nil {
+		return err
+	}
+	message.Content = string(content)
+
+	if message.PayloadCiphertext != nil {
+		payload, err := dataKey.Open(message.PayloadCiphertext, payloadAdditionalData(message.ID))
+		if err != nil {
+			return err
+		}
+		message.Payload = payload
+	}
+
+	return nil
+}
+
+// sealText encrypts a single value at rest, such as a draft, with its own data key bound to
+// additionalData. Without an encryptor it is stored as plaintext.
+func (s *server) sealText(ctx context.Context, text string, additionalData []byte) (sealedContent, error) {
+	if s.options.Encryptor == nil {
+		return sealedContent{Content: text}, nil
+	}
+
+	envelope, err := s.options.Encryptor.Encrypt(ctx, []byte(text), additionalData)
+	if err != nil {
+		return sealedContent{}, err
+	}
+
+	return sealedContent{
+		Ciphertext: envelope.Ciphertext,
+		DataKey:    envelope.WrappedKey,
+		KeyID:      sql.NullString{String: envelope.KeyID, Valid: true},
+	}, nil
+}
+
+// openText decrypts a value sealed with sealText. A value stored without a key id is plaintext
+// and is returned unchanged.
+func (s *server) openText(ctx context.Context, text string, ciphertext, dataKey []byte, keyID sql.NullString, additionalData []byte) (string, error) {
+	if !keyID.Valid {
+		return text, nil
+	}
+	if s.options.Encryptor == nil {
+		return "", errEncryptionDisabled
+	}
+
+	plaintext, err := s.options.Encryptor.Decrypt(ctx, encryption.Envelope{
+		Ciphertext: ciphertext,
+		WrappedKey: dataKey,
+		KeyID:      keyID.String,
+	}, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// draftAdditionalData binds a draft to the user and the partner it belongs to.
+func draftAdditionalData(userID, partnerID uuid.UUID) []byte {
+	return append(append(userID[:], partnerID[:]...), "draft"...)
+}
+
+// openDrafts decrypts the content of every draft.
+func (s *server) openDrafts(ctx context.Context, drafts []database.Draft) error {
+	for i := range drafts {
+		draft := &drafts[i]
+		content, err := s.openText(ctx, draft.Content, draft.ContentCiphertext, draft.ContentDataKey, draft.ContentKeyID, draftAdditionalData(draft.UserID, draft.PartnerID))
+		if err != nil {
+			return err
+		}
+		draft.Content = content
+	}
+
+	return nil
+}
+
+// reportAdditionalData binds the content snapshot of a report to its row.
+func reportAdditionalData(reportID uuid.UUID) []byte {
+	return append(reportID[:], "report"...)
+}
+
+// openReports decrypts the content snapshot of every report.
+func (s *server) openReports(ctx context.Context, reports []database.Report) error {
+	for i := range reports {
+		report := &reports[i]
+		snapshot, err := s.openText(ctx, report.ContentSnapshot, report.ContentSnapshotCiphertext, report.ContentSnapshotDataKey, report.ContentSnapshotKeyID, reportAdditionalData(report.ID))
+		if err != nil {
+			return err
+		}
+		report.ContentSnapshot = snapshot
+	}
+
+	return nil
+}
+
+// openMessages decrypts the content of every message.
+func (s *server) openMessages(ctx context.Context, messages []database.Message) error {
+	for i := range messages {
+		if err := s.openMessage(ctx, &messages[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runReencryption periodically rewrites the rows stored as plaintext, under an old
+// key-encryption key or with a plaintext payload with the current key, so old keys can be retired after a rotation.
+func (s *server) runReencryption(ctx context.Context) {
+	if s.options.Encryptor == nil {
+		return
+	}
+
+	ticker := time.NewTicker(reencryptionInterval)
+	defer ticker.Stop()
+
+	for {
+		s.logReencryption(ctx, "messages", s.reencryptMessages)
+		s.logReencryption(ctx, "drafts", s.reencryptDrafts)
+		s.logReencryption(ctx, "report snapshots", s.reencryptReports)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reencryptMessages re-encrypts batches of rows under an old key, paging by id so every row is
+// read once per run. A row that can't be re-encrypted is logged and skipped, so it doesn't stop
+// the rotation of the rows after it; the next run tries it again.
+func (s *server) reencryptMessages(ctx context.Context) (int, error) {
+	currentKeyID := sql.NullString{String: s.options.Encryptor.CurrentKeyID(), Valid: true}
+
+	total := 0
+	lastID := uuid.Nil
+	for ctx.Err() == nil {
+		messages, err := s.db.ListMessagesToReencrypt(ctx, database.ListMessagesToReencryptParams{
+			ContentKeyID: currentKeyID,
+			ID:           lastID,
+			Limit:        reencryptionBatchSize,
+		})
+		if err != nil {
+			return total, err
+		}
+
+		for _, message := range messages {
+			lastID = message.ID
+
+			ok, err := s.reencryptMessage(ctx, message)
+			if err != nil {
+				slog.WarnContext(ctx, "can't re-encrypt message", "message_id", message.ID, "error", err)
+				continue
+			}
+			if ok {
+				total++
+			}
+		}
+
+		if len(messages) < reencryptionBatchSize {
+			break
+		}
+	}
+
+	return total, nil
+}
+
+// reencryptMessage rewrites one row with the current key. The update only applies when the
+// row still holds the content that was read, so a concurrent ChangeMessage is never undone. The
+// payload never changes after the message is sent, so it needs no such check.
+func (s *server) reencryptMessage(ctx context.Context, message database.Message) (bool, error) {
+	stored := message
+	if err := s.openMessage(ctx, &message); err != nil {
+		return false, err
+	}
+
+	sealed, err := s.sealContent(ctx, message.ID, message.Content, message.Payload)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := s.db.ReencryptMessage(ctx, database.ReencryptMessageParams{
+		ContentCiphertext:    sealed.Ciphertext,
+		ContentDataKey:       sealed.DataKey,
+		ContentKeyID:         sealed.KeyID,
+		Payload:              sealed.Payload,
+		PayloadCiphertext:    sealed.PayloadCiphertext,
+		ID:                   message.ID,
+		OldContent:           stored.Content,
+		OldContentCiphertext: stored.ContentCiphertext,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Cached rows still reference the old key, which would fail once it is retired.
+	redis.InvalidateMessagesCache(ctx, message.SenderID.String(), message.ReceiverID.String())
+	redis.InvalidateLastMessage(ctx, message.SenderID.String(), message.ReceiverID.String())
+
+	return rows > 0, nil
+}
+
+// logReencryption runs one pass of a re-encryption job and logs how it went.
+func (s *server) logReencryption(ctx context.Context, rows string, reencrypt func(context.Context) (int, error)) {
+	reencrypted, err := reencrypt(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "can't re-encrypt "+rows, "error", err)
+	} else if reencrypted > 0 {
+		slog.InfoContext(ctx, "re-encrypted "+rows, "count", reencrypted, "key_id", s.options.Encryptor.CurrentKeyID())
+	}
+}
+
+// reencryptDrafts re-encrypts batches of drafts under an old key, paging by their primary key
+// like reencryptMessages.
+func (s *server) reencryptDrafts(ctx context.Context) (int, error) {
+	currentKeyID := sql.NullString{String: s.options.Encryptor.CurrentKeyID(), Valid: true}
+
+	total := 0
+	var lastUserID, lastPartnerID uuid.UUID
+	for ctx.Err() == nil {
+		drafts, err := s.db.ListDraftsToReencrypt(ctx, database.ListDraftsToReencryptParams{
+			ContentKeyID: currentKeyID,
+			UserID:       lastUserID,
+			PartnerID:    lastPartnerID,
+			LimitCount:   reencryptionBatchSize,
+		})
+		if err != nil {
+			return total, err
+		}
+
+		for _, draft := range drafts {
+			lastUserID, lastPartnerID = draft.UserID, draft.PartnerID
+
+			ok, err := s.reencryptDraft(ctx, draft)
+			if err != nil {
+				slog.WarnContext(ctx, "can't re-encrypt draft", "user_id", draft.UserID, "partner_id", draft.PartnerID, "error", err)
+				continue
+			}
+			if ok {
+				total++
+			}
+		}
+
+		if len(drafts) < reencryptionBatchSize {
+			break
+		}
+	}
+
+	return total, nil
+}
+
+// reencryptDraft rewrites one draft with the current key. The update only applies when the draft
+// still has the version that was read, so a draft saved in the meantime is never undone.
+func (s *server) reencryptDraft(ctx context.Context, draft database.Draft) (bool, error) {
+	drafts := []database.Draft{draft}
+	if err := s.openDrafts(ctx, drafts); err != nil {
+		return false, err
+	}
+
+	sealed, err := s.sealText(ctx, drafts[0].Content, draftAdditionalData(draft.UserID, draft.PartnerID))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := s.db.ReencryptDraft(ctx, database.ReencryptDraftParams{
+		ContentCiphertext: sealed.Ciphertext,
+		ContentDataKey:    sealed.DataKey,
+		ContentKeyID:      sealed.KeyID,
+		UserID:            draft.UserID,
+		PartnerID:         draft.PartnerID,
+		Version:           draft.Version,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	redis.InvalidateDrafts(ctx, draft.UserID.String())
+
+	return rows > 0, nil
+}
+
+// reencryptReports re-encrypts batches of report content snapshots under an old key, paging by
+// id like reencryptMessages. A snapshot never changes after the report is created, so the update
+// needs no check against concurrent writes.
+func (s *server) reencryptReports(ctx context.Context) (int, error) {
+	currentKeyID := sql.NullString{String: s.options.Encryptor.CurrentKeyID(), Valid: true}
+
+	total := 0
+	lastID := uuid.Nil
+	for ctx.Err() == nil {
+		reports, err := s.db.ListReportsToReencrypt(ctx, database.ListReportsToReencryptParams{
+			ContentSnapshotKeyID: currentKeyID,
+			ID:                   lastID,
+			Limit:                reencryptionBatchSize,
+		})
+		if err != nil {
+			return total, err
+		}
+
+		for _, report := range reports {
+			lastID = report.ID
+
+			err := s.reencryptReport(ctx, report)
+			if err != nil {
+				slog.WarnContext(ctx, "can't re-encrypt report", "report_id", report.ID, "error", err)
+				continue
+			}
+			total++
+		}
+
+		if len(reports) < reencryptionBatchSize {
+			break
+		}
+	}
+
+	return total, nil
+}
+
+// reencryptReport rewrites the content snapshot of one report with the current key.
+func (s *server) reencryptReport(ctx context.Context, report database.Report) error {
+	reports := []database.Report{report}
+	if err := s.openReports(ctx, reports); err != nil {
+		return err
+	}
+
+	sealed, err := s.sealText(ctx, reports[0].ContentSnapshot, reportAdditionalData(report.ID))
+	if err != nil {
+		return err
+	}
+
+	return s.db.ReencryptReport(ctx, database.ReencryptReportParams{
+		ID:                        report.ID,
+		ContentSnapshotCiphertext: sealed.Ciphertext,
+		ContentSnapshotDataKey:    sealed.DataKey,
+		ContentSnapshotKeyID:      sealed.KeyID,
+	})
+}
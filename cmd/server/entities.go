@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/entities"
+	pb "github.com/imhasandl/message-service/protos"
+)
+
+const (
+	// maxMessageEntities limits how many entities are stored for a message.
+	maxMessageEntities = 100
+	// linkPreviewInterval is how often the worker looks for links without a preview.
+	linkPreviewInterval = 5 * time.Second
+	// linkPreviewBatchSize is how many links a worker run claims per query.
+	linkPreviewBatchSize = 20
+	// linkPreviewTimeout limits fetching a single link.
+	linkPreviewTimeout = 10 * time.Second
+
+	linkPreviewReady  = "ready"
+	linkPreviewFailed = "failed"
+)
+
+// storeEntities parses the mentions, links and hashtags of the message content and stores them.
+// Mentions are resolved against the users table and links are queued for the preview worker.
+func storeEntities(ctx context.Context, q *database.Queries, messageID uuid.UUID, content string) error {
+	parsed := entities.Parse(content)
+	if len(parsed) > maxMessageEntities {
+		parsed = parsed[:maxMessageEntities]
+	}
+
+	mentionedIDs, err := resolveMentions(ctx, q, parsed)
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range parsed {
+		createMessageEntityParams := database.CreateMessageEntityParams{
+			MessageID:   messageID,
+			StartOffset: int32(entity.Offset),
+			Length:      int32(entity.Length),
+			Kind:        string(entity.Kind),
+			Value:       entity.Value,
+		}
+		if entity.Kind == entities.Mention {
+			userID, found := mentionedIDs[entity.Value]
+			createMessageEntityParams.UserID = uuid.NullUUID{UUID: userID, Valid: found}
+		}
+
+		err = q.CreateMessageEntity(ctx, createMessageEntityParams)
+		if err != nil {
+			return err
+		}
+
+		if entity.Kind == entities.URL {
+			err = q.CreateLinkPreview(ctx, entity.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// replaceEntities stores the entities of changed message content instead of the previous ones.
+func replaceEntities(ctx context.Context, q *database.Queries, messageID uuid.UUID, content string) error {
+	err := q.DeleteMessageEntities(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	return storeEntities(ctx, q, messageID, content)
+}
+
+// resolveMentions returns the ids of the mentioned users by their username. Mentions of unknown
+// usernames are left out.
+func resolveMentions(ctx context.Context, q *database.Queries, parsed []entities.Entity) (map[string]uuid.UUID, error) {
+	var usernames []string
+	for _, entity := range parsed {
+		if entity.Kind == entities.Mention {
+			usernames = append(usernames, entity.Value)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	users, err := q.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	mentionedIDs := make(map[string]uuid.UUID, len(users))
+	for _, user := range users {
+		mentionedIDs[user.Username] = user.ID
+	}
+
+	return mentionedIDs, nil
+}
+
+// isMentioned reports whether the message mentions the user.
+func (s *server) isMentioned(ctx context.Context, messageID, userID uuid.UUID) (bool, error) {
+	messageEntities, err := s.db.ListMessageEntities(ctx, []uuid.UUID{messageID})
+	if err != nil {
+		return false, err
+	}
+
+	for _, entity := range messageEntities {
+		if entity.Kind == string(entities.Mention) && entity.UserID.Valid && entity.UserID.UUID == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// attachEntities sets the entities of the messages, with the previews of their links.
+func (s *server) attachEntities(ctx context.Context, messages []*pb.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*pb.Message, len(messages))
+	messageIDs := make([]uuid.UUID, 0, len(messages))
+	for _, message := range messages {
+		messageID, err := uuid.Parse(message.GetId())
+		if err != nil {
+			return err
+		}
+		byID[message.GetId()] = message
+		messageIDs = append(messageIDs, messageID)
+	}
+
+	messageEntities, err := s.db.ListMessageEntities(ctx, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range messageEntities {
+		message := byID[entity.MessageID.String()]
+		message.Entities = append(message.Entities, messageEntityToProto(entity))
+	}
+
+	return nil
+}
+
+// runLinkPreviews periodically builds the previews of new links. Without a fetcher links keep
+// no preview.
+func (s *server) runLinkPreviews(ctx context.Context) {
+	if s.options.LinkPreviewFetcher == nil {
+		return
+	}
+
+	ticker := time.NewTicker(linkPreviewInterval)
+	defer ticker.Stop()
+
+	for {
+		s.fetchLinkPreviews(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchLinkPreviews claims the links waiting for a preview and fetches them. The rows are claimed
+// with SKIP LOCKED, so every link is fetched by one replica, and links of a replica that stopped
+// while fetching are retried a few times.
+func (s *server) fetchLinkPreviews(ctx context.Context) {
+	for ctx.Err() == nil {
+		urls, err := s.db.ClaimLinkPreviews(ctx, linkPreviewBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "can't claim link previews", "error", err)
+			return
+		}
+
+		for _, url := range urls {
+			err := s.fetchLinkPreview(ctx, url)
+			if err != nil {
+				slog.ErrorContext(ctx, "can't store link preview", "url", url, "error", err)
+			}
+		}
+
+		if len(urls) < linkPreviewBatchSize {
+			return
+		}
+	}
+}
+
+func (s *server) fetchLinkPreview(ctx context.Context, url string) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, linkPreviewTimeout)
+	defer cancel()
+
+	updateLinkPreviewParams := database.UpdateLinkPreviewParams{
+		Url:    url,
+		Status: linkPreviewReady,
+	}
+
+	preview, err := s.options.LinkPreviewFetcher.Fetch(fetchCtx, url)
+	if err != nil {
+		updateLinkPreviewParams.Status = linkPreviewFailed
+	} else {
+		updateLinkPreviewParams.Title = preview.Title
+		updateLinkPreviewParams.Description = preview.Description
+		updateLinkPreviewParams.ImageUrl = preview.ImageURL
+	}
+
+	return s.db.UpdateLinkPreview(ctx, updateLinkPreviewParams)
+}
+
+func messageEntityToProto(entity database.ListMessageEntitiesRow) *pb.MessageEntity {
+	entityResponse := &pb.MessageEntity{
+		Kind:   entityKindToProto(entities.Kind(entity.Kind)),
+		Offset: entity.StartOffset,
+		Length: entity.Length,
+		Value:  entity.Value,
+	}
+	if entity.UserID.Valid {
+		entityResponse.UserId = entity.UserID.UUID.String()
+	}
+	if entity.PreviewStatus.String == linkPreviewReady {
+		entityResponse.Preview = &pb.LinkPreview{
+			Title:       entity.PreviewTitle.String,
+			Description: entity.PreviewDescription.String,
+			ImageUrl:    entity.PreviewImageUrl.String,
+		}
+	}
+
+	return entityResponse
+}
+
+func entityKindToProto(kind entities.Kind) pb.EntityKind {
+	switch kind {
+	case entities.Mention:
+		return pb.EntityKind_ENTITY_KIND_MENTION
+	case entities.URL:
+		return pb.EntityKind_ENTITY_KIND_URL
+	case entities.Hashtag:
+		return pb.EntityKind_ENTITY_KIND_HASHTAG
+	default:
+		return pb.EntityKind_ENTITY_KIND_UNSPECIFIED
+	}
+}
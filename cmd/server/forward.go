@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// maxForwardMessages limits how many messages a ForwardMessage call copies.
+	maxForwardMessages = 20
+	// maxForwardReceivers limits how many conversations a ForwardMessage call copies into.
+	maxForwardReceivers = 10
+)
+
+// forwardedFrom is the provenance a forwarded copy is stored with. A zero value stores a message
+// that isn't forwarded.
+type forwardedFrom struct {
+	SenderID uuid.NullUUID
+	SentAt   sql.NullTime
+}
+
+// forwardSource is a message the caller forwards together with its provenance.
+type forwardSource struct {
+	Message       database.Message
+	ForwardedFrom forwardedFrom
+}
+
+// ForwardMessage copies messages of the caller's conversations into the conversations with the
+// receivers. Every copy goes through the same checks, moderation and notification as a new message.
+// All sources and receivers are checked before the first copy is stored.
+func (s *server) ForwardMessage(ctx context.Context, req *pb.ForwardMessageRequest) (*pb.ForwardMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ForwardMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ForwardMessage", err)
+	}
+
+	if len(req.GetClientRequestId()) > maxClientMessageIDLength {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "client request id is too long - ForwardMessage", nil)
+	}
+
+	sources, err := s.forwardSources(ctx, userID, req.GetMessageIds())
+	if err != nil {
+		return nil, err
+	}
+
+	receivers, err := s.forwardReceivers(ctx, userID, req.GetReceiverIds())
+	if err != nil {
+		return nil, err
+	}
+
+	var messagesResponse []*pb.Message
+	for _, receiver := range receivers {
+		for _, source := range sources {
+			message, err := s.forwardCopy(ctx, userID, receiver, source, req.GetClientRequestId())
+			if err != nil {
+				return nil, err
+			}
+
+			messagesResponse = append(messagesResponse, messageToProto(message))
+		}
+	}
+
+	return &pb.ForwardMessageResponse{
+		Messages: messagesResponse,
+	}, nil
+}
+
+// forwardSources loads the messages to forward. The caller must take part in their conversations,
+// and only delivered messages with content the server can read are forwarded.
+func (s *server) forwardSources(ctx context.Context, userID uuid.UUID, ids []string) ([]forwardSource, error) {
+	if len(ids) == 0 || len(ids) > maxForwardMessages {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "message_ids must have 1 to 20 ids - ForwardMessage", nil)
+	}
+
+	sources := make([]forwardSource, len(ids))
+	for i, id := range ids {
+		messageID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - ForwardMessage", err)
+		}
+
+		message, err := s.getParticipantMessage(ctx, messageID, userID, "ForwardMessage")
+		if err != nil {
+			return nil, err
+		}
+
+		if message.SendAt.Valid || message.SystemEvent.Valid || message.IsEncrypted {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "scheduled, system and end-to-end encrypted messages can't be forwarded - ForwardMessage", nil)
+		}
+
+		forwarded, err := s.forwardedFrom(ctx, message)
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get original sender's privacy settings - ForwardMessage", err)
+		}
+
+		sources[i] = forwardSource{Message: message, ForwardedFrom: forwarded}
+	}
+
+	return sources, nil
+}
+
+// forwardedFrom returns the provenance of a copy of the message. Copies of forwarded messages keep
+// the provenance of the original, and senders that hide it are left out.
+func (s *server) forwardedFrom(ctx context.Context, message database.Message) (forwardedFrom, error) {
+	if message.ForwardedFromSentAt.Valid {
+		return forwardedFrom{
+			SenderID: message.ForwardedFromSenderID,
+			SentAt:   message.ForwardedFromSentAt,
+		}, nil
+	}
+
+	settings, err := s.getPrivacySettings(ctx, message.SenderID)
+	if err != nil {
+		return forwardedFrom{}, err
+	}
+
+	return forwardedFrom{
+		SenderID: uuid.NullUUID{UUID: message.SenderID, Valid: !settings.HideForwardSender},
+		SentAt:   sql.NullTime{Time: message.SentAt, Valid: true},
+	}, nil
+}
+
+// forwardReceivers parses the receivers and checks that the caller can message each of them.
+func (s *server) forwardReceivers(ctx context.Context, userID uuid.UUID, ids []string) ([]database.User, error) {
+	if len(ids) == 0 || len(ids) > maxForwardReceivers {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "receiver_ids must have 1 to 10 ids - ForwardMessage", nil)
+	}
+
+	seen := make(map[uuid.UUID]bool, len(ids))
+	receivers := make([]database.User, 0, len(ids))
+	for _, id := range ids {
+		receiverID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse receiver's id to uuid - ForwardMessage", err)
+		}
+		if seen[receiverID] {
+			continue
+		}
+		seen[receiverID] = true
+
+		receiver, err := s.checkCanMessage(ctx, userID, receiverID, "ForwardMessage")
+		if err != nil {
+			return nil, err
+		}
+
+		receivers = append(receivers, receiver)
+	}
+
+	return receivers, nil
+}
+
+// forwardCopy stores and announces the copy of the source for the receiver. With a client
+// request id every copy gets a client message id of its own, so a retried call returns the
+// copies stored by the first attempt and only stores the missing ones.
+func (s *server) forwardCopy(ctx context.Context, userID uuid.UUID, receiver database.User, source forwardSource, clientRequestID string) (database.Message, error) {
+	sendMessageRequest := forwardRequest(receiver.ID, source.Message)
+	if clientRequestID != "" {
+		sendMessageRequest.ClientMessageId = fmt.Sprintf("forward:%s:%s:%s", clientRequestID, receiver.ID, source.Message.ID)
+
+		message, found, err := s.replaySentMessage(ctx, userID, sendMessageRequest.GetClientMessageId(), "ForwardMessage")
+		if err != nil {
+			return database.Message{}, err
+		}
+		if found {
+			return message, nil
+		}
+	}
+
+	return s.sendMessage(ctx, userID, receiver, sendMessageRequest, source.ForwardedFrom, "ForwardMessage")
+}
+
+// forwardRequest builds the copy of the message sent to the receiver, with the kind and payload
+// of the original.
+func forwardRequest(receiverID uuid.UUID, message database.Message) *pb.SendMessageRequest {
+	original := messageToProto(message)
+	sendMessageRequest := &pb.SendMessageRequest{
+		ReceiverId: receiverID.String(),
+		Content:    message.Content,
+		Kind:       original.GetKind(),
+	}
+
+	switch {
+	case original.GetLocation() != nil:
+		sendMessageRequest.Payload = &pb.SendMessageRequest_Location{Location: original.GetLocation()}
+	case original.GetContact() != nil:
+		sendMessageRequest.Payload = &pb.SendMessageRequest_Contact{Contact: original.GetContact()}
+	case original.GetPoll() != nil:
+		sendMessageRequest.Payload = &pb.SendMessageRequest_Poll{Poll: original.GetPoll()}
+	}
+
+	return sendMessageRequest
+}
+
+// forwardedFromToProto returns the provenance of a forwarded message, or nil for other messages.
+func forwardedFromToProto(message database.Message) *pb.ForwardedFrom {
+	if !message.ForwardedFromSentAt.Valid {
+		return nil
+	}
+
+	forwardedFrom := &pb.ForwardedFrom{
+		SentAt: nullTimeToProto(message.ForwardedFromSentAt),
+	}
+	if message.ForwardedFromSenderID.Valid {
+		forwardedFrom.SenderId = message.ForwardedFromSenderID.UUID.String()
+	}
+
+	return forwardedFrom
+}
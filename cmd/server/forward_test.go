@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// messagesByID answers GetMessageByID with the message of the id, or no rows for unknown ids.
+func messagesByID(messages ...database.Message) fakeAnswer {
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		for _, message := range messages {
+			if args[0] == message.ID.String() {
+				return [][]driver.Value{messageRow(message)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// newIDs returns n random ids.
+func newIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+	return ids
+}
+
+func TestForwardMessageValidation(t *testing.T) {
+	caller := database.User{ID: uuid.New()}
+	partner := database.User{ID: uuid.New()}
+	receiver := database.User{ID: uuid.New()}
+	blocker := database.User{ID: uuid.New()}
+
+	conversationMessage := func() database.Message {
+		return database.Message{
+			ID:         uuid.New(),
+			SentAt:     time.Now().Add(-time.Hour),
+			SenderID:   partner.ID,
+			ReceiverID: caller.ID,
+			Content:    "hello",
+			Kind:       "text",
+		}
+	}
+	delivered := conversationMessage()
+	otherConversation := conversationMessage()
+	otherConversation.ReceiverID = receiver.ID
+	scheduled := conversationMessage()
+	scheduled.SenderID, scheduled.ReceiverID = caller.ID, partner.ID
+	scheduled.SendAt = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+	system := conversationMessage()
+	system.SystemEvent = sql.NullString{String: "disappearing_messages_changed", Valid: true}
+	encrypted := conversationMessage()
+	encrypted.IsEncrypted = true
+	encrypted.Content = ""
+
+	tests := []struct {
+		name        string
+		messageIDs  []string
+		receiverIDs []string
+		wantCode    codes.Code
+		wantReason  helper.Reason
+	}{
+		{
+			name:        "no messages",
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.InvalidArgument,
+		},
+		{
+			name:        "too many messages",
+			messageIDs:  newIDs(maxForwardMessages + 1),
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.InvalidArgument,
+		},
+		{
+			name:        "invalid message id",
+			messageIDs:  []string{"not-a-uuid"},
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.InvalidArgument,
+		},
+		{
+			name:        "unknown message",
+			messageIDs:  []string{uuid.NewString()},
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.NotFound,
+			wantReason:  helper.ReasonMessageNotFound,
+		},
+		{
+			name:        "message of another conversation",
+			messageIDs:  []string{otherConversation.ID.String()},
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.NotFound,
+			wantReason:  helper.ReasonMessageNotFound,
+		},
+		{
+			name:        "scheduled message",
+			messageIDs:  []string{scheduled.ID.String()},
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.FailedPrecondition,
+		},
+		{
+			name:        "system message",
+			messageIDs:  []string{system.ID.String()},
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.FailedPrecondition,
+		},
+		{
+			name:        "end-to-end encrypted message",
+			messageIDs:  []string{encrypted.ID.String()},
+			receiverIDs: []string{receiver.ID.String()},
+			wantCode:    codes.FailedPrecondition,
+		},
+		{
+			name:       "no receivers",
+			messageIDs: []string{delivered.ID.String()},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:        "too many receivers",
+			messageIDs:  []string{delivered.ID.String()},
+			receiverIDs: newIDs(maxForwardReceivers + 1),
+			wantCode:    codes.InvalidArgument,
+		},
+		{
+			name:        "unknown receiver",
+			messageIDs:  []string{delivered.ID.String()},
+			receiverIDs: []string{uuid.NewString()},
+			wantCode:    codes.NotFound,
+			wantReason:  helper.ReasonReceiverNotFound,
+		},
+		{
+			name:        "blocked by one receiver",
+			messageIDs:  []string{delivered.ID.String()},
+			receiverIDs: []string{receiver.ID.String(), blocker.ID.String()},
+			wantCode:    codes.PermissionDenied,
+			wantReason:  helper.ReasonReceiverBlocked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestServer(t, map[string]fakeAnswer{
+				"GetMessageByID":     messagesByID(delivered, otherConversation, scheduled, system, encrypted),
+				"GetUserByID":        usersByID(caller, partner, receiver, blocker),
+				"ListBlockedUsers":   blockLists(map[uuid.UUID][]uuid.UUID{blocker.ID: {caller.ID}}),
+				"GetPrivacySettings": returns(),
+			}, Options{})
+
+			_, err := s.ForwardMessage(userContext(t, caller.ID), &pb.ForwardMessageRequest{
+				MessageIds:  tt.messageIDs,
+				ReceiverIds: tt.receiverIDs,
+			})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("ForwardMessage() code = %v, want %v (error %v)", got, tt.wantCode, err)
+			}
+			if got := errorReason(err); tt.wantReason != "" && got != tt.wantReason {
+				t.Errorf("ForwardMessage() reason = %q, want %q", got, tt.wantReason)
+			}
+			// Every source and receiver is checked before the first copy is stored.
+			if db.ran("SendMessage") {
+				t.Error("ForwardMessage() stored a copy before failing")
+			}
+		})
+	}
+}
+
+func TestForwardedFrom(t *testing.T) {
+	originalSenderID := uuid.New()
+	sentAt := time.Now().Add(-time.Hour)
+	originalSentAt := sentAt.Add(-time.Hour)
+
+	tests := []struct {
+		name              string
+		message           database.Message
+		hideForwardSender bool
+		want              forwardedFrom
+	}{
+		{
+			name:    "original message",
+			message: database.Message{SenderID: originalSenderID, SentAt: sentAt},
+			want: forwardedFrom{
+				SenderID: uuid.NullUUID{UUID: originalSenderID, Valid: true},
+				SentAt:   sql.NullTime{Time: sentAt, Valid: true},
+			},
+		},
+		{
+			name:              "sender hides forwards",
+			message:           database.Message{SenderID: originalSenderID, SentAt: sentAt},
+			hideForwardSender: true,
+			want: forwardedFrom{
+				SentAt: sql.NullTime{Time: sentAt, Valid: true},
+			},
+		},
+		{
+			name: "forwarded message keeps the original provenance",
+			message: database.Message{
+				SenderID:              uuid.New(),
+				SentAt:                sentAt,
+				ForwardedFromSenderID: uuid.NullUUID{UUID: originalSenderID, Valid: true},
+				ForwardedFromSentAt:   sql.NullTime{Time: originalSentAt, Valid: true},
+			},
+			want: forwardedFrom{
+				SenderID: uuid.NullUUID{UUID: originalSenderID, Valid: true},
+				SentAt:   sql.NullTime{Time: originalSentAt, Valid: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, map[string]fakeAnswer{
+				"GetPrivacySettings": returns(privacySettingsRow(database.UserPrivacySetting{
+					UserID:            tt.message.SenderID,
+					MessagesFrom:      "everyone",
+					HideForwardSender: tt.hideForwardSender,
+				})),
+			}, Options{})
+
+			got, err := s.forwardedFrom(context.Background(), tt.message)
+			if err != nil {
+				t.Fatalf("forwardedFrom() error = %v", err)
+			}
+			// A hidden sender is stored as NULL, whatever id the invalid value holds.
+			if !got.SenderID.Valid {
+				got.SenderID.UUID = uuid.Nil
+			}
+			if got != tt.want {
+				t.Errorf("forwardedFrom() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A retried call with the same client request id returns the copies the first attempt stored.
+func TestForwardMessageRetry(t *testing.T) {
+	caller := database.User{ID: uuid.New()}
+	receiver := database.User{ID: uuid.New()}
+	source := database.Message{
+		ID:         uuid.New(),
+		SentAt:     time.Now().Add(-time.Hour),
+		SenderID:   caller.ID,
+		ReceiverID: uuid.New(),
+		Content:    "hello",
+		Kind:       "text",
+	}
+	clientMessageID := fmt.Sprintf("forward:req-1:%s:%s", receiver.ID, source.ID)
+	stored := database.Message{
+		ID:                    uuid.New(),
+		SentAt:                time.Now(),
+		SenderID:              caller.ID,
+		ReceiverID:            receiver.ID,
+		Content:               source.Content,
+		ClientMessageID:       sql.NullString{String: clientMessageID, Valid: true},
+		ForwardedFromSenderID: uuid.NullUUID{UUID: caller.ID, Valid: true},
+		ForwardedFromSentAt:   sql.NullTime{Time: source.SentAt, Valid: true},
+		Kind:                  "text",
+		NotifiedAt:            sql.NullTime{Time: time.Now(), Valid: true},
+	}
+
+	s, db := newTestServer(t, map[string]fakeAnswer{
+		"GetMessageByID":     messagesByID(source),
+		"GetUserByID":        usersByID(caller, receiver),
+		"ListBlockedUsers":   blockLists(nil),
+		"GetPrivacySettings": returns(),
+		"GetMessageByClientID": func(args []driver.Value) ([][]driver.Value, error) {
+			if args[1] != clientMessageID {
+				t.Errorf("GetMessageByClientID client message id = %v, want %v", args[1], clientMessageID)
+				return nil, nil
+			}
+			return [][]driver.Value{messageRow(stored)}, nil
+		},
+	}, Options{})
+
+	resp, err := s.ForwardMessage(userContext(t, caller.ID), &pb.ForwardMessageRequest{
+		MessageIds:      []string{source.ID.String()},
+		ReceiverIds:     []string{receiver.ID.String(), receiver.ID.String()},
+		ClientRequestId: "req-1",
+	})
+	if err != nil {
+		t.Fatalf("ForwardMessage() error = %v", err)
+	}
+
+	if len(resp.GetMessages()) != 1 || resp.GetMessages()[0].GetId() != stored.ID.String() {
+		t.Fatalf("ForwardMessage() messages = %v, want the stored copy %v", resp.GetMessages(), stored.ID)
+	}
+	if got := resp.GetMessages()[0].GetForwardedFrom().GetSenderId(); got != caller.ID.String() {
+		t.Errorf("ForwardMessage() forwarded from sender = %q, want %q", got, caller.ID)
+	}
+	if db.ran("SendMessage") {
+		t.Error("ForwardMessage() stored the copy again")
+	}
+}
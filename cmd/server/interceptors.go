@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/metrics"
+	"github.com/imhasandl/message-service/internal/ratelimit"
+	"github.com/imhasandl/message-service/internal/requestid"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewRequestIDInterceptor creates a unary interceptor that gives every call a request id, taken
+// from the x-request-id metadata or generated. The id is returned in the x-request-id header and
+// sent in the details of errors, so a failed call can be found in the logs.
+func NewRequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestID := requestid.FromIncoming(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, requestID))
+
+		return handler(requestid.NewContext(ctx, requestID), req)
+	}
+}
+
+// NewLoggingInterceptor creates a unary interceptor that logs every call with its method, caller,
+// duration and status code. Calls that failed with a server error are logged as errors. Health
+// checks aren't logged, probes make them every few seconds.
+func NewLoggingInterceptor(tokenSecret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		attrs := []any{
+			"method", path.Base(info.FullMethod),
+			"code", code.String(),
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if userID, ok := callerID(ctx, tokenSecret); ok {
+			attrs = append(attrs, "user_id", userID.String())
+		}
+		if err != nil {
+			attrs = append(attrs, "error", status.Convert(err).Message())
+		}
+
+		level := slog.LevelInfo
+		if helper.IsServerError(code) {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "grpc call", attrs...)
+
+		return resp, err
+	}
+}
+
+// NewMetricsInterceptor creates a unary interceptor that records the number and duration of
+// calls by method and status code.
+func NewMetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		metrics.ObserveGRPCRequest(path.Base(info.FullMethod), status.Code(err).String(), time.Since(start))
+
+		return resp, err
+	}
+}
+
+// NewMetricsStreamInterceptor creates a stream interceptor that counts the open streams and
+// records the number and duration of streams by method and status code.
+func NewMetricsStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		method := path.Base(info.FullMethod)
+		streamClosed := metrics.StreamOpened(method)
+		defer streamClosed()
+
+		start := time.Now()
+		err := handler(srv, stream)
+		metrics.ObserveGRPCRequest(method, status.Code(err).String(), time.Since(start))
+
+		return err
+	}
+}
+
+// NewRateLimitInterceptor creates a unary interceptor that throttles calls with the limiter.
+// Calls are counted globally, per caller and per caller and receiver pair, and throttled calls
+// get ResourceExhausted with the number of seconds to wait in the retry-after header.
+func NewRateLimitInterceptor(db *database.Queries, tokenSecret string, limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := path.Base(info.FullMethod)
+		if !limiter.Limits(method) {
+			return handler(ctx, req)
+		}
+
+		rateLimitRequest := ratelimit.Request{Method: method}
+		if userID, ok := callerID(ctx, tokenSecret); ok {
+			rateLimitRequest.CallerID = userID.String()
+			if user, err := lookupUser(ctx, db, userID); err == nil {
+				rateLimitRequest.Premium = user.IsPremium
+			}
+		}
+		if receiverRequest, ok := req.(interface{ GetReceiverId() string }); ok {
+			rateLimitRequest.ReceiverID = receiverRequest.GetReceiverId()
+		}
+
+		allowed, retryAfter, err := limiter.Allow(ctx, rateLimitRequest)
+		if err != nil {
+			// Throttling is a protection, not a dependency: a Redis failure lets the call through.
+			slog.WarnContext(ctx, "can't check rate limit", "method", method, "error", err)
+			return handler(ctx, req)
+		}
+		if !allowed {
+			retryAfterSeconds := int(math.Ceil(retryAfter.Seconds()))
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(retryAfterSeconds)))
+			return nil, helper.RespondWithReasonGRPC(ctx, helper.ReasonRateLimited, "rate limit exceeded, retry after "+strconv.Itoa(retryAfterSeconds)+"s - "+method, nil)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// NewPresenceInterceptor creates a unary interceptor that marks the caller of every
+// authenticated call as online and refreshes their last seen time.
+func NewPresenceInterceptor(tokenSecret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if userID, ok := callerID(ctx, tokenSecret); ok {
+			touchPresence(ctx, userID, "")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// callerID returns the id of the authenticated caller, if the call carries a valid token.
+func callerID(ctx context.Context, tokenSecret string) (uuid.UUID, bool) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return uuid.Nil, false
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, tokenSecret)
+	if err != nil {
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// RunBackgroundJobs runs the periodic jobs of the service until the context is cancelled.
+func (s *server) RunBackgroundJobs(ctx context.Context) {
+	jobs := []func(context.Context){
+		s.runReencryption,
+		s.runScheduler,
+		s.runReaper,
+		s.runLinkPreviews,
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job func(context.Context)) {
+			defer wg.Done()
+			job(ctx)
+		}(job)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+)
+
+// maxOneTimePreKeysPerRequest limits how many one-time prekeys a device uploads in one call.
+const maxOneTimePreKeysPerRequest = 100
+
+func (s *server) PublishDeviceKeys(ctx context.Context, req *pb.PublishDeviceKeysRequest) (*pb.PublishDeviceKeysResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - PublishDeviceKeys", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - PublishDeviceKeys", err)
+	}
+
+	deviceID, err := uuid.Parse(req.GetDeviceId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse device id - PublishDeviceKeys", err)
+	}
+
+	err = validateDeviceKeys(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkOwnDevice(ctx, deviceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	upsertDeviceIdentityKeyParams := database.UpsertDeviceIdentityKeyParams{
+		DeviceID:              deviceID,
+		UserID:                userID,
+		IdentityKey:           req.GetIdentityKey(),
+		SignedPrekeyID:        req.GetSignedPrekey().GetKeyId(),
+		SignedPrekey:          req.GetSignedPrekey().GetPublicKey(),
+		SignedPrekeySignature: req.GetSignedPrekey().GetSignature(),
+	}
+
+	_, err = s.db.UpsertDeviceIdentityKey(ctx, upsertDeviceIdentityKeyParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store identity key via db - PublishDeviceKeys", err)
+	}
+
+	err = s.addOneTimePreKeys(ctx, deviceID, req.GetOneTimePrekeys())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store one-time prekey via db - PublishDeviceKeys", err)
+	}
+
+	count, err := s.db.CountOneTimePrekeys(ctx, deviceID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't count one-time prekeys - PublishDeviceKeys", err)
+	}
+
+	return &pb.PublishDeviceKeysResponse{
+		OneTimePrekeyCount: count,
+	}, nil
+}
+
+func (s *server) GetPreKeyBundles(ctx context.Context, req *pb.GetPreKeyBundlesRequest) (*pb.GetPreKeyBundlesResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - GetPreKeyBundles", err)
+	}
+
+	callerID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - GetPreKeyBundles", err)
+	}
+
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse user's id to uuid - GetPreKeyBundles", err)
+	}
+
+	// Bundles are only handed out to users who may message the owner, so a blocked or
+	// restricted user can't drain the one-time prekeys. Users may always fetch their own.
+	if userID != callerID {
+		_, err = s.checkCanMessage(ctx, callerID, userID, "GetPreKeyBundles")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identityKeys, err := s.db.ListDeviceIdentityKeys(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get identity keys from db - GetPreKeyBundles", err)
+	}
+
+	bundles := make([]*pb.PreKeyBundle, len(identityKeys))
+	for i, identityKey := range identityKeys {
+		bundles[i] = &pb.PreKeyBundle{
+			DeviceId:    identityKey.DeviceID.String(),
+			IdentityKey: identityKey.IdentityKey,
+			SignedPrekey: &pb.SignedPreKey{
+				KeyId:     identityKey.SignedPrekeyID,
+				PublicKey: identityKey.SignedPrekey,
+				Signature: identityKey.SignedPrekeySignature,
+			},
+		}
+
+		// Every one-time prekey is handed out once, so concurrent fetches get different keys.
+		preKey, err := s.db.ClaimOneTimePrekey(ctx, identityKey.DeviceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't claim one-time prekey - GetPreKeyBundles", err)
+		}
+
+		bundles[i].OneTimePrekey = &pb.OneTimePreKey{
+			KeyId:     preKey.KeyID,
+			PublicKey: preKey.PublicKey,
+		}
+	}
+
+	return &pb.GetPreKeyBundlesResponse{
+		Bundles: bundles,
+	}, nil
+}
+
+func validateDeviceKeys(ctx context.Context, req *pb.PublishDeviceKeysRequest) error {
+	if len(req.GetIdentityKey()) == 0 || len(req.GetSignedPrekey().GetPublicKey()) == 0 || len(req.GetSignedPrekey().GetSignature()) == 0 {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "identity key and signed prekey are required - PublishDeviceKeys", nil)
+	}
+
+	if len(req.GetOneTimePrekeys()) > maxOneTimePreKeysPerRequest {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "too many one-time prekeys - PublishDeviceKeys", nil)
+	}
+
+	return nil
+}
+
+// checkOwnDevice makes sure keys are only published for a device registered by the caller.
+func (s *server) checkOwnDevice(ctx context.Context, deviceID, userID uuid.UUID) error {
+	deviceToken, err := s.db.GetDeviceToken(ctx, deviceID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && deviceToken.UserID != userID) {
+		return helper.RespondWithErrorGRPC(ctx, codes.NotFound, "device not found - PublishDeviceKeys", err)
+	}
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get device from db - PublishDeviceKeys", err)
+	}
+
+	return nil
+}
+
+func (s *server) addOneTimePreKeys(ctx context.Context, deviceID uuid.UUID, preKeys []*pb.OneTimePreKey) error {
+	for _, preKey := range preKeys {
+		addOneTimePrekeyParams := database.AddOneTimePrekeyParams{
+			DeviceID:  deviceID,
+			KeyID:     preKey.GetKeyId(),
+			PublicKey: preKey.GetPublicKey(),
+		}
+
+		err := s.db.AddOneTimePrekey(ctx, addOneTimePrekeyParams)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateEncryptedPayload checks that an end-to-end encrypted send carries a ciphertext and
+// device headers and no plaintext content.
+func validateEncryptedPayload(ctx context.Context, req *pb.SendMessageRequest) error {
+	payload := req.GetEncryptedPayload()
+	if payload == nil {
+		return nil
+	}
+
+	if req.GetContent() != "" {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "encrypted messages can't have plaintext content - SendMessage", nil)
+	}
+	if len(payload.GetCiphertext()) == 0 || len(payload.GetDeviceHeaders()) == 0 {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "encrypted payload needs a ciphertext and device headers - SendMessage", nil)
+	}
+
+	return nil
+}
+
+// encryptionHeaders marshals the device headers of the payload for the encryption_headers column.
+func encryptionHeaders(payload *pb.EncryptedPayload) (json.RawMessage, error) {
+	if payload == nil {
+		return json.RawMessage("{}"), nil
+	}
+
+	return json.Marshal(payload.GetDeviceHeaders())
+}
+
+// encryptedPayloadToProto converts the stored ciphertext and device headers back to the payload.
+func encryptedPayloadToProto(message database.Message) *pb.EncryptedPayload {
+	if !message.IsEncrypted {
+		return nil
+	}
+
+	// The headers are marshalled by SendMessage, so they are always valid JSON.
+	var deviceHeaders map[string][]byte
+	json.Unmarshal(message.EncryptionHeaders, &deviceHeaders)
+
+	return &pb.EncryptedPayload{
+		Ciphertext:    message.EncryptedPayload,
+		DeviceHeaders: deviceHeaders,
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+)
+
+func TestGetPreKeyBundlesChecksCaller(t *testing.T) {
+	caller := database.User{ID: uuid.New()}
+	owner := database.User{ID: uuid.New()}
+	blocker := database.User{ID: uuid.New()}
+	restricted := database.User{ID: uuid.New()}
+
+	tests := []struct {
+		name       string
+		userID     uuid.UUID
+		wantReason helper.Reason
+	}{
+		{name: "own bundles", userID: caller.ID},
+		{name: "user the caller may message", userID: owner.ID},
+		{name: "blocked by the owner", userID: blocker.ID, wantReason: helper.ReasonReceiverBlocked},
+		{name: "owner accepts no messages from the caller", userID: restricted.ID, wantReason: helper.ReasonPrivacyRestricted},
+		{name: "unknown owner", userID: uuid.New(), wantReason: helper.ReasonReceiverNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestServer(t, map[string]fakeAnswer{
+				"GetUserByID":      usersByID(caller, owner, blocker, restricted),
+				"ListBlockedUsers": blockLists(map[uuid.UUID][]uuid.UUID{blocker.ID: {caller.ID}}),
+				"GetPrivacySettings": usersPrivacySettings(database.UserPrivacySetting{
+					UserID:       restricted.ID,
+					MessagesFrom: "mutual",
+				}),
+				"ListDeviceIdentityKeys": returns(),
+			}, Options{})
+
+			_, err := s.GetPreKeyBundles(userContext(t, caller.ID), &pb.GetPreKeyBundlesRequest{
+				UserId: tt.userID.String(),
+			})
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("GetPreKeyBundles() error = %v", err)
+				}
+				return
+			}
+
+			if got := errorReason(err); got != tt.wantReason {
+				t.Fatalf("GetPreKeyBundles() reason = %q, want %q (error %v)", got, tt.wantReason, err)
+			}
+			if db.ran("ListDeviceIdentityKeys") {
+				t.Error("GetPreKeyBundles() read the bundles of a user the caller can't message")
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxLocationTextLength = 200
+	maxContactFieldLength = 100
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 100
+	minPollOptions        = 2
+	maxPollOptions        = 10
+)
+
+var (
+	htmlTagRegexp      = regexp.MustCompile(`</?[A-Za-z][^>]*>`)
+	markdownLinkRegexp = regexp.MustCompile(`\[[^\]]*\]\(([^)\s]*)\)`)
+	phoneRegexp        = regexp.MustCompile(`^\+?[0-9 ()-]{3,32}$`)
+)
+
+// validateMessageKind checks that the payload of a new message matches its kind and is valid.
+func validateMessageKind(ctx context.Context, req *pb.SendMessageRequest) error {
+	kind := sendMessageKind(req)
+	if kind == pb.MessageKind_MESSAGE_KIND_SYSTEM {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "system messages can't be sent - SendMessage", nil)
+	}
+	if kind != pb.MessageKind_MESSAGE_KIND_TEXT && req.GetEncryptedPayload() != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "end-to-end encrypted messages must be text - SendMessage", nil)
+	}
+	if payloadKind(req) != kind {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "payload doesn't match the message kind - SendMessage", nil)
+	}
+
+	var problem string
+	switch kind {
+	case pb.MessageKind_MESSAGE_KIND_MARKDOWN:
+		problem = markdownProblem(req.GetContent())
+	case pb.MessageKind_MESSAGE_KIND_LOCATION:
+		problem = locationProblem(req.GetLocation())
+	case pb.MessageKind_MESSAGE_KIND_CONTACT:
+		problem = contactProblem(req.GetContact())
+	case pb.MessageKind_MESSAGE_KIND_POLL:
+		problem = pollProblem(req.GetPoll())
+	}
+	if problem != "" {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, problem+" - SendMessage", nil)
+	}
+
+	return nil
+}
+
+// sendMessageKind returns the kind of a new message, which is text when it isn't specified.
+func sendMessageKind(req *pb.SendMessageRequest) pb.MessageKind {
+	if req.GetKind() == pb.MessageKind_MESSAGE_KIND_UNSPECIFIED {
+		return pb.MessageKind_MESSAGE_KIND_TEXT
+	}
+	return req.GetKind()
+}
+
+// payloadKind returns the kind the payload of the request belongs to. Requests without a payload
+// belong to the content only kinds, text and markdown.
+func payloadKind(req *pb.SendMessageRequest) pb.MessageKind {
+	switch req.GetPayload().(type) {
+	case *pb.SendMessageRequest_Location:
+		return pb.MessageKind_MESSAGE_KIND_LOCATION
+	case *pb.SendMessageRequest_Contact:
+		return pb.MessageKind_MESSAGE_KIND_CONTACT
+	case *pb.SendMessageRequest_Poll:
+		return pb.MessageKind_MESSAGE_KIND_POLL
+	}
+
+	if req.GetKind() == pb.MessageKind_MESSAGE_KIND_MARKDOWN {
+		return pb.MessageKind_MESSAGE_KIND_MARKDOWN
+	}
+	return pb.MessageKind_MESSAGE_KIND_TEXT
+}
+
+// markdownProblem describes why the content isn't in the supported markdown subset: bold, italic,
+// strikethrough, code and links. HTML and images aren't supported.
+func markdownProblem(content string) string {
+	if htmlTagRegexp.MatchString(content) {
+		return "markdown can't contain HTML"
+	}
+	if strings.Contains(content, "![") {
+		return "markdown can't contain images"
+	}
+	if strings.Count(content, "```")%2 != 0 {
+		return "markdown has an unclosed code block"
+	}
+
+	for _, match := range markdownLinkRegexp.FindAllStringSubmatch(content, -1) {
+		linkURL, err := url.Parse(match[1])
+		if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https" && linkURL.Scheme != "mailto") {
+			return "markdown links must be http, https or mailto links"
+		}
+	}
+
+	return ""
+}
+
+func locationProblem(location *pb.Location) string {
+	latitude, longitude := location.GetLatitude(), location.GetLongitude()
+	if math.IsNaN(latitude) || math.Abs(latitude) > 90 || math.IsNaN(longitude) || math.Abs(longitude) > 180 {
+		return "location must have a latitude between -90 and 90 and a longitude between -180 and 180"
+	}
+	if utf8.RuneCountInString(location.GetName()) > maxLocationTextLength || utf8.RuneCountInString(location.GetAddress()) > maxLocationTextLength {
+		return "location name and address must be at most 200 characters"
+	}
+
+	return ""
+}
+
+func contactProblem(contact *pb.ContactCard) string {
+	name := strings.TrimSpace(contact.GetName())
+	if name == "" || utf8.RuneCountInString(name) > maxContactFieldLength {
+		return "contact needs a name of at most 100 characters"
+	}
+	if contact.GetPhone() == "" && contact.GetEmail() == "" && contact.GetUserId() == "" {
+		return "contact needs a phone, an email or a user id"
+	}
+
+	return contactDetailsProblem(contact)
+}
+
+// contactDetailsProblem describes what is wrong with the ways to reach the contact.
+func contactDetailsProblem(contact *pb.ContactCard) string {
+	if contact.GetPhone() != "" && !phoneRegexp.MatchString(contact.GetPhone()) {
+		return "contact phone is invalid"
+	}
+	if contact.GetEmail() != "" && (len(contact.GetEmail()) > maxContactFieldLength || !strings.Contains(contact.GetEmail(), "@")) {
+		return "contact email is invalid"
+	}
+	if _, err := uuid.Parse(contact.GetUserId()); contact.GetUserId() != "" && err != nil {
+		return "contact user id is invalid"
+	}
+
+	return ""
+}
+
+func pollProblem(poll *pb.Poll) string {
+	question := strings.TrimSpace(poll.GetQuestion())
+	if question == "" || utf8.RuneCountInString(question) > maxPollQuestionLength {
+		return "poll needs a question of at most 300 characters"
+	}
+	if len(poll.GetOptions()) < minPollOptions || len(poll.GetOptions()) > maxPollOptions {
+		return "poll needs 2 to 10 options"
+	}
+
+	seen := make(map[string]bool, len(poll.GetOptions()))
+	for _, option := range poll.GetOptions() {
+		option = strings.TrimSpace(option)
+		if option == "" || utf8.RuneCountInString(option) > maxPollOptionLength {
+			return "poll options must have 1 to 100 characters"
+		}
+		if seen[option] {
+			return "poll options must be unique"
+		}
+		seen[option] = true
+	}
+
+	return ""
+}
+
+// messagePayload returns the stored kind and payload of a new message.
+func messagePayload(req *pb.SendMessageRequest) (string, json.RawMessage, error) {
+	var payload proto.Message
+	switch p := req.GetPayload().(type) {
+	case *pb.SendMessageRequest_Location:
+		payload = p.Location
+	case *pb.SendMessageRequest_Contact:
+		payload = p.Contact
+	case *pb.SendMessageRequest_Poll:
+		// Results are kept with the votes, only the definition of the poll is stored.
+		payload = &pb.Poll{
+			Question:       p.Poll.GetQuestion(),
+			Options:        p.Poll.GetOptions(),
+			MultipleChoice: p.Poll.GetMultipleChoice(),
+			Anonymous:      p.Poll.GetAnonymous(),
+		}
+	default:
+		return messageKindToString(sendMessageKind(req)), json.RawMessage("{}"), nil
+	}
+
+	data, err := protojson.Marshal(payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return messageKindToString(sendMessageKind(req)), data, nil
+}
+
+// setMessagePayload sets the kind and the typed payload of the message response. Rows stored
+// before message kinds existed are text, or system messages when they have a system event.
+func setMessagePayload(messageResponse *pb.Message, message database.Message) {
+	kind := messageKindFromString(message.Kind)
+	if message.SystemEvent.Valid {
+		kind = pb.MessageKind_MESSAGE_KIND_SYSTEM
+	}
+	messageResponse.Kind = kind
+
+	switch kind {
+	case pb.MessageKind_MESSAGE_KIND_LOCATION:
+		location := &pb.Location{}
+		if protojson.Unmarshal(message.Payload, location) == nil {
+			messageResponse.Payload = &pb.Message_Location{Location: location}
+		}
+	case pb.MessageKind_MESSAGE_KIND_CONTACT:
+		contact := &pb.ContactCard{}
+		if protojson.Unmarshal(message.Payload, contact) == nil {
+			messageResponse.Payload = &pb.Message_Contact{Contact: contact}
+		}
+	case pb.MessageKind_MESSAGE_KIND_POLL:
+		poll := &pb.Poll{}
+		if protojson.Unmarshal(message.Payload, poll) == nil {
+			messageResponse.Payload = &pb.Message_Poll{Poll: poll}
+		}
+	}
+}
+
+func messageKindToString(kind pb.MessageKind) string {
+	return strings.ToLower(strings.TrimPrefix(kind.String(), "MESSAGE_KIND_"))
+}
+
+func messageKindFromString(kind string) pb.MessageKind {
+	if kind == "" {
+		return pb.MessageKind_MESSAGE_KIND_TEXT
+	}
+	return pb.MessageKind(pb.MessageKind_value["MESSAGE_KIND_"+strings.ToUpper(kind)])
+}
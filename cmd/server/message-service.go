@@ -2,44 +2,100 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/content"
 	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/encryption"
+	"github.com/imhasandl/message-service/internal/linkpreview"
+	"github.com/imhasandl/message-service/internal/moderation"
 	"github.com/imhasandl/message-service/internal/rabbitmq"
 	"github.com/imhasandl/message-service/internal/redis"
 	pb "github.com/imhasandl/message-service/protos"
 	"github.com/imhasandl/post-service/cmd/auth"
 	postService "github.com/imhasandl/post-service/cmd/helper"
-	"github.com/streadway/amqp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// maxClientMessageIDLength limits the client supplied idempotency key of SendMessage.
+const maxClientMessageIDLength = 128
+
 // Server represents the gRPC server for the search service.
 type Server interface {
 	pb.MessageServiceServer
+	RunBackgroundJobs(ctx context.Context)
+}
+
+// Options holds the optional settings of the server.
+type Options struct {
+	// ModeratorIDs are the users allowed to list and resolve message reports.
+	ModeratorIDs []uuid.UUID
+	// Moderation checks every message before it is stored.
+	Moderation *moderation.Pipeline
+	// MessageRequestLimit is how many messages a user the receiver doesn't follow can send
+	// before the receiver accepts the message request.
+	MessageRequestLimit int32
+	// PinnedMessagesLimit is how many messages can be pinned in a conversation at the same time.
+	PinnedMessagesLimit int32
+	// Encryptor encrypts message content at rest. Without it content is stored as plaintext.
+	Encryptor *encryption.Encryptor
+	// ContentLimits bounds the length of message content.
+	ContentLimits content.Limits
+	// LinkPreviewFetcher builds the previews of links in messages. Without it links get no preview.
+	LinkPreviewFetcher linkpreview.Fetcher
 }
 
 type server struct {
 	pb.UnimplementedMessageServiceServer
 	db          *database.Queries
+	dbConn      *sql.DB
 	tokenSecret string
 	rabbitmq    *rabbitmq.RabbitMQ
+	options     Options
 }
 
 // NewServer creates and returns a new instance of the search service server.
-// It requires database queries implementation and a token secret for authentication.
-func NewServer(db *database.Queries, tokenSecret string, rabbitmq *rabbitmq.RabbitMQ) Server {
+// It requires the database connection, which transactions are started on, and a token secret
+// for authentication.
+func NewServer(dbConn *sql.DB, tokenSecret string, rabbitmq *rabbitmq.RabbitMQ, options Options) Server {
 	return &server{
 		pb.UnimplementedMessageServiceServer{},
-		db,
+		database.New(dbConn),
+		dbConn,
 		tokenSecret,
 		rabbitmq,
+		options,
 	}
 }
 
+// inTx runs fn with queries that run in one transaction, which is committed when fn returns nil
+// and rolled back otherwise.
+func (s *server) inTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := s.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = fn(s.db.WithTx(tx))
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			slog.WarnContext(ctx, "can't roll back transaction", "error", rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (s *server) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*pb.SendMessageResponse, error) {
 	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
 	if err != nil {
@@ -56,64 +112,425 @@ func (s *server) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*
 		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse receiver's id to uuid - SendMessage", err)
 	}
 
-	var senderUserData database.User
-	err = redis.GetCachedUser(userID.String(), &senderUserData)
+	err = s.validateSendMessageRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// A retried send returns the stored message before any check that could count the retry again.
+	message, found, err := s.replaySentMessage(ctx, userID, req.GetClientMessageId(), "SendMessage")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &pb.SendMessageResponse{
+			Success: true,
+			Message: messageToProto(message),
+		}, nil
+	}
+
+	receiver, err := s.checkCanMessage(ctx, userID, receiverID, "SendMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	message, err = s.sendMessage(ctx, userID, receiver, req, forwardedFrom{}, "SendMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	messageResponse := messageToProto(message)
+	err = s.attachDetails(ctx, userID, []*pb.Message{messageResponse}, "SendMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SendMessageResponse{
+		Success: true,
+		Message: messageResponse,
+	}, nil
+}
+
+// validateSendMessageRequest normalizes the content of a new message and checks its fields.
+// Content can only be empty when the message carries a payload or is end-to-end encrypted.
+func (s *server) validateSendMessageRequest(ctx context.Context, req *pb.SendMessageRequest) error {
+	if len(req.GetClientMessageId()) > maxClientMessageIDLength {
+		return helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "client message id is too long - SendMessage", nil)
+	}
+
+	normalized, err := s.normalizeContent(ctx, req.GetContent(), req.GetPayload() != nil || req.GetEncryptedPayload() != nil, "SendMessage")
+	if err != nil {
+		return err
+	}
+	req.Content = normalized
+
+	err = validateEncryptedPayload(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	err = validateMessageKind(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = parseSendAt(ctx, req.GetSendAt(), "SendMessage")
+	return err
+}
+
+// normalizeContent returns the normalized content when it is within the content limits, and an
+// InvalidArgument error with a field violation for the content otherwise.
+func (s *server) normalizeContent(ctx context.Context, messageContent string, allowBlank bool, method string) (string, error) {
+	normalized := content.Normalize(messageContent)
+
+	err := s.options.ContentLimits.Check(normalized, allowBlank)
+	if err != nil {
+		return "", helper.RespondWithBadRequestGRPC(ctx, helper.ReasonContentInvalid, "invalid message content - "+method, &errdetails.BadRequest_FieldViolation{
+			Field:       "content",
+			Description: err.Error(),
+		})
+	}
+
+	return normalized, nil
+}
+
+// sendMessage moderates, stores and announces a new message. A concurrent retry with the same
+// client message id returns the message stored by the first attempt without a second notification.
+func (s *server) sendMessage(ctx context.Context, userID uuid.UUID, receiver database.User, req *pb.SendMessageRequest, forwarded forwardedFrom, method string) (database.Message, error) {
+	receiverID := receiver.ID
+
+	moderationResult := s.moderateMessage(ctx, userID, receiverID, req.GetContent(), req.GetEncryptedPayload() != nil)
+	if moderationResult.Verdict == moderation.Reject {
+		return database.Message{}, moderationRejected(ctx, moderationResult, method)
+	}
+
+	needsRequest, err := s.needsMessageRequest(ctx, userID, receiver, method)
+	if err != nil {
+		return database.Message{}, err
+	}
+
+	sendMessageParams, err := s.sendMessageParams(ctx, userID, receiverID, req, forwarded, method)
+	if err != nil {
+		return database.Message{}, err
+	}
+
+	message, stored, isMessageRequest, err := s.storeMessage(ctx, sendMessageParams, req, needsRequest, method)
+	if err != nil || !stored {
+		return message, err
+	}
+
+	// Forwarding doesn't use the draft, so it's kept.
+	if !forwarded.SentAt.Valid {
+		s.clearDraft(ctx, userID, receiverID)
+	}
+
+	// The stored row holds the ciphertext, the response and the report need the plaintext.
+	storedMessage := message
+	err = s.openMessage(ctx, &message)
 	if err != nil {
-		senderUserData, err = s.db.GetUserByID(ctx, userID)
+		return database.Message{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt message - "+method, err)
+	}
+
+	if moderationResult.Verdict == moderation.Flag {
+		s.reportFlaggedMessage(ctx, message, moderationResult)
+	}
+
+	// Scheduled messages stay hidden until the scheduler delivers them.
+	if message.SendAt.Valid {
+		return message, nil
+	}
+
+	err = s.deliverMessage(ctx, storedMessage, isMessageRequest)
+	if err != nil {
+		return database.Message{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't deliver message - "+method, err)
+	}
+
+	return message, nil
+}
+
+// storeMessage inserts the message with the entities of its content and its poll in one
+// transaction, so a failed insert leaves nothing behind for a retry to find. A message that
+// needs a message request is counted against it in the same transaction and reports whether it
+// went to the requests inbox. When a concurrent retry with the same client message id stored it
+// first, the stored message is returned and stored is false.
+func (s *server) storeMessage(ctx context.Context, params database.SendMessageParams, req *pb.SendMessageRequest, needsRequest bool, method string) (message database.Message, stored, isMessageRequest bool, err error) {
+	err = s.inTx(ctx, func(q *database.Queries) error {
+		message, err = q.SendMessage(ctx, params)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
 		if err != nil {
-			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can;t get sender's data by id - SendMessage", err)
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't send message via db - "+method, err)
 		}
+		stored = true
 
-		redis.CacheUser(userID.String(), senderUserData)
+		if needsRequest {
+			isMessageRequest, err = s.recordMessageRequest(ctx, q, params.SenderID, params.ReceiverID, method)
+			if err != nil {
+				return err
+			}
+		}
+
+		return storeMessageDetails(ctx, q, message.ID, req, method)
+	})
+	if err != nil {
+		return database.Message{}, false, false, transactionError(ctx, err, method)
+	}
+	if stored {
+		return message, true, isMessageRequest, nil
 	}
 
-	sendMessageParams := database.SendMessageParams{
-		ID:         uuid.New(),
-		SenderID:   userID,
-		ReceiverID: receiverID,
-		Content:    req.GetContent(),
+	message, _, err = s.findSentMessage(ctx, params.SenderID, params.ClientMessageID.String)
+	if err != nil {
+		return database.Message{}, false, false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message by client id - "+method, err)
+	}
+	return message, false, false, nil
+}
+
+// storeMessageDetails stores the entities of the content and the poll of a new message. They are
+// taken from the request, as the stored row may hold them encrypted.
+func storeMessageDetails(ctx context.Context, q *database.Queries, messageID uuid.UUID, req *pb.SendMessageRequest, method string) error {
+	err := storeEntities(ctx, q, messageID, req.GetContent())
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store message entities - "+method, err)
 	}
 
-	message, err := s.db.SendMessage(ctx, sendMessageParams)
+	err = storePoll(ctx, q, messageID, req.GetPoll())
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't send message via db - SendMessage", err)
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store poll - "+method, err)
 	}
 
-	redis.InvalidateMessagesCache(userID.String(), receiverID.String())
-	redis.InvalidateConversationList(userID.String())
-	redis.InvalidateConversationList(receiverID.String())
-	redis.InvalidateLastMessage(userID.String(), receiverID.String())
-	redis.DeleteMessageCount(userID.String(), receiverID.String())
+	return nil
+}
+
+// transactionError returns the gRPC error of a failed transaction: the error the transaction
+// returned, or an Internal error when it couldn't begin or commit.
+func transactionError(ctx context.Context, err error, method string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store message via db - "+method, err)
+}
 
-	redis.CacheLastMessage(userID.String(), receiverID.String(), message)
+// deliverMessage makes a stored message visible in the conversation, notifies the receiver and
+// records that the notification was published.
+func (s *server) deliverMessage(ctx context.Context, message database.Message, isMessageRequest bool) error {
+	refreshConversationCaches(ctx, message)
 
-	messageJSON, err := json.Marshal(map[string]interface{}{
-		"title":           "New Notification",
-		"sender_username": senderUserData.Username,
-		"receiver_id":     receiverID.String(),
-		"content":         req.GetContent(),
+	err := s.announceMessage(ctx, message, isMessageRequest)
+	if err != nil {
+		return err
+	}
+
+	// Until this is recorded a retry of the send publishes the notification again.
+	err = s.db.MarkMessageNotified(ctx, message.ID)
+	if err != nil {
+		slog.WarnContext(ctx, "can't mark message notified", "message_id", message.ID, "error", err)
+	}
+
+	return nil
+}
+
+// refreshConversationCaches invalidates the conversation caches for a message that became
+// visible and caches it as the last message of the conversation.
+func refreshConversationCaches(ctx context.Context, message database.Message) {
+	invalidateConversationCaches(ctx, message.SenderID, message.ReceiverID)
+
+	redis.CacheLastMessage(ctx, message.SenderID.String(), message.ReceiverID.String(), message)
+}
+
+// announceMessage publishes the notification of a stored message to the receiver.
+func (s *server) announceMessage(ctx context.Context, message database.Message, isMessageRequest bool) error {
+	sender, err := s.getUser(ctx, message.SenderID)
+	if err != nil {
+		return fmt.Errorf("can't get sender's data by id: %w", err)
+	}
+
+	err = s.openMessage(ctx, &message)
+	if err != nil {
+		return err
+	}
+
+	mentioned, err := s.isMentioned(ctx, message.ID, message.ReceiverID)
+	if err != nil {
+		return fmt.Errorf("can't get message entities: %w", err)
+	}
+
+	return s.notifyReceiver(ctx, message, sender.Username, isMessageRequest, mentioned)
+}
+
+// sendMessageParams builds the row of a new message with its content encrypted at rest.
+func (s *server) sendMessageParams(ctx context.Context, userID, receiverID uuid.UUID, req *pb.SendMessageRequest, forwarded forwardedFrom, method string) (database.SendMessageParams, error) {
+	headers, err := encryptionHeaders(req.GetEncryptedPayload())
+	if err != nil {
+		return database.SendMessageParams{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't marshal encryption headers - "+method, err)
+	}
+
+	sendAt, err := parseSendAt(ctx, req.GetSendAt(), method)
+	if err != nil {
+		return database.SendMessageParams{}, err
+	}
+
+	kind, payload, err := messagePayload(req)
+	if err != nil {
+		return database.SendMessageParams{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't marshal message payload - "+method, err)
+	}
+
+	settings, err := s.getConversationSettings(ctx, userID, receiverID)
+	if err != nil {
+		return database.SendMessageParams{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get disappearing messages settings - "+method, err)
+	}
+	timer := newDisappearingTimer(settings, sendAt.Valid, time.Now())
+
+	// End-to-end encrypted messages have no content to encrypt at rest.
+	messageID := uuid.New()
+	sealed := sealedContent{Payload: payload}
+	if req.GetEncryptedPayload() == nil {
+		sealed, err = s.sealContent(ctx, messageID, req.GetContent(), payload)
+		if err != nil {
+			return database.SendMessageParams{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't encrypt message content - "+method, err)
+		}
+	}
+
+	return database.SendMessageParams{
+		ID:                    messageID,
+		SenderID:              userID,
+		ReceiverID:            receiverID,
+		Content:               sealed.Content,
+		ClientMessageID:       sql.NullString{String: req.GetClientMessageId(), Valid: req.GetClientMessageId() != ""},
+		IsEncrypted:           req.GetEncryptedPayload() != nil,
+		EncryptedPayload:      req.GetEncryptedPayload().GetCiphertext(),
+		EncryptionHeaders:     headers,
+		ContentCiphertext:     sealed.Ciphertext,
+		ContentDataKey:        sealed.DataKey,
+		ContentKeyID:          sealed.KeyID,
+		SendAt:                sendAt,
+		ExpiresAt:             timer.ExpiresAt,
+		DisappearAfterSeconds: timer.AfterSeconds,
+		DisappearAfterRead:    timer.AfterRead,
+		ForwardedFromSenderID: forwarded.SenderID,
+		ForwardedFromSentAt:   forwarded.SentAt,
+		Kind:                  kind,
+		Payload:               sealed.Payload,
+		PayloadCiphertext:     sealed.PayloadCiphertext,
+	}, nil
+}
+
+// moderateMessage runs the moderation pipeline on the message content. End-to-end encrypted
+// messages have no content the server can read, so they are explicitly not moderated.
+func (s *server) moderateMessage(ctx context.Context, userID, receiverID uuid.UUID, content string, encrypted bool) moderation.Result {
+	if encrypted {
+		return moderation.Result{Verdict: moderation.Allow}
+	}
+
+	return s.options.Moderation.Run(ctx, moderation.Input{
+		SenderID:   userID,
+		ReceiverID: receiverID,
+		Content:    content,
+	})
+}
+
+// moderationRejected returns the error for content rejected by moderation. The reason and the
+// filter that rejected it are added to the error metadata, so clients can explain the rejection.
+func moderationRejected(ctx context.Context, result moderation.Result, method string) error {
+	return helper.RespondWithReasonMetadataGRPC(ctx, helper.ReasonModerationRejected, fmt.Sprintf("message rejected by moderation: %s - %s", result.Reason, method), nil, map[string]string{
+		"moderation_reason": result.Reason,
+		"filter":            result.Filter,
+	})
+}
+
+// notifyReceiver publishes the new message notification. Messages waiting in the receiver's
+// requests inbox use their own routing key, so they can be delivered with a lower priority, and
+// messages mentioning the receiver use the high priority mention routing key.
+func (s *server) notifyReceiver(ctx context.Context, message database.Message, senderUsername string, isMessageRequest, mentioned bool) error {
+	title, routingKey := "New Notification", rabbitmq.RoutingKey
+	switch {
+	case isMessageRequest:
+		title, routingKey = "New Message Request", rabbitmq.MessageRequestRoutingKey
+	case mentioned:
+		title, routingKey = "New Mention", rabbitmq.MentionRoutingKey
+	}
+
+	return s.rabbitmq.PublishJSON(ctx, routingKey, map[string]interface{}{
+		"title":           title,
+		"sender_username": senderUsername,
+		"receiver_id":     message.ReceiverID.String(),
+		"content":         message.Content,
+		"encrypted":       message.IsEncrypted,
+		"forwarded":       message.ForwardedFromSentAt.Valid,
 		"sent_at":         message.SentAt,
 	})
+}
+
+// findSentMessage looks up a message the sender already stored with the client message id.
+func (s *server) findSentMessage(ctx context.Context, senderID uuid.UUID, clientMessageID string) (database.Message, bool, error) {
+	message, found, err := s.findStoredMessage(ctx, senderID, clientMessageID)
+	if err != nil || !found {
+		return database.Message{}, false, err
+	}
+
+	err = s.openMessage(ctx, &message)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't marshal message to JSON - SendMessage", err)
+		return database.Message{}, false, err
 	}
 
-	err = s.rabbitmq.Channel.Publish(
-		rabbitmq.ExchangeName, // exchange
-		rabbitmq.RoutingKey,   // routing key
-		false,                 // mandatory
-		false,                 // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        messageJSON,
-		})
+	return message, true, nil
+}
+
+// findStoredMessage looks up a message the sender already stored with the client message id, as
+// it is stored.
+func (s *server) findStoredMessage(ctx context.Context, senderID uuid.UUID, clientMessageID string) (database.Message, bool, error) {
+	if clientMessageID == "" {
+		return database.Message{}, false, nil
+	}
+
+	getMessageByClientIDParams := database.GetMessageByClientIDParams{
+		SenderID:        senderID,
+		ClientMessageID: sql.NullString{String: clientMessageID, Valid: true},
+	}
+
+	message, err := s.db.GetMessageByClientID(ctx, getMessageByClientIDParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Message{}, false, nil
+	}
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't publish message to RabbitMQ - SendMessage", err)
+		return database.Message{}, false, err
 	}
 
-	return &pb.SendMessageResponse{
-		Success: true,
-	}, nil
+	return message, true, nil
+}
+
+// replaySentMessage returns the message a retried send stored before. When the attempt that
+// stored it failed after the commit, before the notification was published, the message is
+// delivered again, so a retry always ends with the receiver notified. Scheduled messages are
+// left to the scheduler.
+func (s *server) replaySentMessage(ctx context.Context, senderID uuid.UUID, clientMessageID, method string) (database.Message, bool, error) {
+	message, found, err := s.findStoredMessage(ctx, senderID, clientMessageID)
+	if err != nil {
+		return database.Message{}, false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message by client id - "+method, err)
+	}
+	if !found {
+		return database.Message{}, false, nil
+	}
+
+	if !message.NotifiedAt.Valid && !message.SendAt.Valid {
+		isMessageRequest, err := s.isPendingMessageRequest(ctx, message.SenderID, message.ReceiverID)
+		if err != nil {
+			return database.Message{}, false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message request - "+method, err)
+		}
+
+		err = s.deliverMessage(ctx, message, isMessageRequest)
+		if err != nil {
+			return database.Message{}, false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't deliver message - "+method, err)
+		}
+	}
+
+	err = s.openMessage(ctx, &message)
+	if err != nil {
+		return database.Message{}, false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt message - "+method, err)
+	}
+
+	return message, true, nil
 }
 
 func (s *server) GetMessages(ctx context.Context, req *pb.GetMessagesRequest) (*pb.GetMessagesResponse, error) {
@@ -133,7 +550,7 @@ func (s *server) GetMessages(ctx context.Context, req *pb.GetMessagesRequest) (*
 	}
 
 	var messages []database.Message
-	err = redis.GetCachedMessages(userID.String(), receiverID.String(), &messages)
+	err = redis.GetCachedMessages(ctx, userID.String(), receiverID.String(), &messages)
 	if err != nil {
 		getMessagesParams := database.GetMessagesParams{
 			SenderID:   userID,
@@ -145,20 +562,33 @@ func (s *server) GetMessages(ctx context.Context, req *pb.GetMessagesRequest) (*
 			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get messages from db - GetMessages", err)
 		}
 
-		redis.CacheMessages(userID.String(), receiverID.String(), messages)
+		redis.CacheMessages(ctx, userID.String(), receiverID.String(), messages)
+
+		redis.CacheMessageCount(ctx, userID.String(), receiverID.String(), int64(len(messages)))
+	}
+
+	// Cached conversations can still hold messages that expired since they were cached.
+	messages = removeExpiredMessages(messages, time.Now())
 
-		redis.CacheMessageCount(userID.String(), receiverID.String(), int64(len(messages)))
+	// Redis caches the rows as stored, so the content is only decrypted for the response.
+	err = s.openMessages(ctx, messages)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt messages - GetMessages", err)
 	}
 
 	messagesResponse := make([]*pb.Message, len(messages))
 	for i, message := range messages {
-		messagesResponse[i] = &pb.Message{
-			Id:         message.ID.String(),
-			SentAt:     timestamppb.New(message.SentAt),
-			SenderId:   message.SenderID.String(),
-			ReceiverId: message.ReceiverID.String(),
-			Content:    message.Content,
-		}
+		messagesResponse[i] = messageToProto(message)
+	}
+
+	err = s.markPinned(ctx, userID, receiverID, messagesResponse)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get pinned messages - GetMessages", err)
+	}
+
+	err = s.attachDetails(ctx, userID, messagesResponse, "GetMessages")
+	if err != nil {
+		return nil, err
 	}
 
 	return &pb.GetMessagesResponse{
@@ -172,30 +602,95 @@ func (s *server) ChangeMessage(ctx context.Context, req *pb.ChangeMessageRequest
 		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - ChangeMessage", err)
 	}
 
+	normalized, err := s.normalizeContent(ctx, req.GetContent(), false, "ChangeMessage")
+	if err != nil {
+		return nil, err
+	}
+	req.Content = normalized
+
+	// The payload is sealed with the content, so it's sealed again with the new data key.
+	current, err := s.db.GetMessageByID(ctx, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, helper.RespondWithReasonGRPC(ctx, helper.ReasonMessageNotFound, "message not found - ChangeMessage", err)
+	}
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message by id - ChangeMessage", err)
+	}
+
+	err = s.openMessage(ctx, &current)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt message - ChangeMessage", err)
+	}
+
+	// The new content goes through the same moderation as a new message.
+	moderationResult := s.moderateMessage(ctx, current.SenderID, current.ReceiverID, req.GetContent(), current.IsEncrypted)
+	if moderationResult.Verdict == moderation.Reject {
+		return nil, moderationRejected(ctx, moderationResult, "ChangeMessage")
+	}
+
+	sealed, err := s.sealContent(ctx, messageID, req.GetContent(), current.Payload)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't encrypt message content - ChangeMessage", err)
+	}
+
 	changeMessageParams := database.ChangeMessageParams{
-		ID:      messageID,
-		Content: req.GetContent(),
+		ID:                messageID,
+		Content:           sealed.Content,
+		ContentCiphertext: sealed.Ciphertext,
+		ContentDataKey:    sealed.DataKey,
+		ContentKeyID:      sealed.KeyID,
+		Payload:           sealed.Payload,
+		PayloadCiphertext: sealed.PayloadCiphertext,
 	}
 
-	message, err := s.db.ChangeMessage(ctx, changeMessageParams)
+	message, err := s.changeMessage(ctx, changeMessageParams, req.GetContent())
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't change message - ChangeMessage", err)
+		return nil, err
 	}
 
-	redis.InvalidateMessagesCache(message.SenderID.String(), message.ReceiverID.String())
-	redis.InvalidateLastMessage(message.SenderID.String(), message.ReceiverID.String())
+	redis.InvalidateMessagesCache(ctx, message.SenderID.String(), message.ReceiverID.String())
+	redis.InvalidateLastMessage(ctx, message.SenderID.String(), message.ReceiverID.String())
+
+	message.Content, message.Payload = req.GetContent(), current.Payload
+
+	if moderationResult.Verdict == moderation.Flag {
+		s.reportFlaggedMessage(ctx, message, moderationResult)
+	}
 
 	return &pb.ChangeMessageResponse{
-		Message: &pb.Message{
-			Id:         message.ID.String(),
-			SentAt:     timestamppb.New(message.SentAt),
-			SenderId:   message.SenderID.String(),
-			ReceiverId: message.ReceiverID.String(),
-			Content:    message.Content,
-		},
+		Message: messageToProto(message),
 	}, nil
 }
 
+// changeMessage stores the new content and replaces the entities of the message in one
+// transaction, so the entities always match the stored content. End-to-end encrypted messages
+// are never changed to plaintext.
+func (s *server) changeMessage(ctx context.Context, params database.ChangeMessageParams, content string) (database.Message, error) {
+	var message database.Message
+	err := s.inTx(ctx, func(q *database.Queries) error {
+		var err error
+		message, err = q.ChangeMessage(ctx, params)
+		if errors.Is(err, sql.ErrNoRows) {
+			return helper.RespondWithReasonGRPC(ctx, helper.ReasonMessageNotFound, "message not found or end-to-end encrypted - ChangeMessage", err)
+		}
+		if err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't change message - ChangeMessage", err)
+		}
+
+		err = replaceEntities(ctx, q, message.ID, content)
+		if err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store message entities - ChangeMessage", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return database.Message{}, transactionError(ctx, err, "ChangeMessage")
+	}
+
+	return message, nil
+}
+
 func (s *server) DeleteMessage(ctx context.Context, req *pb.DeleteMessageRequest) (*pb.DeleteMessageResponse, error) {
 	messageID, err := uuid.Parse(req.GetId())
 	if err != nil {
@@ -211,3 +706,105 @@ func (s *server) DeleteMessage(ctx context.Context, req *pb.DeleteMessageRequest
 		Status: true,
 	}, nil
 }
+
+// messageToProto converts a stored message to its protobuf representation.
+func messageToProto(message database.Message) *pb.Message {
+	messageResponse := &pb.Message{
+		Id:               message.ID.String(),
+		SentAt:           timestamppb.New(message.SentAt),
+		SenderId:         message.SenderID.String(),
+		ReceiverId:       message.ReceiverID.String(),
+		Content:          message.Content,
+		ClientMessageId:  message.ClientMessageID.String,
+		Encrypted:        message.IsEncrypted,
+		EncryptedPayload: encryptedPayloadToProto(message),
+		SendAt:           nullTimeToProto(message.SendAt),
+		SystemEvent:      message.SystemEvent.String,
+		ReadAt:           nullTimeToProto(message.ReadAt),
+		ExpiresAt:        nullTimeToProto(message.ExpiresAt),
+		ForwardedFrom:    forwardedFromToProto(message),
+	}
+	setMessagePayload(messageResponse, message)
+
+	return messageResponse
+}
+
+// attachDetails sets the entities and the poll results of the messages, as seen by the user.
+func (s *server) attachDetails(ctx context.Context, userID uuid.UUID, messages []*pb.Message, method string) error {
+	err := s.attachEntities(ctx, messages)
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message entities - "+method, err)
+	}
+
+	err = s.attachPolls(ctx, userID, messages)
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get poll results - "+method, err)
+	}
+
+	return nil
+}
+
+// nullTimeToProto converts an optional time, leaving the field empty when it isn't set.
+func nullTimeToProto(t sql.NullTime) *timestamppb.Timestamp {
+	if !t.Valid {
+		return nil
+	}
+
+	return timestamppb.New(t.Time)
+}
+
+// getUser returns the user's data, reading it from Redis when possible and falling back to the database.
+func (s *server) getUser(ctx context.Context, userID uuid.UUID) (database.User, error) {
+	return lookupUser(ctx, s.db, userID)
+}
+
+func lookupUser(ctx context.Context, db *database.Queries, userID uuid.UUID) (database.User, error) {
+	var user database.User
+	err := redis.GetCachedUser(ctx, userID.String(), &user)
+	if err == nil {
+		return user, nil
+	}
+
+	user, err = db.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	redis.CacheUser(ctx, userID.String(), user)
+
+	return user, nil
+}
+
+// getParticipantMessage returns the message when the user is its sender or receiver. Messages of
+// other conversations are reported as not found, so their existence isn't revealed.
+func (s *server) getParticipantMessage(ctx context.Context, messageID, userID uuid.UUID, method string) (database.Message, error) {
+	message, err := s.db.GetMessageByID(ctx, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Message{}, helper.RespondWithReasonGRPC(ctx, helper.ReasonMessageNotFound, "message not found - "+method, err)
+	}
+	if err != nil {
+		return database.Message{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message by id - "+method, err)
+	}
+
+	// Scheduled messages aren't visible to the receiver before they are delivered.
+	if message.SenderID != userID && (message.ReceiverID != userID || message.SendAt.Valid) || isExpired(message, time.Now()) {
+		return database.Message{}, helper.RespondWithReasonGRPC(ctx, helper.ReasonMessageNotFound, "message not found - "+method, nil)
+	}
+
+	err = s.openMessage(ctx, &message)
+	if err != nil {
+		return database.Message{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt message - "+method, err)
+	}
+
+	return message, nil
+}
+
+// invalidateConversationCaches removes every cached entry that changes when a message is added
+// to the conversation between the two users.
+func invalidateConversationCaches(ctx context.Context, senderID, receiverID uuid.UUID) {
+	redis.InvalidateMessagesCache(ctx, senderID.String(), receiverID.String())
+	redis.InvalidateConversationList(ctx, senderID.String())
+	redis.InvalidateConversationList(ctx, receiverID.String())
+	redis.InvalidateLastMessage(ctx, senderID.String(), receiverID.String())
+	redis.DeleteMessageCount(ctx, senderID.String(), receiverID.String())
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	messageRequestPending  = "pending"
+	messageRequestAccepted = "accepted"
+	messageRequestDeclined = "declined"
+)
+
+func (s *server) ListMessageRequests(ctx context.Context, req *pb.ListMessageRequestsRequest) (*pb.ListMessageRequestsResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ListMessageRequests", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ListMessageRequests", err)
+	}
+
+	messageRequests, err := s.db.ListMessageRequests(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message requests from db - ListMessageRequests", err)
+	}
+
+	messageRequestsResponse := make([]*pb.MessageRequest, len(messageRequests))
+	for i, messageRequest := range messageRequests {
+		messageRequestsResponse[i] = s.messageRequestToProto(ctx, messageRequest)
+	}
+
+	return &pb.ListMessageRequestsResponse{
+		MessageRequests: messageRequestsResponse,
+	}, nil
+}
+
+func (s *server) AcceptMessageRequest(ctx context.Context, req *pb.AcceptMessageRequestRequest) (*pb.AcceptMessageRequestResponse, error) {
+	messageRequest, err := s.answerMessageRequest(ctx, req.GetId(), messageRequestAccepted, "AcceptMessageRequest")
+	if err != nil {
+		return nil, err
+	}
+
+	// Accepted conversations move from the requests inbox to the receiver's conversation list.
+	redis.InvalidateConversationList(ctx, messageRequest.ReceiverID.String())
+
+	return &pb.AcceptMessageRequestResponse{
+		MessageRequest: s.messageRequestToProto(ctx, messageRequest),
+	}, nil
+}
+
+func (s *server) DeclineMessageRequest(ctx context.Context, req *pb.DeclineMessageRequestRequest) (*pb.DeclineMessageRequestResponse, error) {
+	messageRequest, err := s.answerMessageRequest(ctx, req.GetId(), messageRequestDeclined, "DeclineMessageRequest")
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.DeclineMessageRequestResponse{
+		MessageRequest: s.messageRequestToProto(ctx, messageRequest),
+	}, nil
+}
+
+// answerMessageRequest moves a pending request addressed to the caller to the given status.
+func (s *server) answerMessageRequest(ctx context.Context, id, status, method string) (database.MessageRequest, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return database.MessageRequest{}, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - "+method, err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return database.MessageRequest{}, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - "+method, err)
+	}
+
+	requestID, err := uuid.Parse(id)
+	if err != nil {
+		return database.MessageRequest{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message request id - "+method, err)
+	}
+
+	updateMessageRequestStatusParams := database.UpdateMessageRequestStatusParams{
+		ID:         requestID,
+		ReceiverID: userID,
+		Status:     status,
+	}
+
+	messageRequest, err := s.db.UpdateMessageRequestStatus(ctx, updateMessageRequestStatusParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.MessageRequest{}, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "pending message request not found - "+method, err)
+	}
+	if err != nil {
+		return database.MessageRequest{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't update message request via db - "+method, err)
+	}
+
+	// Accepted requests join the caller's conversation list.
+	redis.InvalidateConversationList(ctx, userID.String())
+
+	return messageRequest, nil
+}
+
+// needsMessageRequest decides whether a message may go to the receiver's requests inbox. Messages
+// from users the receiver follows and replies in conversations the receiver started go to the
+// main inbox. Other messages are counted by recordMessageRequest once they are stored.
+func (s *server) needsMessageRequest(ctx context.Context, senderID uuid.UUID, receiver database.User, method string) (bool, error) {
+	if slices.Contains(receiver.SubscribedTo, senderID) {
+		return false, nil
+	}
+
+	startedByReceiver, err := s.db.HasSentMessage(ctx, database.HasSentMessageParams{
+		SenderID:   receiver.ID,
+		ReceiverID: senderID,
+	})
+	if err != nil {
+		return false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't check conversation history - "+method, err)
+	}
+
+	return !startedByReceiver, nil
+}
+
+// recordMessageRequest counts a stored message against the message request of the sender. It
+// runs in the transaction that stores the message, so only stored messages are counted and a
+// rejected message isn't stored. Messages of accepted requests go to the main inbox, otherwise
+// the request allows at most MessageRequestLimit messages until the receiver accepts it.
+func (s *server) recordMessageRequest(ctx context.Context, q *database.Queries, senderID, receiverID uuid.UUID, method string) (bool, error) {
+	recordMessageRequestParams := database.RecordMessageRequestParams{
+		ID:           uuid.New(),
+		SenderID:     senderID,
+		ReceiverID:   receiverID,
+		MessageLimit: s.options.MessageRequestLimit,
+	}
+
+	_, err := q.RecordMessageRequest(ctx, recordMessageRequestParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return closedMessageRequest(ctx, q, senderID, receiverID, method)
+	}
+	if err != nil {
+		return false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't record message request via db - "+method, err)
+	}
+
+	return true, nil
+}
+
+// closedMessageRequest handles a request that no longer takes messages: accepted requests send
+// to the main inbox, while declined and full requests reject the message.
+func closedMessageRequest(ctx context.Context, q *database.Queries, senderID, receiverID uuid.UUID, method string) (bool, error) {
+	messageRequest, err := q.GetMessageRequest(ctx, database.GetMessageRequestParams{
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+	})
+	if err != nil {
+		return false, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get message request from db - "+method, err)
+	}
+
+	switch messageRequest.Status {
+	case messageRequestAccepted:
+		return false, nil
+	case messageRequestDeclined:
+		return false, helper.RespondWithReasonGRPC(ctx, helper.ReasonMessageRequestDeclined, "receiver declined your message request - "+method, nil)
+	default:
+		return false, helper.RespondWithReasonGRPC(ctx, helper.ReasonMessageRequestLimitReached, "message request limit reached, wait until the receiver accepts it - "+method, nil)
+	}
+}
+
+func (s *server) messageRequestToProto(ctx context.Context, messageRequest database.MessageRequest) *pb.MessageRequest {
+	messageRequestResponse := &pb.MessageRequest{
+		Id:           messageRequest.ID.String(),
+		SenderId:     messageRequest.SenderID.String(),
+		Status:       messageRequest.Status,
+		MessageCount: messageRequest.MessageCount,
+		CreatedAt:    timestamppb.New(messageRequest.CreatedAt),
+		UpdatedAt:    timestamppb.New(messageRequest.UpdatedAt),
+	}
+
+	sender, err := s.getUser(ctx, messageRequest.SenderID)
+	if err == nil {
+		messageRequestResponse.SenderUsername = sender.Username
+	}
+
+	return messageRequestResponse
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+)
+
+// messageRequestRow returns the columns of a message request as the message request queries
+// select them.
+func messageRequestRow(senderID, receiverID uuid.UUID, status string, messageCount int32) []driver.Value {
+	return []driver.Value{
+		uuid.NewString(),
+		senderID.String(),
+		receiverID.String(),
+		status,
+		int64(messageCount),
+		time.Now(),
+		time.Now(),
+	}
+}
+
+func TestNeedsMessageRequest(t *testing.T) {
+	senderID := uuid.New()
+
+	tests := []struct {
+		name              string
+		receiverFollows   bool
+		receiverSentFirst bool
+		want              bool
+		wantQuery         bool
+	}{
+		{name: "receiver follows sender", receiverFollows: true, want: false},
+		{name: "reply in conversation the receiver started", receiverSentFirst: true, want: false, wantQuery: true},
+		{name: "stranger", want: true, wantQuery: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := database.User{ID: uuid.New()}
+			if tt.receiverFollows {
+				receiver.SubscribedTo = []uuid.UUID{senderID}
+			}
+
+			s, db := newTestServer(t, map[string]fakeAnswer{
+				"HasSentMessage": returns([]driver.Value{tt.receiverSentFirst}),
+			}, Options{})
+
+			got, err := s.needsMessageRequest(context.Background(), senderID, receiver, "SendMessage")
+			if err != nil {
+				t.Fatalf("needsMessageRequest() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("needsMessageRequest() = %v, want %v", got, tt.want)
+			}
+			if db.ran("HasSentMessage") != tt.wantQuery {
+				t.Errorf("needsMessageRequest() ran HasSentMessage = %v, want %v", db.ran("HasSentMessage"), tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestStoreMessageCountsMessageRequest(t *testing.T) {
+	const messageRequestLimit = 3
+	senderID := uuid.New()
+	receiverID := uuid.New()
+
+	tests := []struct {
+		name                 string
+		needsRequest         bool
+		recordMessageRequest fakeAnswer
+		getMessageRequest    fakeAnswer
+		wantStored           bool
+		wantMessageRequest   bool
+		wantReason           helper.Reason
+	}{
+		{
+			name:       "request not needed",
+			wantStored: true,
+		},
+		{
+			name:                 "counted against pending request",
+			needsRequest:         true,
+			recordMessageRequest: returns(messageRequestRow(senderID, receiverID, messageRequestPending, 2)),
+			wantStored:           true,
+			wantMessageRequest:   true,
+		},
+		{
+			name:                 "accepted request",
+			needsRequest:         true,
+			recordMessageRequest: returns(),
+			getMessageRequest:    returns(messageRequestRow(senderID, receiverID, messageRequestAccepted, 3)),
+			wantStored:           true,
+		},
+		{
+			name:                 "declined request",
+			needsRequest:         true,
+			recordMessageRequest: returns(),
+			getMessageRequest:    returns(messageRequestRow(senderID, receiverID, messageRequestDeclined, 1)),
+			wantReason:           helper.ReasonMessageRequestDeclined,
+		},
+		{
+			name:                 "limit reached",
+			needsRequest:         true,
+			recordMessageRequest: returns(),
+			getMessageRequest:    returns(messageRequestRow(senderID, receiverID, messageRequestPending, messageRequestLimit)),
+			wantReason:           helper.ReasonMessageRequestLimitReached,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := database.SendMessageParams{
+				ID:         uuid.New(),
+				SenderID:   senderID,
+				ReceiverID: receiverID,
+				Content:    "hello",
+			}
+
+			answers := map[string]fakeAnswer{
+				"SendMessage": returns(messageRow(database.Message{
+					ID:         params.ID,
+					SentAt:     time.Now(),
+					SenderID:   senderID,
+					ReceiverID: receiverID,
+					Content:    params.Content,
+					Kind:       "text",
+				})),
+			}
+			if tt.recordMessageRequest != nil {
+				answers["RecordMessageRequest"] = tt.recordMessageRequest
+			}
+			if tt.getMessageRequest != nil {
+				answers["GetMessageRequest"] = tt.getMessageRequest
+			}
+			s, db := newTestServer(t, answers, Options{MessageRequestLimit: messageRequestLimit})
+
+			message, stored, isMessageRequest, err := s.storeMessage(context.Background(), params, &pb.SendMessageRequest{Content: params.Content}, tt.needsRequest, "SendMessage")
+			if tt.wantReason != "" {
+				if got := errorReason(err); got != tt.wantReason {
+					t.Fatalf("storeMessage() reason = %q, want %q (error %v)", got, tt.wantReason, err)
+				}
+				if db.commits != 0 || db.rollbacks != 1 {
+					t.Errorf("storeMessage() commits = %d, rollbacks = %d, want the rejected message rolled back", db.commits, db.rollbacks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("storeMessage() error = %v", err)
+			}
+
+			if stored != tt.wantStored || message.ID != params.ID {
+				t.Errorf("storeMessage() stored = %v, id = %v, want %v, %v", stored, message.ID, tt.wantStored, params.ID)
+			}
+			if isMessageRequest != tt.wantMessageRequest {
+				t.Errorf("storeMessage() isMessageRequest = %v, want %v", isMessageRequest, tt.wantMessageRequest)
+			}
+			if db.commits != 1 {
+				t.Errorf("storeMessage() commits = %d, want 1", db.commits)
+			}
+		})
+	}
+}
+
+// The configured limit is what RecordMessageRequest checks the request's count against.
+func TestRecordMessageRequestLimit(t *testing.T) {
+	senderID := uuid.New()
+	receiverID := uuid.New()
+
+	var messageCount int32
+	s, _ := newTestServer(t, map[string]fakeAnswer{
+		"RecordMessageRequest": func(args []driver.Value) ([][]driver.Value, error) {
+			if messageCount >= int32(args[3].(int64)) {
+				return nil, nil
+			}
+			messageCount++
+			return [][]driver.Value{messageRequestRow(senderID, receiverID, messageRequestPending, messageCount)}, nil
+		},
+		"GetMessageRequest": func([]driver.Value) ([][]driver.Value, error) {
+			return [][]driver.Value{messageRequestRow(senderID, receiverID, messageRequestPending, messageCount)}, nil
+		},
+	}, Options{MessageRequestLimit: 2})
+
+	for i, wantReason := range []helper.Reason{"", "", helper.ReasonMessageRequestLimitReached} {
+		isMessageRequest, err := s.recordMessageRequest(context.Background(), s.db, senderID, receiverID, "SendMessage")
+		if got := errorReason(err); got != wantReason {
+			t.Fatalf("message %d: recordMessageRequest() reason = %q, want %q (error %v)", i+1, got, wantReason, err)
+		}
+		if err == nil && !isMessageRequest {
+			t.Errorf("message %d: recordMessageRequest() isMessageRequest = false, want true", i+1)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/rabbitmq"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+)
+
+const systemEventMessagePinned = "message_pinned"
+
+func (s *server) PinMessage(ctx context.Context, req *pb.PinMessageRequest) (*pb.PinMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - PinMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - PinMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - PinMessage", err)
+	}
+
+	message, err := s.getParticipantMessage(ctx, messageID, userID, "PinMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	if message.SendAt.Valid {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "scheduled messages can't be pinned - PinMessage", nil)
+	}
+	if message.SystemEvent.Valid {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "system messages can't be pinned - PinMessage", nil)
+	}
+
+	// Pinning posts to the conversation and notifies the partner, like sending a message.
+	partnerID := conversationPartner(message, userID)
+	err = s.checkNotBlocked(ctx, userID, partnerID, "PinMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.pinMessage(ctx, message, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.postSystemMessage(ctx, userID, partnerID, systemEventMessagePinned, "A message was pinned")
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't post pinned message event - PinMessage", err)
+	}
+
+	// The pin is already stored, so a failed notification doesn't fail the request.
+	err = s.notifyPinned(ctx, message, userID, partnerID)
+	if err != nil {
+		slog.WarnContext(ctx, "can't notify about pinned message", "message_id", message.ID, "error", err)
+	}
+
+	messageResponse := messageToProto(message)
+	messageResponse.Pinned = true
+
+	return &pb.PinMessageResponse{
+		Message: messageResponse,
+	}, nil
+}
+
+// pinMessage stores the pin in the first free slot of the conversation. The slots are unique per
+// conversation, so two concurrent pins can't exceed the limit together.
+func (s *server) pinMessage(ctx context.Context, message database.Message, userID uuid.UUID) error {
+	userLow, userHigh := conversationKey(message.SenderID, message.ReceiverID)
+
+	pinnedIDs, err := s.getPinnedMessageIDs(ctx, userLow, userHigh)
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get pinned messages - PinMessage", err)
+	}
+	if slices.Contains(pinnedIDs, message.ID) {
+		return helper.RespondWithErrorGRPC(ctx, codes.AlreadyExists, "message is already pinned - PinMessage", nil)
+	}
+
+	pinMessageParams := database.PinMessageParams{
+		MessageID: message.ID,
+		UserLow:   userLow,
+		UserHigh:  userHigh,
+		PinnedBy:  userID,
+		PinLimit:  s.options.PinnedMessagesLimit,
+	}
+
+	_, err = s.db.PinMessage(ctx, pinMessageParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return helper.RespondWithReasonGRPC(ctx, helper.ReasonPinnedMessagesLimitReached, "pinned messages limit reached, unpin a message first - PinMessage", err)
+	}
+	// A concurrent pin of the same message hits the primary key, one of another message the slot.
+	if isConstraintViolation(err, "pinned_messages_pkey") {
+		return helper.RespondWithErrorGRPC(ctx, codes.AlreadyExists, "message is already pinned - PinMessage", err)
+	}
+	if isUniqueViolation(err) {
+		return helper.RespondWithErrorGRPC(ctx, codes.Aborted, "conversation pins changed concurrently, try again - PinMessage", err)
+	}
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't pin message via db - PinMessage", err)
+	}
+
+	redis.InvalidatePinnedMessageIDs(ctx, userLow.String(), userHigh.String())
+
+	return nil
+}
+
+// notifyPinned lets the other participant know that a message of their conversation was pinned.
+func (s *server) notifyPinned(ctx context.Context, message database.Message, userID, partnerID uuid.UUID) error {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.rabbitmq.PublishJSON(ctx, rabbitmq.RoutingKey, map[string]interface{}{
+		"title":           "Message Pinned",
+		"sender_username": user.Username,
+		"receiver_id":     partnerID.String(),
+		"message_id":      message.ID.String(),
+		"content":         message.Content,
+		"encrypted":       message.IsEncrypted,
+	})
+}
+
+func (s *server) UnpinMessage(ctx context.Context, req *pb.UnpinMessageRequest) (*pb.UnpinMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - UnpinMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - UnpinMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - UnpinMessage", err)
+	}
+
+	message, err := s.getParticipantMessage(ctx, messageID, userID, "UnpinMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.db.UnpinMessage(ctx, message.ID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't unpin message via db - UnpinMessage", err)
+	}
+	if deleted == 0 {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "message isn't pinned - UnpinMessage", nil)
+	}
+
+	userLow, userHigh := conversationKey(message.SenderID, message.ReceiverID)
+	redis.InvalidatePinnedMessageIDs(ctx, userLow.String(), userHigh.String())
+
+	return &pb.UnpinMessageResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) ListPinnedMessages(ctx context.Context, req *pb.ListPinnedMessagesRequest) (*pb.ListPinnedMessagesResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ListPinnedMessages", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ListPinnedMessages", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - ListPinnedMessages", err)
+	}
+
+	userLow, userHigh := conversationKey(userID, partnerID)
+
+	listPinnedMessagesParams := database.ListPinnedMessagesParams{
+		UserLow:  userLow,
+		UserHigh: userHigh,
+	}
+
+	// Only the participants' own conversation is queried, so nobody else's pins can be listed.
+	messages, err := s.db.ListPinnedMessages(ctx, listPinnedMessagesParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get pinned messages from db - ListPinnedMessages", err)
+	}
+
+	err = s.openMessages(ctx, messages)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt messages - ListPinnedMessages", err)
+	}
+
+	messagesResponse := make([]*pb.Message, len(messages))
+	for i, message := range messages {
+		messagesResponse[i] = messageToProto(message)
+		messagesResponse[i].Pinned = true
+	}
+
+	return &pb.ListPinnedMessagesResponse{
+		Messages: messagesResponse,
+	}, nil
+}
+
+// getPinnedMessageIDs returns the ids of the pinned messages of the conversation, reading them
+// from Redis when possible.
+func (s *server) getPinnedMessageIDs(ctx context.Context, userLow, userHigh uuid.UUID) ([]uuid.UUID, error) {
+	var pinnedIDs []uuid.UUID
+	err := redis.GetCachedPinnedMessageIDs(ctx, userLow.String(), userHigh.String(), &pinnedIDs)
+	if err == nil {
+		return pinnedIDs, nil
+	}
+
+	listPinnedMessageIDsParams := database.ListPinnedMessageIDsParams{
+		UserLow:  userLow,
+		UserHigh: userHigh,
+	}
+
+	pinnedIDs, err = s.db.ListPinnedMessageIDs(ctx, listPinnedMessageIDsParams)
+	if err != nil {
+		return nil, err
+	}
+
+	redis.CachePinnedMessageIDs(ctx, userLow.String(), userHigh.String(), pinnedIDs)
+
+	return pinnedIDs, nil
+}
+
+// markPinned sets the pinned flag of the messages of the conversation between the two users.
+func (s *server) markPinned(ctx context.Context, firstID, secondID uuid.UUID, messages []*pb.Message) error {
+	userLow, userHigh := conversationKey(firstID, secondID)
+
+	pinnedIDs, err := s.getPinnedMessageIDs(ctx, userLow, userHigh)
+	if err != nil {
+		return err
+	}
+
+	pinned := uuidStrings(pinnedIDs)
+	for _, message := range messages {
+		message.Pinned = slices.Contains(pinned, message.GetId())
+	}
+
+	return nil
+}
+
+// conversationPartner returns the other participant of the message's conversation.
+func conversationPartner(message database.Message, userID uuid.UUID) uuid.UUID {
+	if message.SenderID == userID {
+		return message.ReceiverID
+	}
+	return message.SenderID
+}
@@ -0,0 +1,431 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pollState is the stored state of a poll with the ballots of its voters, as cached in Redis.
+type pollState struct {
+	Poll    database.Poll
+	Ballots []database.PollBallot
+}
+
+// CreatePoll sends a poll to the receiver. It is a SendMessage with the poll kind and the question
+// as the content, so polls go through the same checks, moderation and notification as messages.
+func (s *server) CreatePoll(ctx context.Context, req *pb.CreatePollRequest) (*pb.CreatePollResponse, error) {
+	sendMessageResponse, err := s.SendMessage(ctx, &pb.SendMessageRequest{
+		ReceiverId:      req.GetReceiverId(),
+		Content:         req.GetPoll().GetQuestion(),
+		ClientMessageId: req.GetClientMessageId(),
+		Kind:            pb.MessageKind_MESSAGE_KIND_POLL,
+		Payload:         &pb.SendMessageRequest_Poll{Poll: req.GetPoll()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CreatePollResponse{
+		Message: sendMessageResponse.GetMessage(),
+	}, nil
+}
+
+// Vote stores the caller's choice, replacing an earlier vote of the caller on the poll.
+func (s *server) Vote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - Vote", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - Vote", err)
+	}
+
+	message, poll, err := s.getPollMessage(ctx, req.GetMessageId(), userID, "Vote")
+	if err != nil {
+		return nil, err
+	}
+
+	problem := voteProblem(poll, req.GetOptions())
+	if problem != "" {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, problem+" - Vote", nil)
+	}
+
+	options := slices.Clone(req.GetOptions())
+	slices.Sort(options)
+
+	castVoteParams := database.CastVoteParams{
+		UserID:    userID,
+		Options:   options,
+		MessageID: message.ID,
+	}
+
+	version, err := s.changePoll(ctx, message.ID, "Vote", func(q *database.Queries) error {
+		_, err := q.CastVote(ctx, castVoteParams)
+		if err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't cast vote via db - Vote", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messageResponse, err := s.pollChanged(ctx, message, userID, version, "Vote")
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.VoteResponse{
+		Message: messageResponse,
+	}, nil
+}
+
+func (s *server) RetractVote(ctx context.Context, req *pb.RetractVoteRequest) (*pb.RetractVoteResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - RetractVote", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - RetractVote", err)
+	}
+
+	message, _, err := s.getPollMessage(ctx, req.GetMessageId(), userID, "RetractVote")
+	if err != nil {
+		return nil, err
+	}
+
+	retractVoteParams := database.RetractVoteParams{
+		MessageID: message.ID,
+		UserID:    userID,
+	}
+
+	version, err := s.changePoll(ctx, message.ID, "RetractVote", func(q *database.Queries) error {
+		rows, err := q.RetractVote(ctx, retractVoteParams)
+		if err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't retract vote via db - RetractVote", err)
+		}
+		if rows == 0 {
+			return helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "no vote to retract - RetractVote", nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messageResponse, err := s.pollChanged(ctx, message, userID, version, "RetractVote")
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RetractVoteResponse{
+		Message: messageResponse,
+	}, nil
+}
+
+// ClosePoll stops the voting. Only the creator of the poll can close it, and the results stay visible.
+func (s *server) ClosePoll(ctx context.Context, req *pb.ClosePollRequest) (*pb.ClosePollResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ClosePoll", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ClosePoll", err)
+	}
+
+	message, _, err := s.getPollMessage(ctx, req.GetMessageId(), userID, "ClosePoll")
+	if err != nil {
+		return nil, err
+	}
+
+	if message.SenderID != userID {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.PermissionDenied, "only the creator of the poll can close it - ClosePoll", nil)
+	}
+
+	version, err := s.changePoll(ctx, message.ID, "ClosePoll", func(q *database.Queries) error {
+		_, err := q.ClosePoll(ctx, message.ID)
+		if err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't close poll via db - ClosePoll", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messageResponse, err := s.pollChanged(ctx, message, userID, version, "ClosePoll")
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ClosePollResponse{
+		Message: messageResponse,
+	}, nil
+}
+
+// getPollMessage returns the poll message of the caller's conversation with the state of the poll,
+// as long as the poll is delivered and open.
+func (s *server) getPollMessage(ctx context.Context, id string, userID uuid.UUID, method string) (database.Message, database.Poll, error) {
+	messageID, err := uuid.Parse(id)
+	if err != nil {
+		return database.Message{}, database.Poll{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - "+method, err)
+	}
+
+	message, err := s.getParticipantMessage(ctx, messageID, userID, method)
+	if err != nil {
+		return database.Message{}, database.Poll{}, err
+	}
+
+	if messageKindFromString(message.Kind) != pb.MessageKind_MESSAGE_KIND_POLL {
+		return database.Message{}, database.Poll{}, helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "message isn't a poll - "+method, nil)
+	}
+	if message.SendAt.Valid {
+		return database.Message{}, database.Poll{}, helper.RespondWithErrorGRPC(ctx, codes.FailedPrecondition, "scheduled polls can't be voted on yet - "+method, nil)
+	}
+
+	poll, err := s.db.GetPoll(ctx, message.ID)
+	if err != nil {
+		return database.Message{}, database.Poll{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get poll via db - "+method, err)
+	}
+	if poll.ClosedAt.Valid {
+		return database.Message{}, database.Poll{}, helper.RespondWithReasonGRPC(ctx, helper.ReasonPollClosed, "poll is closed - "+method, nil)
+	}
+
+	return message, poll, nil
+}
+
+// voteProblem describes why the chosen options aren't a valid vote on the poll.
+func voteProblem(poll database.Poll, options []int32) string {
+	if len(options) == 0 {
+		return "choose at least one option"
+	}
+	if !poll.MultipleChoice && len(options) > 1 {
+		return "single choice polls take exactly one option"
+	}
+
+	seen := make(map[int32]bool, len(options))
+	for _, option := range options {
+		if option < 0 || option >= poll.OptionCount {
+			return "option doesn't exist"
+		}
+		if seen[option] {
+			return "options must be unique"
+		}
+		seen[option] = true
+	}
+
+	return ""
+}
+
+// storePoll stores the state of a new poll, which its votes refer to. Messages without a poll
+// are skipped.
+func storePoll(ctx context.Context, q *database.Queries, messageID uuid.UUID, poll *pb.Poll) error {
+	if poll == nil {
+		return nil
+	}
+
+	return q.CreatePoll(ctx, database.CreatePollParams{
+		MessageID:      messageID,
+		OptionCount:    int32(len(poll.GetOptions())),
+		MultipleChoice: poll.GetMultipleChoice(),
+		Anonymous:      poll.GetAnonymous(),
+	})
+}
+
+// changePoll bumps the version of the open poll and applies the change in the same transaction.
+// It returns the version of the change, or a POLL_CLOSED error when the poll is closed.
+func (s *server) changePoll(ctx context.Context, messageID uuid.UUID, method string, change func(q *database.Queries) error) (int64, error) {
+	var version int64
+	err := s.inTx(ctx, func(q *database.Queries) error {
+		var err error
+		version, err = q.BumpPollVersion(ctx, messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return helper.RespondWithReasonGRPC(ctx, helper.ReasonPollClosed, "poll is closed - "+method, nil)
+		}
+		if err != nil {
+			return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't lock poll via db - "+method, err)
+		}
+
+		return change(q)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't change poll via db - "+method, err)
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// pollChanged refreshes the cached poll and sends both participants its new results. It returns
+// the poll message with the results for the caller.
+func (s *server) pollChanged(ctx context.Context, message database.Message, userID uuid.UUID, version int64, method string) (*pb.Message, error) {
+	// Recording the version of the change keeps a concurrent read that started before it from
+	// caching its older state.
+	redis.InvalidatePoll(ctx, message.ID.String(), version)
+
+	states, err := s.getPollStates(ctx, []uuid.UUID{message.ID})
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get poll results - "+method, err)
+	}
+	state := states[message.ID]
+
+	// The change is already stored, so a failed event doesn't fail the request.
+	for _, participantID := range []uuid.UUID{message.SenderID, message.ReceiverID} {
+		pollResponse := messageToProto(message).GetPoll()
+		setPollResults(pollResponse, state, participantID)
+
+		err = publishEvent(ctx, redis.UserEventsChannel(participantID.String()), &pb.Event{
+			Event: &pb.Event_Poll{
+				Poll: &pb.PollEvent{
+					MessageId: message.ID.String(),
+					Poll:      pollResponse,
+				},
+			},
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "can't publish poll event", "message_id", message.ID, "error", err)
+		}
+	}
+
+	messageResponse := messageToProto(message)
+	setPollResults(messageResponse.GetPoll(), state, userID)
+
+	return messageResponse, nil
+}
+
+// attachPolls sets the results of the polls among the messages, as seen by the user.
+func (s *server) attachPolls(ctx context.Context, userID uuid.UUID, messages []*pb.Message) error {
+	byID := make(map[uuid.UUID]*pb.Message)
+	var messageIDs []uuid.UUID
+	for _, message := range messages {
+		if message.GetPoll() == nil {
+			continue
+		}
+
+		messageID, err := uuid.Parse(message.GetId())
+		if err != nil {
+			return err
+		}
+		byID[messageID] = message
+		messageIDs = append(messageIDs, messageID)
+	}
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	states, err := s.getPollStates(ctx, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	for messageID, state := range states {
+		setPollResults(byID[messageID].GetPoll(), state, userID)
+	}
+
+	return nil
+}
+
+// getPollStates returns the polls with their ballots, reading them from Redis when possible and
+// falling back to the database.
+func (s *server) getPollStates(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID]pollState, error) {
+	states := make(map[uuid.UUID]pollState, len(messageIDs))
+	var missing []uuid.UUID
+	for _, messageID := range messageIDs {
+		var state pollState
+		if redis.GetCachedPoll(ctx, messageID.String(), &state) == nil {
+			states[messageID] = state
+			continue
+		}
+		missing = append(missing, messageID)
+	}
+	if len(missing) == 0 {
+		return states, nil
+	}
+
+	loaded, err := s.loadPollStates(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for messageID, state := range loaded {
+		states[messageID] = state
+		redis.CachePoll(ctx, messageID.String(), state.Poll.Version, state)
+	}
+
+	return states, nil
+}
+
+// loadPollStates reads the polls with their ballots from the database. The polls are read first,
+// so the ballots are at least as new as the version of the state they are cached with.
+func (s *server) loadPollStates(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID]pollState, error) {
+	polls, err := s.db.ListPolls(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ballots, err := s.db.ListPollBallots(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[uuid.UUID]pollState, len(polls))
+	for _, poll := range polls {
+		states[poll.MessageID] = pollState{Poll: poll}
+	}
+	for _, ballot := range ballots {
+		state := states[ballot.MessageID]
+		state.Ballots = append(state.Ballots, ballot)
+		states[ballot.MessageID] = state
+	}
+
+	return states, nil
+}
+
+// setPollResults counts the ballots into the results of the poll. The counts are derived from the
+// stored ballots, where every voter has exactly one, so they can't drift under concurrent votes.
+func setPollResults(poll *pb.Poll, state pollState, userID uuid.UUID) {
+	results := make([]*pb.PollResult, len(poll.GetOptions()))
+	for i := range results {
+		results[i] = &pb.PollResult{Option: int32(i)}
+	}
+
+	for _, ballot := range state.Ballots {
+		for _, option := range ballot.Options {
+			if int(option) >= len(results) {
+				continue
+			}
+			results[option].Votes++
+			if !state.Poll.Anonymous {
+				results[option].VoterIds = append(results[option].VoterIds, ballot.UserID.String())
+			}
+		}
+		if ballot.UserID == userID {
+			poll.MyVotes = ballot.Options
+		}
+	}
+
+	poll.Results = results
+	poll.TotalVoters = int32(len(state.Ballots))
+	poll.ClosedAt = nullTimeToProto(state.Poll.ClosedAt)
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxPresenceUsers limits how many users GetPresence and StreamEvents accept at once.
+const maxPresenceUsers = 100
+
+func (s *server) SetTyping(ctx context.Context, req *pb.SetTypingRequest) (*pb.SetTypingResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - SetTyping", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - SetTyping", err)
+	}
+
+	partnerID, err := uuid.Parse(req.GetPartnerId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse partner id - SetTyping", err)
+	}
+
+	// Typing is shown to the partner, so it needs the same permission as a message.
+	_, err = s.checkCanMessage(ctx, userID, partnerID, "SetTyping")
+	if err != nil {
+		return nil, err
+	}
+
+	err = publishEvent(ctx, redis.UserEventsChannel(partnerID.String()), &pb.Event{
+		Event: &pb.Event_Typing{
+			Typing: &pb.TypingEvent{
+				UserId: userID.String(),
+				Typing: req.GetTyping(),
+			},
+		},
+	})
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't publish typing event - SetTyping", err)
+	}
+
+	return &pb.SetTypingResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) GetPresence(ctx context.Context, req *pb.GetPresenceRequest) (*pb.GetPresenceResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - GetPresence", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - GetPresence", err)
+	}
+
+	userIDs, err := parsePresenceUserIDs(ctx, req.GetUserIds(), "GetPresence")
+	if err != nil {
+		return nil, err
+	}
+
+	presences, err := redis.GetPresence(ctx, uuidStrings(userIDs))
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get presence from redis - GetPresence", err)
+	}
+
+	presencesResponse := make([]*pb.Presence, len(userIDs))
+	for i, presenceUserID := range userIDs {
+		presencesResponse[i], err = s.visiblePresence(ctx, userID, presenceUserID, presences[i])
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't check presence visibility - GetPresence", err)
+		}
+	}
+
+	return &pb.GetPresenceResponse{
+		Presences: presencesResponse,
+	}, nil
+}
+
+// StreamEvents streams the real-time events addressed to the caller, and the presence changes
+// of the requested users. The caller is online while the stream is open.
+func (s *server) StreamEvents(req *pb.StreamEventsRequest, stream pb.MessageService_StreamEventsServer) error {
+	ctx := stream.Context()
+
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - StreamEvents", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - StreamEvents", err)
+	}
+
+	channels, err := s.eventChannels(ctx, userID, req.GetPresenceUserIds())
+	if err != nil {
+		return err
+	}
+
+	pubsub := redis.SubscribeEvents(channels...)
+	defer pubsub.Close()
+
+	// Receiving the confirmation makes sure no event published from now on is missed.
+	_, err = pubsub.Receive()
+	if err != nil {
+		return helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't subscribe to events - StreamEvents", err)
+	}
+
+	// Every stream is a connection of its own, so the user stays online until the last one closes.
+	connectionID := uuid.NewString()
+	touchPresence(ctx, userID, connectionID)
+	// The stream's context is already done when it closes, so the presence is updated without
+	// its cancellation.
+	defer s.goOffline(context.WithoutCancel(ctx), userID, connectionID)
+
+	return streamEvents(ctx, userID, connectionID, pubsub.Channel(), stream)
+}
+
+// streamEvents forwards the published events to the stream until the client goes away, keeping
+// the connection open in the meantime.
+func streamEvents(ctx context.Context, userID uuid.UUID, connectionID string, messages <-chan *redis.EventMessage, stream pb.MessageService_StreamEventsServer) error {
+	ticker := time.NewTicker(redis.OnlineTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			touchPresence(ctx, userID, connectionID)
+		case message, ok := <-messages:
+			if !ok {
+				return helper.RespondWithErrorGRPC(ctx, codes.Unavailable, "event subscription closed - StreamEvents", nil)
+			}
+
+			var event pb.Event
+			err := protojson.Unmarshal([]byte(message.Payload), &event)
+			if err != nil {
+				slog.WarnContext(ctx, "can't decode event", "channel", message.Channel, "error", err)
+				continue
+			}
+
+			err = stream.Send(&event)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// eventChannels returns the channels streamed to the user: the user's own events and the
+// presence of the requested users that the user is allowed to see.
+func (s *server) eventChannels(ctx context.Context, userID uuid.UUID, presenceUserIDs []string) ([]string, error) {
+	parsedIDs, err := parsePresenceUserIDs(ctx, presenceUserIDs, "StreamEvents")
+	if err != nil {
+		return nil, err
+	}
+
+	channels := []string{redis.UserEventsChannel(userID.String())}
+	for _, presenceUserID := range parsedIDs {
+		blocked, err := s.isBlockedBetween(ctx, userID, presenceUserID)
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't check block list - StreamEvents", err)
+		}
+		if !blocked {
+			channels = append(channels, redis.PresenceEventsChannel(presenceUserID.String()))
+		}
+	}
+
+	return channels, nil
+}
+
+// visiblePresence applies blocks and the user's last seen setting to the presence shown to the
+// viewer. Users that blocked or were blocked by the viewer always look offline.
+func (s *server) visiblePresence(ctx context.Context, viewerID, userID uuid.UUID, presence redis.Presence) (*pb.Presence, error) {
+	presenceResponse := &pb.Presence{
+		UserId: userID.String(),
+	}
+
+	blocked, err := s.isBlockedBetween(ctx, viewerID, userID)
+	if err != nil || blocked {
+		return presenceResponse, err
+	}
+
+	presenceResponse.Online = presence.Online
+	if presence.LastSeen.IsZero() {
+		return presenceResponse, nil
+	}
+
+	settings, err := s.getPrivacySettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.HideLastSeen {
+		presenceResponse.LastSeen = timestamppb.New(presence.LastSeen)
+	}
+
+	return presenceResponse, nil
+}
+
+// touchPresence marks the user as online and announces it when the user was offline. The
+// connection id is empty for unary calls.
+func touchPresence(ctx context.Context, userID uuid.UUID, connectionID string) {
+	wentOnline, err := redis.TouchPresence(ctx, userID.String(), connectionID, time.Now())
+	if err != nil {
+		slog.WarnContext(ctx, "can't update presence", "user_id", userID, "error", err)
+		return
+	}
+	if !wentOnline {
+		return
+	}
+
+	err = publishEvent(ctx, redis.PresenceEventsChannel(userID.String()), &pb.Event{
+		Event: &pb.Event_Presence{
+			Presence: &pb.Presence{
+				UserId: userID.String(),
+				Online: true,
+			},
+		},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "can't publish presence", "user_id", userID, "error", err)
+	}
+}
+
+// goOffline closes the connection and, when it was the user's last one, marks the user as
+// offline and announces it, with the last seen time unless the user hides it.
+func (s *server) goOffline(ctx context.Context, userID uuid.UUID, connectionID string) {
+	now := time.Now()
+	wentOffline, err := redis.CloseConnection(ctx, userID.String(), connectionID, now)
+	if err != nil {
+		slog.WarnContext(ctx, "can't update presence", "user_id", userID, "error", err)
+		return
+	}
+	if !wentOffline {
+		return
+	}
+
+	presence := &pb.Presence{
+		UserId: userID.String(),
+	}
+
+	settings, err := s.getPrivacySettings(ctx, userID)
+	if err == nil && !settings.HideLastSeen {
+		presence.LastSeen = timestamppb.New(now)
+	}
+
+	err = publishEvent(ctx, redis.PresenceEventsChannel(userID.String()), &pb.Event{
+		Event: &pb.Event_Presence{
+			Presence: presence,
+		},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "can't publish presence", "user_id", userID, "error", err)
+	}
+}
+
+// publishEvent publishes a real-time event to the subscribers of the channel.
+func publishEvent(ctx context.Context, channel string, event *pb.Event) error {
+	event.CreatedAt = timestamppb.Now()
+
+	data, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return redis.PublishEvent(ctx, channel, data)
+}
+
+func parsePresenceUserIDs(ctx context.Context, userIDs []string, method string) ([]uuid.UUID, error) {
+	if len(userIDs) > maxPresenceUsers {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "too many user ids - "+method, nil)
+	}
+
+	parsedIDs := make([]uuid.UUID, len(userIDs))
+	for i, userID := range userIDs {
+		parsedID, err := uuid.Parse(userID)
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse user id - "+method, err)
+		}
+		parsedIDs[i] = parsedID
+	}
+
+	return parsedIDs, nil
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+
+	return values
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/redis"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) GetPrivacySettings(ctx context.Context, req *pb.GetPrivacySettingsRequest) (*pb.GetPrivacySettingsResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - GetPrivacySettings", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - GetPrivacySettings", err)
+	}
+
+	settings, err := s.getPrivacySettings(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get privacy settings from db - GetPrivacySettings", err)
+	}
+
+	return &pb.GetPrivacySettingsResponse{
+		Settings: privacySettingsToProto(settings),
+	}, nil
+}
+
+func (s *server) UpdatePrivacySettings(ctx context.Context, req *pb.UpdatePrivacySettingsRequest) (*pb.UpdatePrivacySettingsResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - UpdatePrivacySettings", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - UpdatePrivacySettings", err)
+	}
+
+	if req.GetMessagesFrom() == pb.MessagesFrom_MESSAGES_FROM_UNSPECIFIED && req.HideLastSeen == nil && req.HideForwardSender == nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "messages_from, hide_last_seen or hide_forward_sender is required - UpdatePrivacySettings", nil)
+	}
+
+	// Fields left out of the request keep their current value.
+	currentSettings, err := s.getPrivacySettings(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get privacy settings from db - UpdatePrivacySettings", err)
+	}
+
+	settings, err := s.db.UpsertPrivacySettings(ctx, upsertPrivacySettingsParams(currentSettings, req))
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't update privacy settings via db - UpdatePrivacySettings", err)
+	}
+
+	redis.InvalidatePrivacySettings(ctx, userID.String())
+
+	return &pb.UpdatePrivacySettingsResponse{
+		Settings: privacySettingsToProto(settings),
+	}, nil
+}
+
+// upsertPrivacySettingsParams applies the fields set in the request to the current settings.
+func upsertPrivacySettingsParams(currentSettings database.UserPrivacySetting, req *pb.UpdatePrivacySettingsRequest) database.UpsertPrivacySettingsParams {
+	params := database.UpsertPrivacySettingsParams{
+		UserID:            currentSettings.UserID,
+		MessagesFrom:      currentSettings.MessagesFrom,
+		HideLastSeen:      currentSettings.HideLastSeen,
+		HideForwardSender: currentSettings.HideForwardSender,
+	}
+	if req.GetMessagesFrom() != pb.MessagesFrom_MESSAGES_FROM_UNSPECIFIED {
+		params.MessagesFrom = messagesFromToString(req.GetMessagesFrom())
+	}
+	if req.HideLastSeen != nil {
+		params.HideLastSeen = req.GetHideLastSeen()
+	}
+	if req.HideForwardSender != nil {
+		params.HideForwardSender = req.GetHideForwardSender()
+	}
+
+	return params
+}
+
+// checkCanMessage validates that the receiver exists, isn't the sender, hasn't blocked or been
+// blocked by the sender and accepts messages from the sender. It returns the receiver's data.
+func (s *server) checkCanMessage(ctx context.Context, senderID, receiverID uuid.UUID, method string) (database.User, error) {
+	if senderID == receiverID {
+		return database.User{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't send message to yourself - "+method, nil)
+	}
+
+	receiver, err := s.getUser(ctx, receiverID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, helper.RespondWithReasonGRPC(ctx, helper.ReasonReceiverNotFound, "receiver not found - "+method, err)
+	}
+	if err != nil {
+		return database.User{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get receiver's data by id - "+method, err)
+	}
+
+	err = s.checkNotBlocked(ctx, senderID, receiverID, method)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	settings, err := s.getPrivacySettings(ctx, receiverID)
+	if err != nil {
+		return database.User{}, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get receiver's privacy settings - "+method, err)
+	}
+
+	if !acceptsMessagesFrom(receiver, settings.MessagesFrom, senderID) {
+		return database.User{}, helper.RespondWithReasonGRPC(ctx, helper.ReasonPrivacyRestricted, "receiver doesn't accept messages from you - "+method, nil)
+	}
+
+	return receiver, nil
+}
+
+// getPrivacySettings returns the user's privacy settings, reading them from Redis when possible.
+// Users that never changed their settings get the defaults.
+func (s *server) getPrivacySettings(ctx context.Context, userID uuid.UUID) (database.UserPrivacySetting, error) {
+	var settings database.UserPrivacySetting
+	err := redis.GetCachedPrivacySettings(ctx, userID.String(), &settings)
+	if err == nil {
+		return settings, nil
+	}
+
+	settings, err = s.db.GetPrivacySettings(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		settings = defaultPrivacySettings(userID)
+	} else if err != nil {
+		return database.UserPrivacySetting{}, err
+	}
+
+	redis.CachePrivacySettings(ctx, userID.String(), settings)
+
+	return settings, nil
+}
+
+func defaultPrivacySettings(userID uuid.UUID) database.UserPrivacySetting {
+	return database.UserPrivacySetting{
+		UserID:       userID,
+		MessagesFrom: messagesFromToString(pb.MessagesFrom_MESSAGES_FROM_EVERYONE),
+	}
+}
+
+// acceptsMessagesFrom applies the receiver's messages_from setting to the sender.
+func acceptsMessagesFrom(receiver database.User, messagesFrom string, senderID uuid.UUID) bool {
+	subscribesToSender := slices.Contains(receiver.SubscribedTo, senderID)
+	subscribedBySender := slices.Contains(receiver.Subscribers, senderID)
+
+	switch messagesFromFromString(messagesFrom) {
+	case pb.MessagesFrom_MESSAGES_FROM_SUBSCRIPTIONS:
+		return subscribesToSender
+	case pb.MessagesFrom_MESSAGES_FROM_SUBSCRIBERS:
+		return subscribedBySender
+	case pb.MessagesFrom_MESSAGES_FROM_MUTUAL:
+		return subscribesToSender && subscribedBySender
+	default:
+		return true
+	}
+}
+
+func messagesFromToString(messagesFrom pb.MessagesFrom) string {
+	return strings.ToLower(strings.TrimPrefix(messagesFrom.String(), "MESSAGES_FROM_"))
+}
+
+func messagesFromFromString(messagesFrom string) pb.MessagesFrom {
+	return pb.MessagesFrom(pb.MessagesFrom_value["MESSAGES_FROM_"+strings.ToUpper(messagesFrom)])
+}
+
+func privacySettingsToProto(settings database.UserPrivacySetting) *pb.PrivacySettings {
+	settingsResponse := &pb.PrivacySettings{
+		MessagesFrom:      messagesFromFromString(settings.MessagesFrom),
+		HideLastSeen:      settings.HideLastSeen,
+		HideForwardSender: settings.HideForwardSender,
+	}
+	if !settings.UpdatedAt.IsZero() {
+		settingsResponse.UpdatedAt = timestamppb.New(settings.UpdatedAt)
+	}
+
+	return settingsResponse
+}
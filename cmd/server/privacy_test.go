@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// privacySettingsRow returns the columns of the settings as GetPrivacySettings selects them.
+func privacySettingsRow(settings database.UserPrivacySetting) []driver.Value {
+	return []driver.Value{
+		settings.UserID.String(),
+		settings.MessagesFrom,
+		time.Now(),
+		settings.HideLastSeen,
+		settings.HideForwardSender,
+	}
+}
+
+// usersPrivacySettings answers GetPrivacySettings with the settings of the user, or no rows for
+// users with the defaults.
+func usersPrivacySettings(settings ...database.UserPrivacySetting) fakeAnswer {
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		for _, userSettings := range settings {
+			if args[0] == userSettings.UserID.String() {
+				return [][]driver.Value{privacySettingsRow(userSettings)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+func TestAcceptsMessagesFrom(t *testing.T) {
+	senderID := uuid.New()
+
+	tests := []struct {
+		name         string
+		messagesFrom string
+		subscribes   bool
+		subscribed   bool
+		want         bool
+	}{
+		{name: "everyone", messagesFrom: "everyone", want: true},
+		{name: "unknown setting", messagesFrom: "", want: true},
+		{name: "subscriptions, receiver follows sender", messagesFrom: "subscriptions", subscribes: true, want: true},
+		{name: "subscriptions, sender follows receiver", messagesFrom: "subscriptions", subscribed: true, want: false},
+		{name: "subscribers, sender follows receiver", messagesFrom: "subscribers", subscribed: true, want: true},
+		{name: "subscribers, receiver follows sender", messagesFrom: "subscribers", subscribes: true, want: false},
+		{name: "mutual, both follow", messagesFrom: "mutual", subscribes: true, subscribed: true, want: true},
+		{name: "mutual, receiver follows sender", messagesFrom: "mutual", subscribes: true, want: false},
+		{name: "mutual, sender follows receiver", messagesFrom: "mutual", subscribed: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := database.User{ID: uuid.New()}
+			if tt.subscribes {
+				receiver.SubscribedTo = []uuid.UUID{senderID}
+			}
+			if tt.subscribed {
+				receiver.Subscribers = []uuid.UUID{senderID}
+			}
+
+			if got := acceptsMessagesFrom(receiver, tt.messagesFrom, senderID); got != tt.want {
+				t.Errorf("acceptsMessagesFrom() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCanMessage(t *testing.T) {
+	senderID := uuid.New()
+	follower := database.User{ID: uuid.New(), SubscribedTo: []uuid.UUID{senderID}}
+	stranger := database.User{ID: uuid.New()}
+
+	tests := []struct {
+		name         string
+		receiverID   uuid.UUID
+		messagesFrom string
+		wantCode     codes.Code
+		wantReason   helper.Reason
+	}{
+		{
+			name:       "yourself",
+			receiverID: senderID,
+			wantCode:   codes.InvalidArgument,
+			wantReason: helper.ReasonInvalidArgument,
+		},
+		{
+			name:       "receiver not found",
+			receiverID: uuid.New(),
+			wantCode:   codes.NotFound,
+			wantReason: helper.ReasonReceiverNotFound,
+		},
+		{
+			name:       "default settings",
+			receiverID: stranger.ID,
+			wantCode:   codes.OK,
+		},
+		{
+			name:         "subscriptions only, receiver follows sender",
+			receiverID:   follower.ID,
+			messagesFrom: "subscriptions",
+			wantCode:     codes.OK,
+		},
+		{
+			name:         "subscriptions only, stranger",
+			receiverID:   stranger.ID,
+			messagesFrom: "subscriptions",
+			wantCode:     codes.PermissionDenied,
+			wantReason:   helper.ReasonPrivacyRestricted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getPrivacySettings := returns()
+			if tt.messagesFrom != "" {
+				getPrivacySettings = returns(privacySettingsRow(database.UserPrivacySetting{
+					UserID:       tt.receiverID,
+					MessagesFrom: tt.messagesFrom,
+				}))
+			}
+
+			s, _ := newTestServer(t, map[string]fakeAnswer{
+				"GetUserByID":        usersByID(follower, stranger),
+				"ListBlockedUsers":   blockLists(nil),
+				"GetPrivacySettings": getPrivacySettings,
+			}, Options{})
+
+			receiver, err := s.checkCanMessage(context.Background(), senderID, tt.receiverID, "SendMessage")
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("checkCanMessage() code = %v, want %v (error %v)", got, tt.wantCode, err)
+			}
+			if err != nil {
+				if got := errorReason(err); got != tt.wantReason {
+					t.Errorf("checkCanMessage() reason = %q, want %q", got, tt.wantReason)
+				}
+				return
+			}
+			if receiver.ID != tt.receiverID {
+				t.Errorf("checkCanMessage() receiver = %v, want %v", receiver.ID, tt.receiverID)
+			}
+		})
+	}
+}
+
+func TestSendMessageValidatesReceiver(t *testing.T) {
+	senderID := uuid.New()
+
+	tests := []struct {
+		name       string
+		receiverID string
+		wantCode   codes.Code
+	}{
+		{name: "invalid id", receiverID: "not-a-uuid", wantCode: codes.InvalidArgument},
+		{name: "yourself", receiverID: senderID.String(), wantCode: codes.InvalidArgument},
+		{name: "unknown receiver", receiverID: uuid.NewString(), wantCode: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := newTestServer(t, map[string]fakeAnswer{
+				"GetUserByID": usersByID(),
+			}, Options{})
+
+			_, err := s.SendMessage(userContext(t, senderID), &pb.SendMessageRequest{
+				ReceiverId: tt.receiverID,
+				Content:    "hello",
+			})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("SendMessage() code = %v, want %v (error %v)", got, tt.wantCode, err)
+			}
+			if db.ran("SendMessage") {
+				t.Error("SendMessage() stored a message for an invalid receiver")
+			}
+		})
+	}
+}
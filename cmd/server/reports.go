@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/moderation"
+	"github.com/imhasandl/message-service/internal/rabbitmq"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultReportsLimit = 50
+	maxReportsLimit     = 200
+)
+
+func (s *server) ReportMessage(ctx context.Context, req *pb.ReportMessageRequest) (*pb.ReportMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ReportMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ReportMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetMessageId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - ReportMessage", err)
+	}
+
+	if req.GetReason() == pb.ReportReason_REPORT_REASON_UNSPECIFIED {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "report reason is required - ReportMessage", nil)
+	}
+
+	message, err := s.getParticipantMessage(ctx, messageID, userID, "ReportMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	createReportParams := database.CreateReportParams{
+		ID:              uuid.New(),
+		ReportedBy:      userID,
+		Reason:          req.GetComment(),
+		MessageID:       uuid.NullUUID{UUID: message.ID, Valid: true},
+		ReportedUserID:  uuid.NullUUID{UUID: message.SenderID, Valid: true},
+		Category:        reportReasonToCategory(req.GetReason()),
+		ContentSnapshot: message.Content,
+	}
+
+	report, err := s.createReport(ctx, createReportParams)
+	if isUniqueViolation(err) {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.AlreadyExists, "message is already reported - ReportMessage", err)
+	}
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't create report via db - ReportMessage", err)
+	}
+
+	return &pb.ReportMessageResponse{
+		Report: reportToProto(report),
+	}, nil
+}
+
+func (s *server) ListReports(ctx context.Context, req *pb.ListReportsRequest) (*pb.ListReportsResponse, error) {
+	_, err := s.authorizeModerator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reportStatus := req.GetStatus()
+	if reportStatus == pb.ReportStatus_REPORT_STATUS_UNSPECIFIED {
+		reportStatus = pb.ReportStatus_REPORT_STATUS_OPEN
+	}
+
+	limit := req.GetLimit()
+	if limit <= 0 {
+		limit = defaultReportsLimit
+	}
+	limit = min(limit, maxReportsLimit)
+
+	listReportsParams := database.ListReportsParams{
+		Status: reportStatusToString(reportStatus),
+		Limit:  limit,
+		Offset: max(req.GetOffset(), 0),
+	}
+
+	reports, err := s.db.ListReports(ctx, listReportsParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't list reports from db - ListReports", err)
+	}
+
+	err = s.openReports(ctx, reports)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt reports - ListReports", err)
+	}
+
+	reportsResponse := make([]*pb.Report, len(reports))
+	for i, report := range reports {
+		reportsResponse[i] = reportToProto(report)
+	}
+
+	return &pb.ListReportsResponse{
+		Reports: reportsResponse,
+	}, nil
+}
+
+func (s *server) ResolveReport(ctx context.Context, req *pb.ResolveReportRequest) (*pb.ResolveReportResponse, error) {
+	moderatorID, err := s.authorizeModerator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reportID, err := uuid.Parse(req.GetReportId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse report id - ResolveReport", err)
+	}
+
+	if req.GetStatus() != pb.ReportStatus_REPORT_STATUS_RESOLVED && req.GetStatus() != pb.ReportStatus_REPORT_STATUS_DISMISSED {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "status must be resolved or dismissed - ResolveReport", nil)
+	}
+
+	resolveReportParams := database.ResolveReportParams{
+		ID:             reportID,
+		Status:         reportStatusToString(req.GetStatus()),
+		ResolvedBy:     uuid.NullUUID{UUID: moderatorID, Valid: true},
+		ResolutionNote: sql.NullString{String: req.GetResolutionNote(), Valid: req.GetResolutionNote() != ""},
+	}
+
+	report, err := s.db.ResolveReport(ctx, resolveReportParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "open report not found - ResolveReport", err)
+	}
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't resolve report via db - ResolveReport", err)
+	}
+
+	reports := []database.Report{report}
+	err = s.openReports(ctx, reports)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt report - ResolveReport", err)
+	}
+
+	return &pb.ResolveReportResponse{
+		Report: reportToProto(reports[0]),
+	}, nil
+}
+
+// createReport stores the report with its content snapshot sealed at rest and publishes a
+// moderation event about it. The report is already visible to moderators once stored, so a
+// failed event is only logged.
+func (s *server) createReport(ctx context.Context, params database.CreateReportParams) (database.Report, error) {
+	snapshot := params.ContentSnapshot
+	sealed, err := s.sealText(ctx, snapshot, reportAdditionalData(params.ID))
+	if err != nil {
+		return database.Report{}, err
+	}
+	params.ContentSnapshot = sealed.Content
+	params.ContentSnapshotCiphertext = sealed.Ciphertext
+	params.ContentSnapshotDataKey = sealed.DataKey
+	params.ContentSnapshotKeyID = sealed.KeyID
+
+	report, err := s.db.CreateReport(ctx, params)
+	if err != nil {
+		return database.Report{}, err
+	}
+	report.ContentSnapshot = snapshot
+
+	err = s.rabbitmq.PublishJSON(ctx, rabbitmq.ModerationRoutingKey, map[string]interface{}{
+		"event":            "message_reported",
+		"report_id":        report.ID.String(),
+		"message_id":       params.MessageID.UUID.String(),
+		"reported_by":      report.ReportedBy.String(),
+		"reported_user_id": params.ReportedUserID.UUID.String(),
+		"category":         report.Category,
+		"reported_at":      report.ReportedAt,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "can't publish moderation event", "report_id", report.ID, "error", err)
+	}
+
+	return report, nil
+}
+
+// reportFlaggedMessage creates an automatic report for a message flagged by the moderation
+// pipeline. The message is already stored, so failures are only logged. A message flagged again
+// after a change keeps its first automatic report.
+func (s *server) reportFlaggedMessage(ctx context.Context, message database.Message, result moderation.Result) {
+	createReportParams := database.CreateReportParams{
+		ID:              uuid.New(),
+		ReportedBy:      moderation.SystemReporterID,
+		Reason:          fmt.Sprintf("%s: %s", result.Filter, result.Reason),
+		MessageID:       uuid.NullUUID{UUID: message.ID, Valid: true},
+		ReportedUserID:  uuid.NullUUID{UUID: message.SenderID, Valid: true},
+		Category:        reportReasonToCategory(pb.ReportReason_REPORT_REASON_AUTOMATED),
+		ContentSnapshot: message.Content,
+	}
+
+	_, err := s.createReport(ctx, createReportParams)
+	if isUniqueViolation(err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "can't create automatic report", "message_id", message.ID, "error", err)
+	}
+}
+
+// authorizeModerator validates the caller's token and checks that the caller is a moderator.
+func (s *server) authorizeModerator(ctx context.Context) (uuid.UUID, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - authorizeModerator", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - authorizeModerator", err)
+	}
+
+	if !slices.Contains(s.options.ModeratorIDs, userID) {
+		return uuid.Nil, helper.RespondWithErrorGRPC(ctx, codes.PermissionDenied, "only moderators can manage reports - authorizeModerator", nil)
+	}
+
+	return userID, nil
+}
+
+func reportReasonToCategory(reason pb.ReportReason) string {
+	return strings.ToLower(strings.TrimPrefix(reason.String(), "REPORT_REASON_"))
+}
+
+func reportStatusToString(reportStatus pb.ReportStatus) string {
+	return strings.ToLower(strings.TrimPrefix(reportStatus.String(), "REPORT_STATUS_"))
+}
+
+func reportToProto(report database.Report) *pb.Report {
+	reportResponse := &pb.Report{
+		Id:              report.ID.String(),
+		ReportedBy:      report.ReportedBy.String(),
+		Reason:          pb.ReportReason(pb.ReportReason_value["REPORT_REASON_"+strings.ToUpper(report.Category)]),
+		Comment:         report.Reason,
+		ContentSnapshot: report.ContentSnapshot,
+		Status:          pb.ReportStatus(pb.ReportStatus_value["REPORT_STATUS_"+strings.ToUpper(report.Status)]),
+		ReportedAt:      timestamppb.New(report.ReportedAt),
+		ResolutionNote:  report.ResolutionNote.String,
+	}
+
+	if report.MessageID.Valid {
+		reportResponse.MessageId = report.MessageID.UUID.String()
+	}
+	if report.ReportedUserID.Valid {
+		reportResponse.ReportedUserId = report.ReportedUserID.UUID.String()
+	}
+	if report.ResolvedBy.Valid {
+		reportResponse.ResolvedBy = report.ResolvedBy.UUID.String()
+	}
+	if report.ResolvedAt.Valid {
+		reportResponse.ResolvedAt = timestamppb.New(report.ResolvedAt.Time)
+	}
+
+	return reportResponse
+}
+
+// isUniqueViolation reports whether the error is a postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isConstraintViolation reports whether the error is a unique violation of the named constraint.
+func isConstraintViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
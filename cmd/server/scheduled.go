@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maxScheduleAhead limits how far in the future a message can be scheduled.
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// schedulerInterval is how often the scheduler looks for messages that are due.
+	schedulerInterval = 5 * time.Second
+	// schedulerBatchSize is how many due messages a scheduler run delivers per query.
+	schedulerBatchSize = 100
+)
+
+func (s *server) ListScheduledMessages(ctx context.Context, req *pb.ListScheduledMessagesRequest) (*pb.ListScheduledMessagesResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ListScheduledMessages", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ListScheduledMessages", err)
+	}
+
+	messages, err := s.db.ListScheduledMessages(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get scheduled messages from db - ListScheduledMessages", err)
+	}
+
+	err = s.openMessages(ctx, messages)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt messages - ListScheduledMessages", err)
+	}
+
+	messagesResponse := make([]*pb.Message, len(messages))
+	for i, message := range messages {
+		messagesResponse[i] = messageToProto(message)
+	}
+
+	return &pb.ListScheduledMessagesResponse{
+		Messages: messagesResponse,
+	}, nil
+}
+
+func (s *server) RescheduleMessage(ctx context.Context, req *pb.RescheduleMessageRequest) (*pb.RescheduleMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - RescheduleMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - RescheduleMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - RescheduleMessage", err)
+	}
+
+	if req.GetSendAt() == nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "send_at is required - RescheduleMessage", nil)
+	}
+
+	sendAt, err := parseSendAt(ctx, req.GetSendAt(), "RescheduleMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	rescheduleMessageParams := database.RescheduleMessageParams{
+		ID:       messageID,
+		SenderID: userID,
+		SendAt:   sendAt,
+	}
+
+	// Messages the scheduler already delivered aren't scheduled anymore and are reported as not found.
+	message, err := s.db.RescheduleMessage(ctx, rescheduleMessageParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "scheduled message not found - RescheduleMessage", err)
+	}
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't reschedule message - RescheduleMessage", err)
+	}
+
+	err = s.openMessage(ctx, &message)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt message - RescheduleMessage", err)
+	}
+
+	return &pb.RescheduleMessageResponse{
+		Message: messageToProto(message),
+	}, nil
+}
+
+func (s *server) CancelScheduledMessage(ctx context.Context, req *pb.CancelScheduledMessageRequest) (*pb.CancelScheduledMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - CancelScheduledMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - CancelScheduledMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - CancelScheduledMessage", err)
+	}
+
+	cancelScheduledMessageParams := database.CancelScheduledMessageParams{
+		ID:       messageID,
+		SenderID: userID,
+	}
+
+	deleted, err := s.db.CancelScheduledMessage(ctx, cancelScheduledMessageParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't cancel scheduled message - CancelScheduledMessage", err)
+	}
+	if deleted == 0 {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "scheduled message not found - CancelScheduledMessage", nil)
+	}
+
+	return &pb.CancelScheduledMessageResponse{
+		Success: true,
+	}, nil
+}
+
+// parseSendAt validates the delivery time of a scheduled message. A missing time means the
+// message is sent right away.
+func parseSendAt(ctx context.Context, sendAt *timestamppb.Timestamp, method string) (sql.NullTime, error) {
+	if sendAt == nil {
+		return sql.NullTime{}, nil
+	}
+
+	if err := sendAt.CheckValid(); err != nil {
+		return sql.NullTime{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "invalid send_at - "+method, err)
+	}
+
+	now := time.Now()
+	sendAtTime := sendAt.AsTime()
+	if !sendAtTime.After(now) {
+		return sql.NullTime{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "send_at must be in the future - "+method, nil)
+	}
+	if sendAtTime.After(now.Add(maxScheduleAhead)) {
+		return sql.NullTime{}, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "send_at is too far in the future - "+method, nil)
+	}
+
+	return sql.NullTime{Time: sendAtTime, Valid: true}, nil
+}
+
+// runScheduler periodically delivers the scheduled messages that are due.
+func (s *server) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverScheduledMessages(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverScheduledMessages delivers the due messages. A message that can't be delivered stays
+// scheduled, so a run stops once a batch delivers nothing and the next run tries again.
+func (s *server) deliverScheduledMessages(ctx context.Context) {
+	for ctx.Err() == nil {
+		messageIDs, err := s.db.ListDueScheduledMessages(ctx, schedulerBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "can't list due scheduled messages", "error", err)
+			return
+		}
+
+		delivered := 0
+		for _, messageID := range messageIDs {
+			ok, err := s.deliverScheduledMessage(ctx, messageID)
+			if err != nil {
+				slog.ErrorContext(ctx, "can't deliver scheduled message", "message_id", messageID, "error", err)
+				continue
+			}
+			if ok {
+				delivered++
+			}
+		}
+
+		if len(messageIDs) < schedulerBatchSize || delivered == 0 {
+			return
+		}
+	}
+}
+
+// deliverScheduledMessage claims a due message with SKIP LOCKED, so every message is delivered by
+// one replica, and announces it. The claim commits only once the notification is published, so a
+// message whose notification fails, or is cut short by a shutdown, stays scheduled. Either user
+// may have blocked the other or the receiver may have changed their privacy settings since the
+// message was scheduled, so a message the receiver no longer accepts is deleted instead. It
+// returns false when the message was claimed by another replica or is no longer scheduled.
+func (s *server) deliverScheduledMessage(ctx context.Context, messageID uuid.UUID) (bool, error) {
+	var message database.Message
+	claimed, deliverable := false, false
+
+	// Once the notification is published the claim is committed, even when ctx is cancelled.
+	err := s.inTx(context.WithoutCancel(ctx), func(q *database.Queries) error {
+		var err error
+		message, err = q.ClaimScheduledMessage(ctx, messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		claimed = true
+
+		deliverable, err = s.canDeliverScheduledMessage(ctx, message.SenderID, message.ReceiverID)
+		if err != nil {
+			return err
+		}
+		if !deliverable {
+			slog.InfoContext(ctx, "dropping scheduled message the receiver no longer accepts", "message_id", message.ID)
+			return q.DeleteMessage(ctx, message.ID)
+		}
+
+		isMessageRequest, err := s.isPendingMessageRequest(ctx, message.SenderID, message.ReceiverID)
+		if err != nil {
+			return err
+		}
+
+		return s.announceMessage(ctx, message, isMessageRequest)
+	})
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	// The caches are refreshed after the commit, so a read in between can't cache the message
+	// as still scheduled.
+	if deliverable {
+		refreshConversationCaches(ctx, message)
+	}
+
+	return true, nil
+}
+
+// canDeliverScheduledMessage repeats the block and privacy checks of SendMessage for a scheduled
+// message.
+func (s *server) canDeliverScheduledMessage(ctx context.Context, senderID, receiverID uuid.UUID) (bool, error) {
+	blocked, err := s.isBlockedBetween(ctx, senderID, receiverID)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	receiver, err := s.getUser(ctx, receiverID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	settings, err := s.getPrivacySettings(ctx, receiverID)
+	if err != nil {
+		return false, err
+	}
+
+	return acceptsMessagesFrom(receiver, settings.MessagesFrom, senderID), nil
+}
+
+// isPendingMessageRequest reports whether the sender's messages are still waiting in the
+// receiver's requests inbox.
+func (s *server) isPendingMessageRequest(ctx context.Context, senderID, receiverID uuid.UUID) (bool, error) {
+	getMessageRequestParams := database.GetMessageRequestParams{
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+	}
+
+	messageRequest, err := s.db.GetMessageRequest(ctx, getMessageRequestParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return messageRequest.Status == messageRequestPending, nil
+}
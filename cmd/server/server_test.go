@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/metrics"
+	"github.com/imhasandl/message-service/internal/redis"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testTokenSecret = "test-secret"
+
+// TestMain points the cache at an address nothing listens on, so every cache read misses and the
+// handlers read the fake database.
+func TestMain(m *testing.M) {
+	redis.Client = goredis.NewClient(&goredis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+	})
+
+	code := m.Run()
+	redis.Client.Close()
+	os.Exit(code)
+}
+
+// fakeAnswer returns the rows of a query for its arguments. An exec affects as many rows as the
+// answer returns.
+type fakeAnswer func(args []driver.Value) ([][]driver.Value, error)
+
+// returns answers a query with the same rows whatever its arguments.
+func returns(rows ...[]driver.Value) fakeAnswer {
+	return func([]driver.Value) ([][]driver.Value, error) {
+		return rows, nil
+	}
+}
+
+// fails answers a query with the error.
+func fails(err error) fakeAnswer {
+	return func([]driver.Value) ([][]driver.Value, error) {
+		return nil, err
+	}
+}
+
+// fakeDB is a database/sql driver that answers the sqlc queries by their name, so handlers can be
+// tested without Postgres. A query without an answer fails the test.
+type fakeDB struct {
+	t       *testing.T
+	answers map[string]fakeAnswer
+	// queries are the names of the queries run, in order.
+	queries   []string
+	commits   int
+	rollbacks int
+}
+
+// newTestServer returns a server on a fake database with the answers.
+func newTestServer(t *testing.T, answers map[string]fakeAnswer, options Options) (*server, *fakeDB) {
+	t.Helper()
+
+	db := &fakeDB{t: t, answers: answers}
+	dbConn := sql.OpenDB(db)
+	t.Cleanup(func() { dbConn.Close() })
+
+	return NewServer(dbConn, testTokenSecret, nil, options).(*server), db
+}
+
+// ran reports whether the query with the name was run.
+func (db *fakeDB) ran(name string) bool {
+	return slices.Contains(db.queries, name)
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{db}, nil
+}
+
+func (db *fakeDB) Driver() driver.Driver {
+	return db
+}
+
+func (db *fakeDB) Open(string) (driver.Conn, error) {
+	return fakeConn{db}, nil
+}
+
+func (db *fakeDB) answer(query string, named []driver.NamedValue) ([][]driver.Value, error) {
+	name := metrics.QueryName(query)
+	db.queries = append(db.queries, name)
+
+	answer, ok := db.answers[name]
+	if !ok {
+		db.t.Errorf("unexpected query %s", name)
+		return nil, fmt.Errorf("no answer for query %s", name)
+	}
+
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	return answer(args)
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements aren't supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx(c), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.rollbacks++
+	return nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// userContext returns a context with the access token of the user, as the gateway sends it.
+func userContext(t *testing.T, userID uuid.UUID) context.Context {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(postService.TokenTypeAccess),
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte(testTokenSecret))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// errorReason returns the ErrorInfo reason of a gRPC error.
+func errorReason(err error) helper.Reason {
+	for _, detail := range status.Convert(err).Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			return helper.Reason(errorInfo.GetReason())
+		}
+	}
+	return ""
+}
+
+// nullable returns the value when it is valid and NULL otherwise.
+func nullable(value driver.Value, valid bool) driver.Value {
+	if !valid {
+		return nil
+	}
+	return value
+}
+
+func uuidArray(ids []uuid.UUID) []byte {
+	elements := make([]string, len(ids))
+	for i, id := range ids {
+		elements[i] = id.String()
+	}
+	return []byte("{" + strings.Join(elements, ",") + "}")
+}
+
+// userRow returns the columns of the user as GetUserByID selects them.
+func userRow(user database.User) []driver.Value {
+	return []driver.Value{
+		user.ID.String(),
+		user.CreatedAt,
+		user.UpdatedAt,
+		user.Email,
+		user.Password,
+		user.Username,
+		uuidArray(user.Subscribers),
+		uuidArray(user.SubscribedTo),
+		user.IsPremium,
+		int64(user.VerificationCode),
+		user.VerificationExpireTime,
+		user.IsVerified,
+	}
+}
+
+// usersByID answers GetUserByID with the user of the id, or no rows for unknown ids.
+func usersByID(users ...database.User) fakeAnswer {
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		for _, user := range users {
+			if args[0] == user.ID.String() {
+				return [][]driver.Value{userRow(user)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// messageRow returns the columns of the message as the message queries select them.
+func messageRow(message database.Message) []driver.Value {
+	return []driver.Value{
+		message.ID.String(),
+		message.SentAt,
+		message.SenderID.String(),
+		message.ReceiverID.String(),
+		message.Content,
+		nullable(message.ClientMessageID.String, message.ClientMessageID.Valid),
+		message.IsEncrypted,
+		message.EncryptedPayload,
+		[]byte(message.EncryptionHeaders),
+		message.ContentCiphertext,
+		message.ContentDataKey,
+		nullable(message.ContentKeyID.String, message.ContentKeyID.Valid),
+		nullable(message.SendAt.Time, message.SendAt.Valid),
+		nullable(message.ReadAt.Time, message.ReadAt.Valid),
+		nullable(message.ExpiresAt.Time, message.ExpiresAt.Valid),
+		nullable(int64(message.DisappearAfterSeconds.Int32), message.DisappearAfterSeconds.Valid),
+		message.DisappearAfterRead,
+		nullable(message.SystemEvent.String, message.SystemEvent.Valid),
+		nullable(message.ForwardedFromSenderID.UUID.String(), message.ForwardedFromSenderID.Valid),
+		nullable(message.ForwardedFromSentAt.Time, message.ForwardedFromSentAt.Valid),
+		message.Kind,
+		[]byte(message.Payload),
+		message.PayloadCiphertext,
+		nullable(message.NotifiedAt.Time, message.NotifiedAt.Valid),
+	}
+}
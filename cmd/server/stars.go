@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/cmd/helper"
+	"github.com/imhasandl/message-service/internal/database"
+	pb "github.com/imhasandl/message-service/protos"
+	"github.com/imhasandl/post-service/cmd/auth"
+	postService "github.com/imhasandl/post-service/cmd/helper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultStarredMessagesLimit = 50
+	maxStarredMessagesLimit     = 200
+)
+
+// StarMessage saves a message of one of the user's conversations. Stars are private, the other
+// participant is neither told nor able to see them. Starring a message twice keeps the first star.
+func (s *server) StarMessage(ctx context.Context, req *pb.StarMessageRequest) (*pb.StarMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - StarMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - StarMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - StarMessage", err)
+	}
+
+	message, err := s.getParticipantMessage(ctx, messageID, userID, "StarMessage")
+	if err != nil {
+		return nil, err
+	}
+
+	starMessageParams := database.StarMessageParams{
+		UserID:    userID,
+		MessageID: message.ID,
+	}
+
+	err = s.db.StarMessage(ctx, starMessageParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't star message via db - StarMessage", err)
+	}
+
+	return &pb.StarMessageResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *server) UnstarMessage(ctx context.Context, req *pb.UnstarMessageRequest) (*pb.UnstarMessageResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - UnstarMessage", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - UnstarMessage", err)
+	}
+
+	messageID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't parse message id - UnstarMessage", err)
+	}
+
+	unstarMessageParams := database.UnstarMessageParams{
+		UserID:    userID,
+		MessageID: messageID,
+	}
+
+	deleted, err := s.db.UnstarMessage(ctx, unstarMessageParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't unstar message via db - UnstarMessage", err)
+	}
+	if deleted == 0 {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "message isn't starred - UnstarMessage", nil)
+	}
+
+	return &pb.UnstarMessageResponse{
+		Success: true,
+	}, nil
+}
+
+// ListStarredMessages returns the user's starred messages of all conversations, most recently
+// starred first. Deleted messages lose their stars with them and expired ones are left out.
+func (s *server) ListStarredMessages(ctx context.Context, req *pb.ListStarredMessagesRequest) (*pb.ListStarredMessagesResponse, error) {
+	accessToken, err := auth.GetBearerTokenFromGrpc(ctx)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get token from header - ListStarredMessages", err)
+	}
+
+	userID, err := postService.ValidateJWT(accessToken, s.tokenSecret)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "can't get user id from token - ListStarredMessages", err)
+	}
+
+	limit := req.GetLimit()
+	if limit <= 0 {
+		limit = defaultStarredMessagesLimit
+	}
+	limit = min(limit, maxStarredMessagesLimit)
+
+	listStarredMessagesParams := database.ListStarredMessagesParams{
+		UserID: userID,
+		Limit:  limit,
+		Offset: max(req.GetOffset(), 0),
+	}
+
+	starredMessages, err := s.db.ListStarredMessages(ctx, listStarredMessagesParams)
+	if err != nil {
+		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get starred messages from db - ListStarredMessages", err)
+	}
+
+	messagesResponse := make([]*pb.StarredMessage, len(starredMessages))
+	for i, starredMessage := range starredMessages {
+		err = s.openMessage(ctx, &starredMessage.Message)
+		if err != nil {
+			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't decrypt message - ListStarredMessages", err)
+		}
+
+		messagesResponse[i] = &pb.StarredMessage{
+			Message:         messageToProto(starredMessage.Message),
+			PartnerId:       conversationPartner(starredMessage.Message, userID).String(),
+			PartnerUsername: starredMessage.PartnerUsername,
+			StarredAt:       timestamppb.New(starredMessage.StarredAt),
+		}
+	}
+
+	return &pb.ListStarredMessagesResponse{
+		Messages: messagesResponse,
+	}, nil
+}
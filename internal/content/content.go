@@ -0,0 +1,52 @@
+package content
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrBlank is returned for content that is empty or only whitespace.
+var ErrBlank = errors.New("content can't be empty or only whitespace")
+
+// Limits bounds the size of message content. A zero limit isn't enforced.
+type Limits struct {
+	// MaxRunes is the maximum number of Unicode code points.
+	MaxRunes int
+	// MaxBytes is the maximum size of the UTF-8 encoding.
+	MaxBytes int
+}
+
+// Normalize drops invalid UTF-8 and control characters other than line feeds and tabs, and
+// returns the rest in Unicode normalization form C, so equal text is stored with the same bytes.
+func Normalize(content string) string {
+	content = strings.ToValidUTF8(content, "")
+	content = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, content)
+
+	return norm.NFC.String(content)
+}
+
+// Check returns ErrBlank for blank content unless allowBlank is set, and an error describing the
+// exceeded limit for content that is too long. It expects normalized content.
+func (l Limits) Check(content string, allowBlank bool) error {
+	if !allowBlank && strings.TrimSpace(content) == "" {
+		return ErrBlank
+	}
+	if l.MaxRunes > 0 && utf8.RuneCountInString(content) > l.MaxRunes {
+		return fmt.Errorf("content can't be longer than %d characters", l.MaxRunes)
+	}
+	if l.MaxBytes > 0 && len(content) > l.MaxBytes {
+		return fmt.Errorf("content can't be longer than %d bytes", l.MaxBytes)
+	}
+
+	return nil
+}
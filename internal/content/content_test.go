@@ -0,0 +1,62 @@
+package content
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "plain text", content: "hello", want: "hello"},
+		{name: "keeps line feeds and tabs", content: "a\n\tb", want: "a\n\tb"},
+		{name: "drops control characters", content: "a\x00b\rc\x1bd\u0085", want: "abcd"},
+		{name: "drops invalid utf-8", content: "a\xffb\xc3", want: "ab"},
+		{name: "composes to nfc", content: "e\u0301", want: "\u00e9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.content); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{MaxRunes: 3, MaxBytes: 6}
+
+	tests := []struct {
+		name       string
+		limits     Limits
+		content    string
+		allowBlank bool
+		wantErr    bool
+		wantBlank  bool
+	}{
+		{name: "within limits", limits: limits, content: "abc"},
+		{name: "empty", limits: limits, content: "", wantErr: true, wantBlank: true},
+		{name: "only whitespace", limits: limits, content: " \n\t", wantErr: true, wantBlank: true},
+		{name: "blank allowed", limits: limits, content: "", allowBlank: true},
+		{name: "too many runes", limits: limits, content: "abcd", wantErr: true},
+		{name: "too many bytes", limits: limits, content: "€€€", wantErr: true},
+		{name: "multibyte within limits", limits: limits, content: "ééé"},
+		{name: "zero limits aren't enforced", limits: Limits{}, content: "a long message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Check(tt.content, tt.allowBlank)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check(%q) error = %v, want error %v", tt.content, err, tt.wantErr)
+			}
+			if errors.Is(err, ErrBlank) != tt.wantBlank {
+				t.Errorf("Check(%q) error = %v, want ErrBlank %v", tt.content, err, tt.wantBlank)
+			}
+		})
+	}
+}
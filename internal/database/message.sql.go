@@ -7,24 +7,57 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 
 	"github.com/google/uuid"
 )
 
+const cancelScheduledMessage = `-- name: CancelScheduledMessage :execrows
+DELETE FROM messages
+WHERE id = $1 AND sender_id = $2 AND send_at IS NOT NULL
+`
+
+type CancelScheduledMessageParams struct {
+	ID       uuid.UUID
+	SenderID uuid.UUID
+}
+
+func (q *Queries) CancelScheduledMessage(ctx context.Context, arg CancelScheduledMessageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelScheduledMessage, arg.ID, arg.SenderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const changeMessage = `-- name: ChangeMessage :one
 UPDATE messages
-SET content = $2
-WHERE id = $1
-RETURNING id, sent_at, sender_id, receiver_id, content
+SET content = $2, content_ciphertext = $3, content_data_key = $4, content_key_id = $5, payload = $6, payload_ciphertext = $7
+WHERE id = $1 AND is_encrypted = FALSE
+RETURNING id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at
 `
 
 type ChangeMessageParams struct {
-	ID      uuid.UUID
-	Content string
+	ID                uuid.UUID
+	Content           string
+	ContentCiphertext []byte
+	ContentDataKey    []byte
+	ContentKeyID      sql.NullString
+	Payload           json.RawMessage
+	PayloadCiphertext []byte
 }
 
 func (q *Queries) ChangeMessage(ctx context.Context, arg ChangeMessageParams) (Message, error) {
-	row := q.db.QueryRowContext(ctx, changeMessage, arg.ID, arg.Content)
+	row := q.db.QueryRowContext(ctx, changeMessage,
+		arg.ID,
+		arg.Content,
+		arg.ContentCiphertext,
+		arg.ContentDataKey,
+		arg.ContentKeyID,
+		arg.Payload,
+		arg.PayloadCiphertext,
+	)
 	var i Message
 	err := row.Scan(
 		&i.ID,
@@ -32,10 +65,116 @@ func (q *Queries) ChangeMessage(ctx context.Context, arg ChangeMessageParams) (M
 		&i.SenderID,
 		&i.ReceiverID,
 		&i.Content,
+		&i.ClientMessageID,
+		&i.IsEncrypted,
+		&i.EncryptedPayload,
+		&i.EncryptionHeaders,
+		&i.ContentCiphertext,
+		&i.ContentDataKey,
+		&i.ContentKeyID,
+		&i.SendAt,
+		&i.ReadAt,
+		&i.ExpiresAt,
+		&i.DisappearAfterSeconds,
+		&i.DisappearAfterRead,
+		&i.SystemEvent,
+		&i.ForwardedFromSenderID,
+		&i.ForwardedFromSentAt,
+		&i.Kind,
+		&i.Payload,
+		&i.PayloadCiphertext,
+		&i.NotifiedAt,
 	)
 	return i, err
 }
 
+const claimScheduledMessage = `-- name: ClaimScheduledMessage :one
+UPDATE messages
+SET sent_at = NOW(), send_at = NULL, notified_at = NOW(), expires_at = CASE
+   WHEN disappear_after_seconds IS NOT NULL AND NOT disappear_after_read THEN NOW() + disappear_after_seconds * INTERVAL '1 second'
+END
+WHERE id = (
+   SELECT id FROM messages
+   WHERE id = $1 AND send_at <= NOW()
+   FOR UPDATE SKIP LOCKED
+)
+RETURNING id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at
+`
+
+// The claimed row stays locked until the transaction ends, and other replicas skip it.
+func (q *Queries) ClaimScheduledMessage(ctx context.Context, id uuid.UUID) (Message, error) {
+	row := q.db.QueryRowContext(ctx, claimScheduledMessage, id)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.SentAt,
+		&i.SenderID,
+		&i.ReceiverID,
+		&i.Content,
+		&i.ClientMessageID,
+		&i.IsEncrypted,
+		&i.EncryptedPayload,
+		&i.EncryptionHeaders,
+		&i.ContentCiphertext,
+		&i.ContentDataKey,
+		&i.ContentKeyID,
+		&i.SendAt,
+		&i.ReadAt,
+		&i.ExpiresAt,
+		&i.DisappearAfterSeconds,
+		&i.DisappearAfterRead,
+		&i.SystemEvent,
+		&i.ForwardedFromSenderID,
+		&i.ForwardedFromSentAt,
+		&i.Kind,
+		&i.Payload,
+		&i.PayloadCiphertext,
+		&i.NotifiedAt,
+	)
+	return i, err
+}
+
+const deleteExpiredMessages = `-- name: DeleteExpiredMessages :many
+DELETE FROM messages
+WHERE id IN (
+   SELECT id FROM messages
+   WHERE expires_at <= NOW()
+   ORDER BY expires_at
+   LIMIT $1
+   FOR UPDATE SKIP LOCKED
+)
+RETURNING id, sender_id, receiver_id
+`
+
+type DeleteExpiredMessagesRow struct {
+	ID         uuid.UUID
+	SenderID   uuid.UUID
+	ReceiverID uuid.UUID
+}
+
+func (q *Queries) DeleteExpiredMessages(ctx context.Context, limit int32) ([]DeleteExpiredMessagesRow, error) {
+	rows, err := q.db.QueryContext(ctx, deleteExpiredMessages, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeleteExpiredMessagesRow
+	for rows.Next() {
+		var i DeleteExpiredMessagesRow
+		if err := rows.Scan(&i.ID, &i.SenderID, &i.ReceiverID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const deleteMessage = `-- name: DeleteMessage :exec
 DELETE FROM messages
 WHERE id = $1
@@ -46,9 +185,88 @@ func (q *Queries) DeleteMessage(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const getMessageByClientID = `-- name: GetMessageByClientID :one
+SELECT id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at FROM messages
+WHERE sender_id = $1 AND client_message_id = $2
+`
+
+type GetMessageByClientIDParams struct {
+	SenderID        uuid.UUID
+	ClientMessageID sql.NullString
+}
+
+func (q *Queries) GetMessageByClientID(ctx context.Context, arg GetMessageByClientIDParams) (Message, error) {
+	row := q.db.QueryRowContext(ctx, getMessageByClientID, arg.SenderID, arg.ClientMessageID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.SentAt,
+		&i.SenderID,
+		&i.ReceiverID,
+		&i.Content,
+		&i.ClientMessageID,
+		&i.IsEncrypted,
+		&i.EncryptedPayload,
+		&i.EncryptionHeaders,
+		&i.ContentCiphertext,
+		&i.ContentDataKey,
+		&i.ContentKeyID,
+		&i.SendAt,
+		&i.ReadAt,
+		&i.ExpiresAt,
+		&i.DisappearAfterSeconds,
+		&i.DisappearAfterRead,
+		&i.SystemEvent,
+		&i.ForwardedFromSenderID,
+		&i.ForwardedFromSentAt,
+		&i.Kind,
+		&i.Payload,
+		&i.PayloadCiphertext,
+		&i.NotifiedAt,
+	)
+	return i, err
+}
+
+const getMessageByID = `-- name: GetMessageByID :one
+SELECT id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at FROM messages
+WHERE id = $1
+`
+
+func (q *Queries) GetMessageByID(ctx context.Context, id uuid.UUID) (Message, error) {
+	row := q.db.QueryRowContext(ctx, getMessageByID, id)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.SentAt,
+		&i.SenderID,
+		&i.ReceiverID,
+		&i.Content,
+		&i.ClientMessageID,
+		&i.IsEncrypted,
+		&i.EncryptedPayload,
+		&i.EncryptionHeaders,
+		&i.ContentCiphertext,
+		&i.ContentDataKey,
+		&i.ContentKeyID,
+		&i.SendAt,
+		&i.ReadAt,
+		&i.ExpiresAt,
+		&i.DisappearAfterSeconds,
+		&i.DisappearAfterRead,
+		&i.SystemEvent,
+		&i.ForwardedFromSenderID,
+		&i.ForwardedFromSentAt,
+		&i.Kind,
+		&i.Payload,
+		&i.PayloadCiphertext,
+		&i.NotifiedAt,
+	)
+	return i, err
+}
+
 const getMessages = `-- name: GetMessages :many
-SELECT id, sent_at, sender_id, receiver_id, content FROM messages
-WHERE sender_id = $1 and receiver_id = $2
+SELECT id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at FROM messages
+WHERE sender_id = $1 and receiver_id = $2 AND send_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
 ORDER BY sent_at
 `
 
@@ -72,6 +290,25 @@ func (q *Queries) GetMessages(ctx context.Context, arg GetMessagesParams) ([]Mes
 			&i.SenderID,
 			&i.ReceiverID,
 			&i.Content,
+			&i.ClientMessageID,
+			&i.IsEncrypted,
+			&i.EncryptedPayload,
+			&i.EncryptionHeaders,
+			&i.ContentCiphertext,
+			&i.ContentDataKey,
+			&i.ContentKeyID,
+			&i.SendAt,
+			&i.ReadAt,
+			&i.ExpiresAt,
+			&i.DisappearAfterSeconds,
+			&i.DisappearAfterRead,
+			&i.SystemEvent,
+			&i.ForwardedFromSenderID,
+			&i.ForwardedFromSentAt,
+			&i.Kind,
+			&i.Payload,
+			&i.PayloadCiphertext,
+			&i.NotifiedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -86,23 +323,320 @@ func (q *Queries) GetMessages(ctx context.Context, arg GetMessagesParams) ([]Mes
 	return items, nil
 }
 
+const listDueScheduledMessages = `-- name: ListDueScheduledMessages :many
+SELECT id FROM messages
+WHERE send_at <= NOW()
+ORDER BY send_at
+LIMIT $1
+`
+
+func (q *Queries) ListDueScheduledMessages(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listDueScheduledMessages, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessagesToReencrypt = `-- name: ListMessagesToReencrypt :many
+SELECT id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at FROM messages
+WHERE is_encrypted = FALSE
+   AND (content_key_id IS DISTINCT FROM $1 OR (payload_ciphertext IS NULL AND payload <> '{}'))
+   AND id > $2
+ORDER BY id
+LIMIT $3
+`
+
+type ListMessagesToReencryptParams struct {
+	ContentKeyID sql.NullString
+	ID           uuid.UUID
+	Limit        int32
+}
+
+func (q *Queries) ListMessagesToReencrypt(ctx context.Context, arg ListMessagesToReencryptParams) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, listMessagesToReencrypt, arg.ContentKeyID, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.SentAt,
+			&i.SenderID,
+			&i.ReceiverID,
+			&i.Content,
+			&i.ClientMessageID,
+			&i.IsEncrypted,
+			&i.EncryptedPayload,
+			&i.EncryptionHeaders,
+			&i.ContentCiphertext,
+			&i.ContentDataKey,
+			&i.ContentKeyID,
+			&i.SendAt,
+			&i.ReadAt,
+			&i.ExpiresAt,
+			&i.DisappearAfterSeconds,
+			&i.DisappearAfterRead,
+			&i.SystemEvent,
+			&i.ForwardedFromSenderID,
+			&i.ForwardedFromSentAt,
+			&i.Kind,
+			&i.Payload,
+			&i.PayloadCiphertext,
+			&i.NotifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listScheduledMessages = `-- name: ListScheduledMessages :many
+SELECT id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at FROM messages
+WHERE sender_id = $1 AND send_at IS NOT NULL
+ORDER BY send_at
+`
+
+func (q *Queries) ListScheduledMessages(ctx context.Context, senderID uuid.UUID) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledMessages, senderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.SentAt,
+			&i.SenderID,
+			&i.ReceiverID,
+			&i.Content,
+			&i.ClientMessageID,
+			&i.IsEncrypted,
+			&i.EncryptedPayload,
+			&i.EncryptionHeaders,
+			&i.ContentCiphertext,
+			&i.ContentDataKey,
+			&i.ContentKeyID,
+			&i.SendAt,
+			&i.ReadAt,
+			&i.ExpiresAt,
+			&i.DisappearAfterSeconds,
+			&i.DisappearAfterRead,
+			&i.SystemEvent,
+			&i.ForwardedFromSenderID,
+			&i.ForwardedFromSentAt,
+			&i.Kind,
+			&i.Payload,
+			&i.PayloadCiphertext,
+			&i.NotifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markMessageNotified = `-- name: MarkMessageNotified :exec
+UPDATE messages
+SET notified_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkMessageNotified(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, markMessageNotified, id)
+	return err
+}
+
+const markMessagesRead = `-- name: MarkMessagesRead :execrows
+UPDATE messages
+SET read_at = NOW(), expires_at = CASE
+   WHEN disappear_after_read THEN NOW() + disappear_after_seconds * INTERVAL '1 second'
+   ELSE expires_at
+END
+WHERE sender_id = $1 AND receiver_id = $2 AND read_at IS NULL AND send_at IS NULL
+`
+
+type MarkMessagesReadParams struct {
+	SenderID   uuid.UUID
+	ReceiverID uuid.UUID
+}
+
+func (q *Queries) MarkMessagesRead(ctx context.Context, arg MarkMessagesReadParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markMessagesRead, arg.SenderID, arg.ReceiverID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const reencryptMessage = `-- name: ReencryptMessage :execrows
+UPDATE messages
+SET content = '', content_ciphertext = $1, content_data_key = $2, content_key_id = $3,
+   payload = $4, payload_ciphertext = $5
+WHERE id = $6
+   AND content = $7
+   AND content_ciphertext IS NOT DISTINCT FROM $8
+`
+
+type ReencryptMessageParams struct {
+	ContentCiphertext    []byte
+	ContentDataKey       []byte
+	ContentKeyID         sql.NullString
+	Payload              json.RawMessage
+	PayloadCiphertext    []byte
+	ID                   uuid.UUID
+	OldContent           string
+	OldContentCiphertext []byte
+}
+
+func (q *Queries) ReencryptMessage(ctx context.Context, arg ReencryptMessageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reencryptMessage,
+		arg.ContentCiphertext,
+		arg.ContentDataKey,
+		arg.ContentKeyID,
+		arg.Payload,
+		arg.PayloadCiphertext,
+		arg.ID,
+		arg.OldContent,
+		arg.OldContentCiphertext,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rescheduleMessage = `-- name: RescheduleMessage :one
+UPDATE messages
+SET send_at = $3
+WHERE id = $1 AND sender_id = $2 AND send_at IS NOT NULL
+RETURNING id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at
+`
+
+type RescheduleMessageParams struct {
+	ID       uuid.UUID
+	SenderID uuid.UUID
+	SendAt   sql.NullTime
+}
+
+func (q *Queries) RescheduleMessage(ctx context.Context, arg RescheduleMessageParams) (Message, error) {
+	row := q.db.QueryRowContext(ctx, rescheduleMessage, arg.ID, arg.SenderID, arg.SendAt)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.SentAt,
+		&i.SenderID,
+		&i.ReceiverID,
+		&i.Content,
+		&i.ClientMessageID,
+		&i.IsEncrypted,
+		&i.EncryptedPayload,
+		&i.EncryptionHeaders,
+		&i.ContentCiphertext,
+		&i.ContentDataKey,
+		&i.ContentKeyID,
+		&i.SendAt,
+		&i.ReadAt,
+		&i.ExpiresAt,
+		&i.DisappearAfterSeconds,
+		&i.DisappearAfterRead,
+		&i.SystemEvent,
+		&i.ForwardedFromSenderID,
+		&i.ForwardedFromSentAt,
+		&i.Kind,
+		&i.Payload,
+		&i.PayloadCiphertext,
+		&i.NotifiedAt,
+	)
+	return i, err
+}
+
 const sendMessage = `-- name: SendMessage :one
-INSERT INTO messages (id, sent_at, sender_id, receiver_id, content) 
+INSERT INTO messages (id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext) 
 VALUES (
    $1, 
    NOW(),
    $2,
    $3,
-   $4
+   $4,
+   $5,
+   $6,
+   $7,
+   $8,
+   $9,
+   $10,
+   $11,
+   $12,
+   $13,
+   $14,
+   $15,
+   $16,
+   $17,
+   $18,
+   $19,
+   $20,
+   $21
 )
-RETURNING id, sent_at, sender_id, receiver_id, content
+ON CONFLICT (sender_id, client_message_id) DO NOTHING
+RETURNING id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, read_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext, notified_at
 `
 
 type SendMessageParams struct {
-	ID         uuid.UUID
-	SenderID   uuid.UUID
-	ReceiverID uuid.UUID
-	Content    string
+	ID                    uuid.UUID
+	SenderID              uuid.UUID
+	ReceiverID            uuid.UUID
+	Content               string
+	ClientMessageID       sql.NullString
+	IsEncrypted           bool
+	EncryptedPayload      []byte
+	EncryptionHeaders     json.RawMessage
+	ContentCiphertext     []byte
+	ContentDataKey        []byte
+	ContentKeyID          sql.NullString
+	SendAt                sql.NullTime
+	ExpiresAt             sql.NullTime
+	DisappearAfterSeconds sql.NullInt32
+	DisappearAfterRead    bool
+	SystemEvent           sql.NullString
+	ForwardedFromSenderID uuid.NullUUID
+	ForwardedFromSentAt   sql.NullTime
+	Kind                  string
+	Payload               json.RawMessage
+	PayloadCiphertext     []byte
 }
 
 func (q *Queries) SendMessage(ctx context.Context, arg SendMessageParams) (Message, error) {
@@ -111,6 +645,23 @@ func (q *Queries) SendMessage(ctx context.Context, arg SendMessageParams) (Messa
 		arg.SenderID,
 		arg.ReceiverID,
 		arg.Content,
+		arg.ClientMessageID,
+		arg.IsEncrypted,
+		arg.EncryptedPayload,
+		arg.EncryptionHeaders,
+		arg.ContentCiphertext,
+		arg.ContentDataKey,
+		arg.ContentKeyID,
+		arg.SendAt,
+		arg.ExpiresAt,
+		arg.DisappearAfterSeconds,
+		arg.DisappearAfterRead,
+		arg.SystemEvent,
+		arg.ForwardedFromSenderID,
+		arg.ForwardedFromSentAt,
+		arg.Kind,
+		arg.Payload,
+		arg.PayloadCiphertext,
 	)
 	var i Message
 	err := row.Scan(
@@ -119,6 +670,25 @@ func (q *Queries) SendMessage(ctx context.Context, arg SendMessageParams) (Messa
 		&i.SenderID,
 		&i.ReceiverID,
 		&i.Content,
+		&i.ClientMessageID,
+		&i.IsEncrypted,
+		&i.EncryptedPayload,
+		&i.EncryptionHeaders,
+		&i.ContentCiphertext,
+		&i.ContentDataKey,
+		&i.ContentKeyID,
+		&i.SendAt,
+		&i.ReadAt,
+		&i.ExpiresAt,
+		&i.DisappearAfterSeconds,
+		&i.DisappearAfterRead,
+		&i.SystemEvent,
+		&i.ForwardedFromSenderID,
+		&i.ForwardedFromSentAt,
+		&i.Kind,
+		&i.Payload,
+		&i.PayloadCiphertext,
+		&i.NotifiedAt,
 	)
 	return i, err
 }
@@ -5,6 +5,8 @@
 package database
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,6 +20,15 @@ type Comment struct {
 	CommentText string
 }
 
+type ConversationSetting struct {
+	UserLow               uuid.UUID
+	UserHigh              uuid.UUID
+	DisappearAfterSeconds int32
+	DisappearMode         string
+	UpdatedBy             uuid.UUID
+	UpdatedAt             time.Time
+}
+
 type DeviceToken struct {
 	ID          uuid.UUID
 	UserID      uuid.UUID
@@ -27,12 +38,115 @@ type DeviceToken struct {
 	UpdatedAt   time.Time
 }
 
+type DeviceIdentityKey struct {
+	DeviceID              uuid.UUID
+	UserID                uuid.UUID
+	IdentityKey           []byte
+	SignedPrekeyID        int32
+	SignedPrekey          []byte
+	SignedPrekeySignature []byte
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+type DeviceOneTimePrekey struct {
+	DeviceID  uuid.UUID
+	KeyID     int32
+	PublicKey []byte
+	CreatedAt time.Time
+}
+
+type Draft struct {
+	UserID            uuid.UUID
+	PartnerID         uuid.UUID
+	Content           string
+	Version           int64
+	UpdatedAt         time.Time
+	ContentCiphertext []byte
+	ContentDataKey    []byte
+	ContentKeyID      sql.NullString
+}
+
+type LinkPreview struct {
+	Url         string
+	Status      string
+	Title       string
+	Description string
+	ImageUrl    string
+	Attempts    int32
+	CreatedAt   time.Time
+	FetchedAt   sql.NullTime
+}
+
 type Message struct {
-	ID         uuid.UUID
-	SentAt     time.Time
-	SenderID   uuid.UUID
-	ReceiverID uuid.UUID
-	Content    string
+	ID                    uuid.UUID
+	SentAt                time.Time
+	SenderID              uuid.UUID
+	ReceiverID            uuid.UUID
+	Content               string
+	ClientMessageID       sql.NullString
+	IsEncrypted           bool
+	EncryptedPayload      []byte
+	EncryptionHeaders     json.RawMessage
+	ContentCiphertext     []byte
+	ContentDataKey        []byte
+	ContentKeyID          sql.NullString
+	SendAt                sql.NullTime
+	ReadAt                sql.NullTime
+	ExpiresAt             sql.NullTime
+	DisappearAfterSeconds sql.NullInt32
+	DisappearAfterRead    bool
+	SystemEvent           sql.NullString
+	ForwardedFromSenderID uuid.NullUUID
+	ForwardedFromSentAt   sql.NullTime
+	Kind                  string
+	Payload               json.RawMessage
+	PayloadCiphertext     []byte
+	NotifiedAt            sql.NullTime
+}
+
+type MessageEntity struct {
+	MessageID   uuid.UUID
+	StartOffset int32
+	Length      int32
+	Kind        string
+	Value       string
+	UserID      uuid.NullUUID
+}
+
+type MessageRequest struct {
+	ID           uuid.UUID
+	SenderID     uuid.UUID
+	ReceiverID   uuid.UUID
+	Status       string
+	MessageCount int32
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type PinnedMessage struct {
+	MessageID uuid.UUID
+	UserLow   uuid.UUID
+	UserHigh  uuid.UUID
+	Slot      int32
+	PinnedBy  uuid.UUID
+	PinnedAt  time.Time
+}
+
+type Poll struct {
+	MessageID      uuid.UUID
+	OptionCount    int32
+	MultipleChoice bool
+	Anonymous      bool
+	ClosedAt       sql.NullTime
+	Version        int64
+}
+
+type PollBallot struct {
+	MessageID uuid.UUID
+	UserID    uuid.UUID
+	Options   []int32
+	VotedAt   time.Time
 }
 
 type Post struct {
@@ -54,10 +168,27 @@ type RefreshToken struct {
 }
 
 type Report struct {
-	ID         uuid.UUID
-	ReportedAt time.Time
-	ReportedBy uuid.UUID
-	Reason     string
+	ID                        uuid.UUID
+	ReportedAt                time.Time
+	ReportedBy                uuid.UUID
+	Reason                    string
+	MessageID                 uuid.NullUUID
+	ReportedUserID            uuid.NullUUID
+	Category                  string
+	ContentSnapshot           string
+	Status                    string
+	ResolvedBy                uuid.NullUUID
+	ResolvedAt                sql.NullTime
+	ResolutionNote            sql.NullString
+	ContentSnapshotCiphertext []byte
+	ContentSnapshotDataKey    []byte
+	ContentSnapshotKeyID      sql.NullString
+}
+
+type StarredMessage struct {
+	UserID    uuid.UUID
+	MessageID uuid.UUID
+	StarredAt time.Time
 }
 
 type User struct {
@@ -74,3 +205,17 @@ type User struct {
 	VerificationExpireTime time.Time
 	IsVerified             bool
 }
+
+type UserBlock struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	BlockedAt time.Time
+}
+
+type UserPrivacySetting struct {
+	UserID            uuid.UUID
+	MessagesFrom      string
+	UpdatedAt         time.Time
+	HideLastSeen      bool
+	HideForwardSender bool
+}
@@ -0,0 +1,164 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// dataKeySize is the size of the per row AES-256 data keys.
+const dataKeySize = 32
+
+// KeyProvider wraps and unwraps data keys with key-encryption keys. The local provider keeps
+// the key-encryption keys in memory; a KMS backed provider implements the same interface by
+// calling the KMS encrypt and decrypt APIs.
+type KeyProvider interface {
+	// CurrentKeyID returns the id of the key-encryption key used for new data.
+	CurrentKeyID() string
+	// WrapKey encrypts the data key with the key-encryption key with the given id.
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped with the key-encryption key with the given id.
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+// Envelope is an encrypted value together with its wrapped data key and the id of the
+// key-encryption key that wrapped it.
+type Envelope struct {
+	Ciphertext []byte
+	WrappedKey []byte
+	KeyID      string
+}
+
+// Encryptor applies envelope encryption: every value is encrypted with a fresh data key using
+// AES-GCM, and the data key is stored wrapped by the provider's current key-encryption key.
+type Encryptor struct {
+	provider KeyProvider
+}
+
+// NewEncryptor creates an encryptor that wraps data keys with the provider.
+func NewEncryptor(provider KeyProvider) *Encryptor {
+	return &Encryptor{provider: provider}
+}
+
+// CurrentKeyID returns the id of the key-encryption key used for new envelopes.
+func (e *Encryptor) CurrentKeyID() string {
+	return e.provider.CurrentKeyID()
+}
+
+// Encrypt seals the plaintext. The additional data, such as the row id, isn't stored but must
+// be passed to Decrypt, which binds the ciphertext to its row.
+func (e *Encryptor) Encrypt(ctx context.Context, plaintext, additionalData []byte) (Envelope, error) {
+	dataKey, err := e.NewDataKey(ctx)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	ciphertext, err := dataKey.Seal(plaintext, additionalData)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		Ciphertext: ciphertext,
+		WrappedKey: dataKey.WrappedKey,
+		KeyID:      dataKey.KeyID,
+	}, nil
+}
+
+// Decrypt opens an envelope created by Encrypt with the same additional data.
+func (e *Encryptor) Decrypt(ctx context.Context, envelope Envelope, additionalData []byte) ([]byte, error) {
+	dataKey, err := e.OpenDataKey(ctx, envelope.WrappedKey, envelope.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataKey.Open(envelope.Ciphertext, additionalData)
+}
+
+// DataKey is a data key with its wrapped form. It seals several values of one row, such as the
+// content and the payload of a message, which then share the wrapped key. Every value should
+// get its own additional data, so the values can't be swapped.
+type DataKey struct {
+	key        []byte
+	WrappedKey []byte
+	KeyID      string
+}
+
+// NewDataKey generates a data key wrapped by the current key-encryption key.
+func (e *Encryptor) NewDataKey(ctx context.Context) (DataKey, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return DataKey{}, err
+	}
+
+	keyID := e.provider.CurrentKeyID()
+	wrappedKey, err := e.provider.WrapKey(ctx, keyID, key)
+	if err != nil {
+		return DataKey{}, fmt.Errorf("can't wrap data key with %s: %w", keyID, err)
+	}
+
+	return DataKey{key: key, WrappedKey: wrappedKey, KeyID: keyID}, nil
+}
+
+// OpenDataKey unwraps a data key created by NewDataKey or Encrypt.
+func (e *Encryptor) OpenDataKey(ctx context.Context, wrappedKey []byte, keyID string) (DataKey, error) {
+	key, err := e.provider.UnwrapKey(ctx, keyID, wrappedKey)
+	if err != nil {
+		return DataKey{}, fmt.Errorf("can't unwrap data key with %s: %w", keyID, err)
+	}
+
+	return DataKey{key: key, WrappedKey: wrappedKey, KeyID: keyID}, nil
+}
+
+// Seal encrypts the plaintext with the data key.
+func (k DataKey) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	return seal(k.key, plaintext, additionalData)
+}
+
+// Open decrypts a value sealed with the data key and the same additional data.
+func (k DataKey) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	return open(k.key, ciphertext, additionalData)
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce to the ciphertext.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a value created by seal.
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
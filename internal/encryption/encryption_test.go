@@ -0,0 +1,173 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, dataKeySize)
+}
+
+func newTestEncryptor(t *testing.T, keys map[string][]byte, currentKeyID string) *Encryptor {
+	t.Helper()
+
+	provider, err := NewLocalKeyProvider(keys, currentKeyID)
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider() error = %v", err)
+	}
+	return NewEncryptor(provider)
+}
+
+func TestEncryptorRoundTrip(t *testing.T) {
+	encryptor := newTestEncryptor(t, map[string][]byte{"v1": testKey(1)}, "v1")
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{name: "text", plaintext: []byte("hello")},
+		{name: "empty", plaintext: []byte{}},
+		{name: "unicode", plaintext: []byte("привет 👋")},
+		{name: "large", plaintext: bytes.Repeat([]byte("a"), 1<<16)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := encryptor.Encrypt(ctx, tt.plaintext, []byte("row-1"))
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if envelope.KeyID != "v1" {
+				t.Errorf("Encrypt() key id = %q, want %q", envelope.KeyID, "v1")
+			}
+			if len(tt.plaintext) > 0 && bytes.Contains(envelope.Ciphertext, tt.plaintext) {
+				t.Error("Encrypt() ciphertext contains the plaintext")
+			}
+
+			plaintext, err := encryptor.Decrypt(ctx, envelope, []byte("row-1"))
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(plaintext, tt.plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", plaintext, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestEncryptorDecryptRejectsTampering(t *testing.T) {
+	encryptor := newTestEncryptor(t, map[string][]byte{"v1": testKey(1), "v2": testKey(2)}, "v1")
+	ctx := context.Background()
+
+	envelope, err := encryptor.Encrypt(ctx, []byte("hello"), []byte("row-1"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	flip := func(b []byte) []byte {
+		flipped := bytes.Clone(b)
+		flipped[len(flipped)-1] ^= 1
+		return flipped
+	}
+
+	tests := []struct {
+		name           string
+		envelope       Envelope
+		additionalData string
+	}{
+		{name: "other row", envelope: envelope, additionalData: "row-2"},
+		{name: "changed ciphertext", envelope: Envelope{flip(envelope.Ciphertext), envelope.WrappedKey, envelope.KeyID}, additionalData: "row-1"},
+		{name: "short ciphertext", envelope: Envelope{envelope.Ciphertext[:4], envelope.WrappedKey, envelope.KeyID}, additionalData: "row-1"},
+		{name: "changed wrapped key", envelope: Envelope{envelope.Ciphertext, flip(envelope.WrappedKey), envelope.KeyID}, additionalData: "row-1"},
+		{name: "other key id", envelope: Envelope{envelope.Ciphertext, envelope.WrappedKey, "v2"}, additionalData: "row-1"},
+		{name: "unknown key id", envelope: Envelope{envelope.Ciphertext, envelope.WrappedKey, "v3"}, additionalData: "row-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := encryptor.Decrypt(ctx, tt.envelope, []byte(tt.additionalData)); err == nil {
+				t.Error("Decrypt() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestEncryptorKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	oldEncryptor := newTestEncryptor(t, map[string][]byte{"v1": testKey(1)}, "v1")
+
+	envelope, err := oldEncryptor.Encrypt(ctx, []byte("hello"), []byte("row-1"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// After the rotation new data uses v2, while v1 is kept to read the rows that aren't
+	// re-encrypted yet.
+	rotated := newTestEncryptor(t, map[string][]byte{"v1": testKey(1), "v2": testKey(2)}, "v2")
+	if rotated.CurrentKeyID() != "v2" {
+		t.Fatalf("CurrentKeyID() = %q, want %q", rotated.CurrentKeyID(), "v2")
+	}
+
+	plaintext, err := rotated.Decrypt(ctx, envelope, []byte("row-1"))
+	if err != nil {
+		t.Fatalf("Decrypt() of an old envelope error = %v", err)
+	}
+
+	reencrypted, err := rotated.Encrypt(ctx, plaintext, []byte("row-1"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if reencrypted.KeyID != "v2" {
+		t.Errorf("Encrypt() key id = %q, want %q", reencrypted.KeyID, "v2")
+	}
+
+	// Once every row is re-encrypted v1 can be removed.
+	retired := newTestEncryptor(t, map[string][]byte{"v2": testKey(2)}, "v2")
+	plaintext, err = retired.Decrypt(ctx, reencrypted, []byte("row-1"))
+	if err != nil {
+		t.Fatalf("Decrypt() of a re-encrypted envelope error = %v", err)
+	}
+	if string(plaintext) != "hello" {
+		t.Errorf("Decrypt() = %q, want %q", plaintext, "hello")
+	}
+	if _, err := retired.Decrypt(ctx, envelope, []byte("row-1")); err == nil {
+		t.Error("Decrypt() of an envelope wrapped with a removed key error = nil, want an error")
+	}
+}
+
+func TestDataKeySealsSeveralValues(t *testing.T) {
+	encryptor := newTestEncryptor(t, map[string][]byte{"v1": testKey(1)}, "v1")
+	ctx := context.Background()
+
+	dataKey, err := encryptor.NewDataKey(ctx)
+	if err != nil {
+		t.Fatalf("NewDataKey() error = %v", err)
+	}
+
+	content, err := dataKey.Seal([]byte("hello"), []byte("row-1"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	payload, err := dataKey.Seal([]byte(`{"a":1}`), []byte("row-1payload"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	opened, err := encryptor.OpenDataKey(ctx, dataKey.WrappedKey, dataKey.KeyID)
+	if err != nil {
+		t.Fatalf("OpenDataKey() error = %v", err)
+	}
+
+	if plaintext, err := opened.Open(content, []byte("row-1")); err != nil || string(plaintext) != "hello" {
+		t.Errorf("Open(content) = %q, %v, want %q", plaintext, err, "hello")
+	}
+	if plaintext, err := opened.Open(payload, []byte("row-1payload")); err != nil || string(plaintext) != `{"a":1}` {
+		t.Errorf("Open(payload) = %q, %v, want %q", plaintext, err, `{"a":1}`)
+	}
+	if _, err := opened.Open(payload, []byte("row-1")); err == nil {
+		t.Error("Open() of the payload with the content additional data error = nil, want an error")
+	}
+}
@@ -0,0 +1,101 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LocalKeyProvider keeps the key-encryption keys in memory. It is meant for development and
+// for deployments that inject the keys through the environment or a mounted secret file.
+type LocalKeyProvider struct {
+	keys         map[string][]byte
+	currentKeyID string
+}
+
+// NewLocalKeyProvider creates a provider with the given AES-256 key-encryption keys. New data
+// keys are wrapped with the current key, while the other keys are kept to unwrap old rows
+// until they are re-encrypted.
+func NewLocalKeyProvider(keys map[string][]byte, currentKeyID string) (*LocalKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("current key %q is not one of the keys", currentKeyID)
+	}
+
+	for keyID, key := range keys {
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes long", keyID, dataKeySize)
+		}
+	}
+
+	return &LocalKeyProvider{keys: keys, currentKeyID: currentKeyID}, nil
+}
+
+// ParseKeys parses keys in the "id:base64key,id:base64key" format used by the environment.
+func ParseKeys(value string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		keyID, encodedKey, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q must have the id:base64key format", keyID)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't decode key %q: %w", keyID, err)
+		}
+		keys[keyID] = key
+	}
+
+	return keys, nil
+}
+
+// LoadKeysFile reads a JSON file in the {"current_key_id": "id", "keys": {"id": "base64key"}} format.
+func LoadKeysFile(path string) (map[string][]byte, string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the path comes from the service configuration
+	if err != nil {
+		return nil, "", err
+	}
+
+	var file struct {
+		CurrentKeyID string            `json:"current_key_id"`
+		Keys         map[string][]byte `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, "", err
+	}
+
+	return file.Keys, file.CurrentKeyID, nil
+}
+
+// CurrentKeyID returns the id of the key used to wrap new data keys.
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	return p.currentKeyID
+}
+
+// WrapKey encrypts the data key with the key-encryption key using AES-GCM.
+func (p *LocalKeyProvider) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	return seal(key, dataKey, []byte(keyID))
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+func (p *LocalKeyProvider) UnwrapKey(_ context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	return open(key, wrappedKey, []byte(keyID))
+}
@@ -0,0 +1,64 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testKey(1))
+
+	tests := []struct {
+		name     string
+		value    string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{name: "empty", value: ""},
+		{name: "one key", value: "v1:" + encoded, wantKeys: []string{"v1"}},
+		{name: "several keys", value: " v1:" + encoded + ", v2:" + encoded + ",", wantKeys: []string{"v1", "v2"}},
+		{name: "missing id", value: encoded, wantErr: true},
+		{name: "invalid base64", value: "v1:not base64", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if len(keys) != len(tt.wantKeys) {
+				t.Fatalf("ParseKeys(%q) = %d keys, want %d", tt.value, len(keys), len(tt.wantKeys))
+			}
+			for _, keyID := range tt.wantKeys {
+				if !bytes.Equal(keys[keyID], testKey(1)) {
+					t.Errorf("ParseKeys(%q)[%q] = %x, want %x", tt.value, keyID, keys[keyID], testKey(1))
+				}
+			}
+		})
+	}
+}
+
+func TestNewLocalKeyProvider(t *testing.T) {
+	tests := []struct {
+		name         string
+		keys         map[string][]byte
+		currentKeyID string
+		wantErr      bool
+	}{
+		{name: "valid", keys: map[string][]byte{"v1": testKey(1), "v2": testKey(2)}, currentKeyID: "v2"},
+		{name: "unknown current key", keys: map[string][]byte{"v1": testKey(1)}, currentKeyID: "v2", wantErr: true},
+		{name: "short key", keys: map[string][]byte{"v1": testKey(1), "v2": []byte("short")}, currentKeyID: "v1", wantErr: true},
+		{name: "no keys", keys: nil, currentKeyID: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLocalKeyProvider(tt.keys, tt.currentKeyID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewLocalKeyProvider() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package entities
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is the type of an entity found in message content.
+type Kind string
+
+const (
+	// Mention is an @username reference to another user.
+	Mention Kind = "mention"
+	// URL is a web link.
+	URL Kind = "url"
+	// Hashtag is a #topic tag.
+	Hashtag Kind = "hashtag"
+)
+
+var (
+	urlRegexp     = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+	mentionRegexp = regexp.MustCompile(`@([A-Za-z0-9_]{1,32})`)
+	hashtagRegexp = regexp.MustCompile(`#([\p{L}\p{N}_]{1,64})`)
+)
+
+// Entity is a structured part of message content. Offset and Length count Unicode code points.
+type Entity struct {
+	Kind   Kind
+	Offset int
+	Length int
+	// Value is the username without the @, the link with its scheme, or the tag without the #.
+	Value string
+}
+
+// Parse finds the links, mentions and hashtags of the content in the order they appear.
+// Mentions and hashtags inside links, and @ or # in the middle of a word, aren't entities.
+func Parse(content string) []Entity {
+	var found []span
+
+	for _, match := range urlRegexp.FindAllStringIndex(content, -1) {
+		start, end := match[0], trimLinkEnd(content, match[0], match[1])
+		link := content[start:end]
+		if !strings.Contains(strings.ToLower(link), "://") {
+			link = "https://" + link
+		}
+		if _, err := url.ParseRequestURI(link); err != nil {
+			continue
+		}
+		found = append(found, span{start: start, end: end, kind: URL, value: link})
+	}
+
+	found = appendTagged(found, content, mentionRegexp, Mention)
+	found = appendTagged(found, content, hashtagRegexp, Hashtag)
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].start < found[j].start
+	})
+
+	entities := make([]Entity, len(found))
+	for i, s := range found {
+		entities[i] = Entity{
+			Kind:   s.kind,
+			Offset: utf8.RuneCountInString(content[:s.start]),
+			Length: utf8.RuneCountInString(content[s.start:s.end]),
+			Value:  s.value,
+		}
+	}
+
+	return entities
+}
+
+// span is an entity located by byte positions.
+type span struct {
+	start, end int
+	kind       Kind
+	value      string
+}
+
+// appendTagged adds the @ or # prefixed matches that start a word and don't overlap a link.
+func appendTagged(found []span, content string, re *regexp.Regexp, kind Kind) []span {
+	for _, match := range re.FindAllStringSubmatchIndex(content, -1) {
+		start, end := match[0], match[1]
+		if !startsWord(content, start) || overlaps(found, start, end) {
+			continue
+		}
+		found = append(found, span{start: start, end: end, kind: kind, value: content[match[2]:match[3]]})
+	}
+
+	return found
+}
+
+// startsWord reports whether the byte position isn't preceded by a letter, digit or underscore,
+// which keeps email addresses from being parsed as mentions.
+func startsWord(content string, position int) bool {
+	if position == 0 {
+		return true
+	}
+
+	previous, _ := utf8.DecodeLastRuneInString(content[:position])
+	return !unicode.IsLetter(previous) && !unicode.IsDigit(previous) && previous != '_'
+}
+
+func overlaps(found []span, start, end int) bool {
+	for _, s := range found {
+		if start < s.end && s.start < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trimLinkEnd drops the punctuation that usually ends the sentence rather than the link, keeping
+// a closing parenthesis that has its opening one inside the link.
+func trimLinkEnd(content string, start, end int) int {
+	for end > start {
+		last := content[end-1]
+		switch {
+		case strings.IndexByte(".,!?;:'", last) >= 0:
+			end--
+		case last == ')' && strings.Count(content[start:end], "(") < strings.Count(content[start:end], ")"):
+			end--
+		default:
+			return end
+		}
+	}
+
+	return end
+}
@@ -0,0 +1,86 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Entity
+	}{
+		{
+			name:    "no entities",
+			content: "just text",
+			want:    []Entity{},
+		},
+		{
+			name:    "mention",
+			content: "hi @alice_1!",
+			want:    []Entity{{Kind: Mention, Offset: 3, Length: 8, Value: "alice_1"}},
+		},
+		{
+			name:    "hashtag",
+			content: "#go is fun",
+			want:    []Entity{{Kind: Hashtag, Offset: 0, Length: 3, Value: "go"}},
+		},
+		{
+			name:    "unicode hashtag and offsets in code points",
+			content: "привет #мир @bob",
+			want: []Entity{
+				{Kind: Hashtag, Offset: 7, Length: 4, Value: "мир"},
+				{Kind: Mention, Offset: 12, Length: 4, Value: "bob"},
+			},
+		},
+		{
+			name:    "link with scheme",
+			content: "see https://example.com/a?b=c.",
+			want:    []Entity{{Kind: URL, Offset: 4, Length: 25, Value: "https://example.com/a?b=c"}},
+		},
+		{
+			name:    "link without scheme",
+			content: "www.example.com",
+			want:    []Entity{{Kind: URL, Offset: 0, Length: 15, Value: "https://www.example.com"}},
+		},
+		{
+			name:    "link in parentheses",
+			content: "(https://en.wikipedia.org/wiki/Go_(language))",
+			want:    []Entity{{Kind: URL, Offset: 1, Length: 43, Value: "https://en.wikipedia.org/wiki/Go_(language)"}},
+		},
+		{
+			name:    "email address isn't a mention",
+			content: "mail me at alice@example.com",
+			want:    []Entity{},
+		},
+		{
+			name:    "hashtag in the middle of a word",
+			content: "c#sharp",
+			want:    []Entity{},
+		},
+		{
+			name:    "mention and hashtag inside a link",
+			content: "https://example.com/@alice#top",
+			want:    []Entity{{Kind: URL, Offset: 0, Length: 30, Value: "https://example.com/@alice#top"}},
+		},
+		{
+			name:    "entities in order",
+			content: "@bob #news http://example.com",
+			want: []Entity{
+				{Kind: Mention, Offset: 0, Length: 4, Value: "bob"},
+				{Kind: Hashtag, Offset: 5, Length: 5, Value: "news"},
+				{Kind: URL, Offset: 11, Length: 18, Value: "http://example.com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
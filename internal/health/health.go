@@ -0,0 +1,104 @@
+// Package health keeps the status of the grpc.health.v1 service up to date with the state of the
+// dependencies of the service.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// LivenessService is serving while the process runs, for probes that restart a stuck service.
+	LivenessService = "liveness"
+	// ReadinessService is serving while every dependency is up, for probes that decide whether
+	// the service gets traffic.
+	ReadinessService = "readiness"
+
+	// CheckInterval is how often the dependencies are checked.
+	CheckInterval = 10 * time.Second
+	// CheckTimeout is how long a single dependency check may take.
+	CheckTimeout = 3 * time.Second
+)
+
+// Dependency is a service the message service can't work without, such as Postgres. Check
+// returns an error while it is down.
+type Dependency struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Checker periodically checks the dependencies and sets the readiness of the health server.
+type Checker struct {
+	server       *health.Server
+	dependencies []Dependency
+	services     []string
+	down         map[string]bool
+}
+
+// NewChecker creates a checker for the health server. The liveness service is serving right
+// away, while the readiness service and the other services, such as the empty name of the whole
+// server, are not serving until the first check finds every dependency up.
+func NewChecker(server *health.Server, dependencies []Dependency, services ...string) *Checker {
+	services = append([]string{ReadinessService}, services...)
+
+	server.SetServingStatus(LivenessService, healthpb.HealthCheckResponse_SERVING)
+	for _, service := range services {
+		server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return &Checker{
+		server:       server,
+		dependencies: dependencies,
+		services:     services,
+		down:         make(map[string]bool, len(dependencies)),
+	}
+}
+
+// Run checks the dependencies every CheckInterval until the context is cancelled, then marks
+// every service as not serving.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		c.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			c.server.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check checks every dependency and sets the readiness to serving only when all of them are up.
+// Changes of a dependency's state are logged once.
+func (c *Checker) check(ctx context.Context) {
+	status := healthpb.HealthCheckResponse_SERVING
+	for _, dependency := range c.dependencies {
+		checkCtx, cancel := context.WithTimeout(ctx, CheckTimeout)
+		err := dependency.Check(checkCtx)
+		cancel()
+
+		switch {
+		case err != nil && !c.down[dependency.Name]:
+			slog.Error("dependency is down", "dependency", dependency.Name, "error", err)
+		case err == nil && c.down[dependency.Name]:
+			slog.Info("dependency is up", "dependency", dependency.Name)
+		}
+		c.down[dependency.Name] = err != nil
+
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
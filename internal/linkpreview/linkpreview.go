@@ -0,0 +1,228 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+// ErrUnsupportedContent is returned for pages that are neither HTML nor an image.
+var ErrUnsupportedContent = errors.New("unsupported content type")
+
+// errPrivateAddress is returned when a link resolves to an address that isn't public.
+var errPrivateAddress = errors.New("link resolves to a private address")
+
+// Preview is what a client shows for a link.
+type Preview struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Fetcher builds the preview of a link, usually by downloading the page.
+type Fetcher interface {
+	Fetch(ctx context.Context, link string) (Preview, error)
+}
+
+// Options configures an HTTPFetcher.
+type Options struct {
+	// Timeout limits the whole request, redirects included.
+	Timeout time.Duration
+	// MaxBodyBytes limits how much of the page is read.
+	MaxBodyBytes int64
+	// AllowPrivateNetworks lets links reach loopback and private addresses, such as a local stub
+	// server. It must stay off in production, where links come from users.
+	AllowPrivateNetworks bool
+}
+
+// HTTPFetcher downloads pages and reads their title, description and image from the Open Graph
+// meta tags, falling back to the title element and the description meta tag.
+type HTTPFetcher struct {
+	client       *http.Client
+	maxBodyBytes int64
+}
+
+// NewHTTPFetcher creates a fetcher. Unless private networks are allowed, connections to loopback,
+// private, link-local and other non-public addresses are refused after DNS resolution.
+func NewHTTPFetcher(options Options) *HTTPFetcher {
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = refusePrivateAddresses
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &HTTPFetcher{
+		client: &http.Client{
+			Timeout:   options.Timeout,
+			Transport: transport,
+		},
+		maxBodyBytes: options.MaxBodyBytes,
+	}
+}
+
+// Fetch downloads the link and builds its preview. Links to images get the image as the preview.
+func (f *HTTPFetcher) Fetch(ctx context.Context, link string) (Preview, error) {
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return Preview{}, err
+	}
+	if linkURL.Scheme != "http" && linkURL.Scheme != "https" {
+		return Preview{}, fmt.Errorf("unsupported scheme %q", linkURL.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("Accept", "text/html,image/*")
+	req.Header.Set("User-Agent", "message-service-link-preview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("page responded with status %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return Preview{ImageURL: resp.Request.URL.String()}, nil
+	case mediaType != "text/html" && mediaType != "application/xhtml+xml":
+		return Preview{}, ErrUnsupportedContent
+	}
+
+	preview := parseHead(io.LimitReader(resp.Body, f.maxBodyBytes))
+	preview.ImageURL = resolveReference(resp.Request.URL, preview.ImageURL)
+
+	return preview, nil
+}
+
+// parseHead reads the preview from the meta tags and the title of the page's head.
+func parseHead(body io.Reader) Preview {
+	var preview, fallback Preview
+	tokenizer := html.NewTokenizer(body)
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return mergePreviews(preview, fallback)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				return mergePreviews(preview, fallback)
+			case "title":
+				if tokenizer.Next() == html.TextToken {
+					fallback.Title = strings.TrimSpace(tokenizer.Token().Data)
+				}
+			case "meta":
+				readMeta(token, &preview, &fallback)
+			}
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "head" {
+				return mergePreviews(preview, fallback)
+			}
+		}
+	}
+}
+
+// readMeta stores the content of the Open Graph tags in the preview and of the plain
+// description tag in the fallback.
+func readMeta(token html.Token, preview, fallback *Preview) {
+	var key, content string
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "property", "name":
+			key = strings.ToLower(attr.Val)
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+
+	switch key {
+	case "og:title":
+		preview.Title = content
+	case "og:description":
+		preview.Description = content
+	case "og:image":
+		preview.ImageURL = content
+	case "description":
+		fallback.Description = content
+	}
+}
+
+func mergePreviews(preview, fallback Preview) Preview {
+	if preview.Title == "" {
+		preview.Title = fallback.Title
+	}
+	if preview.Description == "" {
+		preview.Description = fallback.Description
+	}
+
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	preview.Description = truncate(preview.Description, maxDescriptionLength)
+
+	return preview
+}
+
+// resolveReference makes a relative image link absolute and drops links that aren't http or https.
+func resolveReference(base *url.URL, reference string) string {
+	if reference == "" {
+		return ""
+	}
+
+	referenceURL, err := base.Parse(reference)
+	if err != nil || (referenceURL.Scheme != "http" && referenceURL.Scheme != "https") {
+		return ""
+	}
+
+	return referenceURL.String()
+}
+
+func truncate(value string, maxRunes int) string {
+	if utf8.RuneCountInString(value) <= maxRunes {
+		return value
+	}
+
+	return string([]rune(value)[:maxRunes])
+}
+
+// refusePrivateAddresses is a dialer control function that only lets connections to public
+// addresses through, so user supplied links can't reach internal services.
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+
+	return nil
+}
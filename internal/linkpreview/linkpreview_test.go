@@ -0,0 +1,188 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(allowPrivateNetworks bool) *HTTPFetcher {
+	return NewHTTPFetcher(Options{
+		Timeout:              5 * time.Second,
+		MaxBodyBytes:         1 << 20,
+		AllowPrivateNetworks: allowPrivateNetworks,
+	})
+}
+
+func TestHTTPFetcherFetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        func(serverURL string) Preview
+	}{
+		{
+			name:        "open graph",
+			contentType: "text/html; charset=utf-8",
+			body: `<html><head>
+				<title>Page title</title>
+				<meta property="og:title" content=" Open Graph title ">
+				<meta property="og:description" content="Open Graph description">
+				<meta name="description" content="Plain description">
+				<meta property="og:image" content="https://cdn.example.com/cover.png">
+			</head><body></body></html>`,
+			want: func(string) Preview {
+				return Preview{
+					Title:       "Open Graph title",
+					Description: "Open Graph description",
+					ImageURL:    "https://cdn.example.com/cover.png",
+				}
+			},
+		},
+		{
+			name:        "fallback tags",
+			contentType: "text/html",
+			body: `<html><head>
+				<title> Page title </title>
+				<meta name="Description" content="Plain description">
+			</head><body><meta property="og:title" content="Not in the head"></body></html>`,
+			want: func(string) Preview {
+				return Preview{Title: "Page title", Description: "Plain description"}
+			},
+		},
+		{
+			name:        "relative image",
+			contentType: "text/html",
+			body:        `<html><head><meta property="og:image" content="/images/cover.png"></head></html>`,
+			want: func(serverURL string) Preview {
+				return Preview{ImageURL: serverURL + "/images/cover.png"}
+			},
+		},
+		{
+			name:        "image link without http scheme",
+			contentType: "text/html",
+			body:        `<html><head><meta property="og:image" content="javascript:alert(1)"></head></html>`,
+			want: func(string) Preview {
+				return Preview{}
+			},
+		},
+		{
+			name:        "long title",
+			contentType: "text/html",
+			body:        `<html><head><title>` + strings.Repeat("é", maxTitleLength+10) + `</title></head></html>`,
+			want: func(string) Preview {
+				return Preview{Title: strings.Repeat("é", maxTitleLength)}
+			},
+		},
+		{
+			name:        "image content",
+			contentType: "image/png",
+			body:        "\x89PNG",
+			want: func(serverURL string) Preview {
+				return Preview{ImageURL: serverURL + "/page"}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			preview, err := newTestFetcher(true).Fetch(context.Background(), server.URL+"/page")
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if want := tt.want(server.URL); preview != want {
+				t.Errorf("Fetch() = %+v, want %+v", preview, want)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherFetchErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		link    string
+		wantErr error
+	}{
+		{name: "unsupported content", link: server.URL + "/document.pdf", wantErr: ErrUnsupportedContent},
+		{name: "not found", link: server.URL + "/missing"},
+		{name: "unsupported scheme", link: "ftp://example.com/file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestFetcher(true).Fetch(context.Background(), tt.link)
+			if err == nil {
+				t.Fatal("Fetch() error = nil, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Fetch() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherRefusesPrivateAddresses(t *testing.T) {
+	var requested atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(true)
+	}))
+	defer server.Close()
+
+	_, err := newTestFetcher(false).Fetch(context.Background(), server.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("Fetch() error = %v, want %v", err, errPrivateAddress)
+	}
+	if requested.Load() {
+		t.Error("Fetch() reached the loopback server")
+	}
+}
+
+func TestRefusePrivateAddresses(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{address: "93.184.216.34:443"},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443"},
+		{address: "127.0.0.1:80", wantErr: true},
+		{address: "[::1]:80", wantErr: true},
+		{address: "10.0.0.1:80", wantErr: true},
+		{address: "172.16.0.1:80", wantErr: true},
+		{address: "192.168.1.1:80", wantErr: true},
+		{address: "169.254.169.254:80", wantErr: true},
+		{address: "0.0.0.0:80", wantErr: true},
+		{address: "[fe80::1]:80", wantErr: true},
+		{address: "224.0.0.1:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := refusePrivateAddresses("tcp", tt.address, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("refusePrivateAddresses(%q) error = %v, want error %v", tt.address, err, tt.wantErr)
+			}
+		})
+	}
+}
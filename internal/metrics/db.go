@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// QueryHook records the duration of every query under the name sqlc gave it. It is an
+// sqlhook.Hook, so the duration of a query that returns rows includes reading them.
+func QueryHook(ctx context.Context, query string) (context.Context, func(err error)) {
+	name := QueryName(query)
+	start := time.Now()
+	return ctx, func(err error) {
+		ObserveDBQuery(name, time.Since(start), err)
+	}
+}
+
+// QueryName returns the name of a sqlc query from its "-- name: GetMessage :one" header, or
+// "unknown" for queries without one.
+func QueryName(query string) string {
+	header, _, _ := strings.Cut(query, "\n")
+	fields := strings.Fields(strings.TrimPrefix(header, "-- name:"))
+	if !strings.HasPrefix(header, "-- name:") || len(fields) == 0 {
+		return "unknown"
+	}
+	return fields[0]
+}
@@ -0,0 +1,127 @@
+// Package metrics collects the Prometheus metrics of the service: gRPC calls, cache lookups,
+// database queries, RabbitMQ publishes and open event streams.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "message_service"
+
+// Results of a cache lookup.
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Number of handled gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Duration of handled gRPC calls by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	activeStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "grpc_active_streams",
+		Help:      "Number of open gRPC streams by method.",
+	}, []string{"method"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_cache_lookups_total",
+		Help:      "Number of Redis cache lookups by key family and result: hit, miss or error.",
+	}, []string{"family", "result"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of Postgres queries by sqlc query name and status: ok or error.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query", "status"})
+
+	rabbitMQPublishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_publish_failures_total",
+		Help:      "Number of RabbitMQ publishes that failed or weren't confirmed by the broker, by routing key.",
+	}, []string{"routing_key"})
+
+	rabbitMQConfirmDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_confirm_duration_seconds",
+		Help:      "Time from publishing a RabbitMQ message until the broker confirmed it, by routing key.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"routing_key"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		grpcRequests,
+		grpcRequestDuration,
+		activeStreams,
+		cacheLookups,
+		dbQueryDuration,
+		rabbitMQPublishFailures,
+		rabbitMQConfirmDuration,
+	)
+}
+
+// Handler returns the HTTP handler that serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// ObserveGRPCRequest records a handled gRPC call.
+func ObserveGRPCRequest(method, code string, duration time.Duration) {
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcRequestDuration.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+// StreamOpened records a new open stream of the method, the returned function records that it
+// was closed.
+func StreamOpened(method string) func() {
+	gauge := activeStreams.WithLabelValues(method)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// ObserveCacheLookup records a Redis cache lookup of the key family.
+func ObserveCacheLookup(family, result string) {
+	cacheLookups.WithLabelValues(family, result).Inc()
+}
+
+// ObserveDBQuery records a Postgres query.
+func ObserveDBQuery(query string, duration time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	dbQueryDuration.WithLabelValues(query, status).Observe(duration.Seconds())
+}
+
+// RabbitMQPublishFailed records a RabbitMQ publish that failed or wasn't confirmed.
+func RabbitMQPublishFailed(routingKey string) {
+	rabbitMQPublishFailures.WithLabelValues(routingKey).Inc()
+}
+
+// ObserveRabbitMQConfirm records how long the broker took to confirm a RabbitMQ publish.
+func ObserveRabbitMQConfirm(routingKey string, duration time.Duration) {
+	rabbitMQConfirmDuration.WithLabelValues(routingKey).Observe(duration.Seconds())
+}
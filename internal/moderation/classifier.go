@@ -0,0 +1,97 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Classification is the answer of an external content classifier.
+type Classification struct {
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+}
+
+// Classifier scores message content, usually by calling an external service.
+type Classifier interface {
+	Classify(ctx context.Context, content string) (Classification, error)
+}
+
+// ClassifierFilter turns classifier scores into verdicts.
+type ClassifierFilter struct {
+	classifier  Classifier
+	flagScore   float64
+	rejectScore float64
+}
+
+// NewClassifierFilter creates a filter that flags messages scored at or above flagScore and
+// rejects messages scored at or above rejectScore. A zero threshold disables that verdict.
+func NewClassifierFilter(classifier Classifier, flagScore, rejectScore float64) *ClassifierFilter {
+	return &ClassifierFilter{
+		classifier:  classifier,
+		flagScore:   flagScore,
+		rejectScore: rejectScore,
+	}
+}
+
+// Name returns the name of the filter.
+func (f *ClassifierFilter) Name() string {
+	return "classifier"
+}
+
+// Check asks the classifier to score the message.
+func (f *ClassifierFilter) Check(ctx context.Context, input Input) (Result, error) {
+	classification, err := f.classifier.Classify(ctx, input.Content)
+	if err != nil {
+		return Result{}, err
+	}
+
+	switch {
+	case f.rejectScore > 0 && classification.Score >= f.rejectScore:
+		return Result{Verdict: Reject, Filter: f.Name(), Reason: ReasonClassifier}, nil
+	case f.flagScore > 0 && classification.Score >= f.flagScore:
+		return Result{Verdict: Flag, Filter: f.Name(), Reason: ReasonClassifier}, nil
+	default:
+		return Result{Verdict: Allow}, nil
+	}
+}
+
+// HTTPClassifier posts the content as JSON to a classification endpoint and expects a
+// Classification in the response body.
+type HTTPClassifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Classify sends the content to the classification endpoint.
+func (c *HTTPClassifier) Classify(ctx context.Context, content string) (Classification, error) {
+	body, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return Classification{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return Classification{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return Classification{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Classification{}, fmt.Errorf("classifier responded with status %d", resp.StatusCode)
+	}
+
+	var classification Classification
+	if err := json.NewDecoder(resp.Body).Decode(&classification); err != nil {
+		return Classification{}, err
+	}
+
+	return classification, nil
+}
@@ -0,0 +1,141 @@
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Config describes which filters run and how they behave. It is loaded from a JSON file so
+// word lists and thresholds can be changed without a code change.
+type Config struct {
+	Profanity  ProfanityConfig  `json:"profanity"`
+	Links      LinksConfig      `json:"links"`
+	Spam       SpamConfig       `json:"spam"`
+	Classifier ClassifierConfig `json:"classifier"`
+}
+
+// ProfanityConfig configures the word list filter.
+type ProfanityConfig struct {
+	Enabled bool     `json:"enabled"`
+	Action  string   `json:"action"`
+	Words   []string `json:"words"`
+}
+
+// LinksConfig configures the link filter.
+type LinksConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Action         string   `json:"action"`
+	AllowedDomains []string `json:"allowed_domains"`
+}
+
+// SpamConfig configures the spam heuristics.
+type SpamConfig struct {
+	Enabled             bool   `json:"enabled"`
+	Action              string `json:"action"`
+	MaxMentions         int    `json:"max_mentions"`
+	MaxRepeats          int64  `json:"max_repeats"`
+	RepeatWindowSeconds int    `json:"repeat_window_seconds"`
+}
+
+// ClassifierConfig configures the optional external classifier.
+type ClassifierConfig struct {
+	Enabled        bool    `json:"enabled"`
+	URL            string  `json:"url"`
+	FlagScore      float64 `json:"flag_score"`
+	RejectScore    float64 `json:"reject_score"`
+	TimeoutSeconds int     `json:"timeout_seconds"`
+}
+
+// DefaultConfig is used when no config file is given. The link filter is disabled, because
+// without an allow list it would flag every message with a link.
+func DefaultConfig() Config {
+	return Config{
+		Profanity: ProfanityConfig{Enabled: true, Action: "reject"},
+		Links:     LinksConfig{Enabled: false, Action: "flag"},
+		Spam: SpamConfig{
+			Enabled:             true,
+			Action:              "reject",
+			MaxMentions:         10,
+			MaxRepeats:          5,
+			RepeatWindowSeconds: 60,
+		},
+	}
+}
+
+// LoadConfig reads the config from a JSON file. An empty path returns DefaultConfig.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 -- the path comes from the service configuration
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// RepeatWindow returns the window in which repeated content is counted.
+func (c SpamConfig) RepeatWindow() time.Duration {
+	return time.Duration(c.RepeatWindowSeconds) * time.Second
+}
+
+// NewPipelineFromConfig builds a pipeline with the filters enabled in the config.
+// The counter is used by the spam filter to detect repeated content.
+func NewPipelineFromConfig(config Config, counter RepeatCounter) (*Pipeline, error) {
+	var filters []Filter
+
+	if config.Profanity.Enabled {
+		action, err := parseAction(config.Profanity.Action)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, NewProfanityFilter(config.Profanity.Words, action))
+	}
+
+	if config.Links.Enabled {
+		action, err := parseAction(config.Links.Action)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, NewLinkFilter(config.Links.AllowedDomains, action))
+	}
+
+	if config.Spam.Enabled {
+		action, err := parseAction(config.Spam.Action)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, NewSpamFilter(config.Spam.MaxMentions, config.Spam.MaxRepeats, counter, action))
+	}
+
+	if config.Classifier.Enabled {
+		classifier := &HTTPClassifier{
+			URL:    config.Classifier.URL,
+			Client: &http.Client{Timeout: time.Duration(config.Classifier.TimeoutSeconds) * time.Second},
+		}
+		filters = append(filters, NewClassifierFilter(classifier, config.Classifier.FlagScore, config.Classifier.RejectScore))
+	}
+
+	return NewPipeline(filters...), nil
+}
+
+func parseAction(action string) (Verdict, error) {
+	switch action {
+	case "flag":
+		return Flag, nil
+	case "reject", "":
+		return Reject, nil
+	default:
+		return Allow, fmt.Errorf("unknown moderation action %q", action)
+	}
+}
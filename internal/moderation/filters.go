@@ -0,0 +1,166 @@
+package moderation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	linkRegexp    = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+	mentionRegexp = regexp.MustCompile(`(?:^|\s)@\w+`)
+)
+
+// ProfanityFilter matches the words of a message against a word list.
+type ProfanityFilter struct {
+	words  map[string]struct{}
+	action Verdict
+}
+
+// NewProfanityFilter creates a filter that applies the action to messages containing any of the words.
+func NewProfanityFilter(words []string, action Verdict) *ProfanityFilter {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		set[strings.ToLower(strings.TrimSpace(word))] = struct{}{}
+	}
+
+	return &ProfanityFilter{words: set, action: action}
+}
+
+// Name returns the name of the filter.
+func (f *ProfanityFilter) Name() string {
+	return "profanity"
+}
+
+// Check looks for listed words in the message.
+func (f *ProfanityFilter) Check(_ context.Context, input Input) (Result, error) {
+	words := strings.FieldsFunc(strings.ToLower(input.Content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for _, word := range words {
+		if _, ok := f.words[word]; ok {
+			return Result{Verdict: f.action, Filter: f.Name(), Reason: ReasonProfanity}, nil
+		}
+	}
+
+	return Result{Verdict: Allow}, nil
+}
+
+// LinkFilter blocks links to domains outside of an allow list.
+type LinkFilter struct {
+	allowedDomains []string
+	action         Verdict
+}
+
+// NewLinkFilter creates a filter that applies the action to links whose host is not one of the
+// allowed domains or their subdomains.
+func NewLinkFilter(allowedDomains []string, action Verdict) *LinkFilter {
+	domains := make([]string, len(allowedDomains))
+	for i, domain := range allowedDomains {
+		domains[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+
+	return &LinkFilter{allowedDomains: domains, action: action}
+}
+
+// Name returns the name of the filter.
+func (f *LinkFilter) Name() string {
+	return "links"
+}
+
+// Check looks for links to domains that are not allowed.
+func (f *LinkFilter) Check(_ context.Context, input Input) (Result, error) {
+	for _, link := range linkRegexp.FindAllString(input.Content, -1) {
+		if !f.isAllowed(link) {
+			return Result{Verdict: f.action, Filter: f.Name(), Reason: ReasonBlockedLink}, nil
+		}
+	}
+
+	return Result{Verdict: Allow}, nil
+}
+
+func (f *LinkFilter) isAllowed(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range f.allowedDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RepeatCounter counts how many times a sender has recently sent the same content.
+type RepeatCounter interface {
+	CountRepeat(ctx context.Context, senderID, contentHash string) (int64, error)
+}
+
+// RepeatCounterFunc adapts a function to the RepeatCounter interface.
+type RepeatCounterFunc func(ctx context.Context, senderID, contentHash string) (int64, error)
+
+// CountRepeat calls f(ctx, senderID, contentHash).
+func (f RepeatCounterFunc) CountRepeat(ctx context.Context, senderID, contentHash string) (int64, error) {
+	return f(ctx, senderID, contentHash)
+}
+
+// SpamFilter applies heuristics for repeated content and mass mentions.
+type SpamFilter struct {
+	maxMentions int
+	maxRepeats  int64
+	counter     RepeatCounter
+	action      Verdict
+}
+
+// NewSpamFilter creates a spam filter. A zero limit disables the matching heuristic, and a nil
+// counter disables the repeated content check.
+func NewSpamFilter(maxMentions int, maxRepeats int64, counter RepeatCounter, action Verdict) *SpamFilter {
+	return &SpamFilter{
+		maxMentions: maxMentions,
+		maxRepeats:  maxRepeats,
+		counter:     counter,
+		action:      action,
+	}
+}
+
+// Name returns the name of the filter.
+func (f *SpamFilter) Name() string {
+	return "spam"
+}
+
+// Check counts mentions and repeats of the same content by the sender.
+func (f *SpamFilter) Check(ctx context.Context, input Input) (Result, error) {
+	if f.maxMentions > 0 && len(mentionRegexp.FindAllString(input.Content, -1)) > f.maxMentions {
+		return Result{Verdict: f.action, Filter: f.Name(), Reason: ReasonTooManyMentions}, nil
+	}
+
+	if f.maxRepeats <= 0 || f.counter == nil {
+		return Result{Verdict: Allow}, nil
+	}
+
+	normalized := strings.Join(strings.Fields(strings.ToLower(input.Content)), " ")
+	hash := sha256.Sum256([]byte(normalized))
+
+	count, err := f.counter.CountRepeat(ctx, input.SenderID.String(), hex.EncodeToString(hash[:]))
+	if err != nil {
+		return Result{}, err
+	}
+	if count > f.maxRepeats {
+		return Result{Verdict: f.action, Filter: f.Name(), Reason: ReasonRepeatedContent}, nil
+	}
+
+	return Result{Verdict: Allow}, nil
+}
@@ -0,0 +1,103 @@
+package moderation
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+// Verdict is the decision a filter makes about a message.
+type Verdict int
+
+const (
+	// Allow lets the message through unchanged.
+	Allow Verdict = iota
+	// Flag stores the message but creates an automatic report for moderators.
+	Flag
+	// Reject refuses to store the message.
+	Reject
+)
+
+// String returns the lowercase name of the verdict as it is used in the config file.
+func (v Verdict) String() string {
+	switch v {
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// Reason codes returned by the built-in filters.
+const (
+	ReasonProfanity       = "PROFANITY"
+	ReasonBlockedLink     = "BLOCKED_LINK"
+	ReasonRepeatedContent = "REPEATED_CONTENT"
+	ReasonTooManyMentions = "TOO_MANY_MENTIONS"
+	ReasonClassifier      = "CLASSIFIER"
+)
+
+// SystemReporterID is stored as the reporter of reports created by the pipeline itself.
+var SystemReporterID = uuid.Nil
+
+// Input is the message being checked.
+type Input struct {
+	SenderID   uuid.UUID
+	ReceiverID uuid.UUID
+	Content    string
+}
+
+// Result is the outcome of a filter or of the whole pipeline.
+type Result struct {
+	Verdict Verdict
+	Filter  string
+	Reason  string
+}
+
+// Filter checks a message and returns a verdict about it.
+type Filter interface {
+	Name() string
+	Check(ctx context.Context, input Input) (Result, error)
+}
+
+// Pipeline runs a chain of filters against a message.
+type Pipeline struct {
+	filters []Filter
+}
+
+// NewPipeline creates a pipeline that runs the given filters in order.
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Run checks the message with every filter. The first Reject stops the chain, otherwise the
+// first Flag is returned. A filter that fails is logged and skipped, so an unavailable
+// dependency such as an external classifier doesn't block messaging.
+func (p *Pipeline) Run(ctx context.Context, input Input) Result {
+	result := Result{Verdict: Allow}
+	if p == nil {
+		return result
+	}
+
+	for _, filter := range p.filters {
+		filterResult, err := filter.Check(ctx, input)
+		if err != nil {
+			slog.WarnContext(ctx, "moderation filter failed", "filter", filter.Name(), "error", err)
+			continue
+		}
+
+		switch filterResult.Verdict {
+		case Reject:
+			return filterResult
+		case Flag:
+			if result.Verdict == Allow {
+				result = filterResult
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,248 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// stubFilter returns a fixed result or error.
+type stubFilter struct {
+	name   string
+	result Result
+	err    error
+}
+
+func (f stubFilter) Name() string {
+	return f.name
+}
+
+func (f stubFilter) Check(context.Context, Input) (Result, error) {
+	return f.result, f.err
+}
+
+func TestPipelineRun(t *testing.T) {
+	allow := stubFilter{name: "allow", result: Result{Verdict: Allow}}
+	flag := stubFilter{name: "flag", result: Result{Verdict: Flag, Filter: "flag", Reason: "FIRST"}}
+	otherFlag := stubFilter{name: "other", result: Result{Verdict: Flag, Filter: "other", Reason: "SECOND"}}
+	reject := stubFilter{name: "reject", result: Result{Verdict: Reject, Filter: "reject", Reason: ReasonProfanity}}
+	failing := stubFilter{name: "failing", err: errors.New("unavailable")}
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    Result
+	}{
+		{name: "no filters", want: Result{Verdict: Allow}},
+		{name: "all allow", filters: []Filter{allow, allow}, want: Result{Verdict: Allow}},
+		{name: "first flag wins", filters: []Filter{allow, flag, otherFlag}, want: flag.result},
+		{name: "reject after flag", filters: []Filter{flag, reject}, want: reject.result},
+		{name: "reject stops the chain", filters: []Filter{reject, flag}, want: reject.result},
+		{name: "failing filter is skipped", filters: []Filter{failing, flag}, want: flag.result},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewPipeline(tt.filters...).Run(context.Background(), Input{}); got != tt.want {
+				t.Errorf("Run() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilPipelineAllows(t *testing.T) {
+	var pipeline *Pipeline
+	if got := pipeline.Run(context.Background(), Input{Content: "anything"}); got.Verdict != Allow {
+		t.Errorf("Run() = %+v, want Allow", got)
+	}
+}
+
+func TestProfanityFilter(t *testing.T) {
+	filter := NewProfanityFilter([]string{" Darn ", "heck"}, Reject)
+
+	tests := []struct {
+		content string
+		want    Verdict
+	}{
+		{content: "hello there", want: Allow},
+		{content: "oh DARN it", want: Reject},
+		{content: "what the heck!", want: Reject},
+		{content: "heckler", want: Allow},
+		{content: "darn_it", want: Reject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			got, err := filter.Check(context.Background(), Input{Content: tt.content})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got.Verdict != tt.want {
+				t.Errorf("Check(%q) verdict = %v, want %v", tt.content, got.Verdict, tt.want)
+			}
+			if got.Verdict != Allow && got.Reason != ReasonProfanity {
+				t.Errorf("Check(%q) reason = %q, want %q", tt.content, got.Reason, ReasonProfanity)
+			}
+		})
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	filter := NewLinkFilter([]string{"Example.com"}, Flag)
+
+	tests := []struct {
+		content string
+		want    Verdict
+	}{
+		{content: "no links here", want: Allow},
+		{content: "see https://example.com/page", want: Allow},
+		{content: "see http://docs.example.com", want: Allow},
+		{content: "see www.example.com", want: Allow},
+		{content: "see https://evil.com", want: Flag},
+		{content: "see https://notexample.com", want: Flag},
+		{content: "see https://example.com.evil.com", want: Flag},
+		{content: "https://example.com and www.spam.net", want: Flag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			got, err := filter.Check(context.Background(), Input{Content: tt.content})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got.Verdict != tt.want {
+				t.Errorf("Check(%q) verdict = %v, want %v", tt.content, got.Verdict, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	counter := func(count int64, err error) RepeatCounter {
+		return RepeatCounterFunc(func(context.Context, string, string) (int64, error) {
+			return count, err
+		})
+	}
+
+	tests := []struct {
+		name    string
+		filter  *SpamFilter
+		content string
+		want    Result
+		wantErr bool
+	}{
+		{
+			name:    "few mentions",
+			filter:  NewSpamFilter(2, 0, nil, Reject),
+			content: "@alice @bob hi",
+			want:    Result{Verdict: Allow},
+		},
+		{
+			name:    "too many mentions",
+			filter:  NewSpamFilter(2, 0, nil, Reject),
+			content: "@alice @bob @carol hi",
+			want:    Result{Verdict: Reject, Filter: "spam", Reason: ReasonTooManyMentions},
+		},
+		{
+			name:    "mention limit disabled",
+			filter:  NewSpamFilter(0, 0, nil, Reject),
+			content: "@alice @bob @carol hi",
+			want:    Result{Verdict: Allow},
+		},
+		{
+			name:    "repeats within the limit",
+			filter:  NewSpamFilter(0, 3, counter(3, nil), Flag),
+			content: "buy now",
+			want:    Result{Verdict: Allow},
+		},
+		{
+			name:    "too many repeats",
+			filter:  NewSpamFilter(0, 3, counter(4, nil), Flag),
+			content: "buy now",
+			want:    Result{Verdict: Flag, Filter: "spam", Reason: ReasonRepeatedContent},
+		},
+		{
+			name:    "counter fails",
+			filter:  NewSpamFilter(0, 3, counter(0, errors.New("unavailable")), Flag),
+			content: "buy now",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Check(context.Background(), Input{Content: tt.content})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpamFilterNormalizesRepeatedContent(t *testing.T) {
+	var hashes []string
+	counter := RepeatCounterFunc(func(_ context.Context, _, contentHash string) (int64, error) {
+		hashes = append(hashes, contentHash)
+		return 1, nil
+	})
+	filter := NewSpamFilter(0, 5, counter, Reject)
+
+	for _, content := range []string{"Buy  now", "buy now", " BUY\nnow "} {
+		if _, err := filter.Check(context.Background(), Input{Content: content}); err != nil {
+			t.Fatalf("Check(%q) error = %v", content, err)
+		}
+	}
+
+	for _, hash := range hashes[1:] {
+		if hash != hashes[0] {
+			t.Fatalf("content hashes = %v, want them all equal", hashes)
+		}
+	}
+}
+
+type stubClassifier float64
+
+func (c stubClassifier) Classify(context.Context, string) (Classification, error) {
+	return Classification{Label: "toxic", Score: float64(c)}, nil
+}
+
+func TestClassifierFilter(t *testing.T) {
+	tests := []struct {
+		name        string
+		score       float64
+		flagScore   float64
+		rejectScore float64
+		want        Verdict
+	}{
+		{name: "below thresholds", score: 0.2, flagScore: 0.5, rejectScore: 0.9, want: Allow},
+		{name: "at flag score", score: 0.5, flagScore: 0.5, rejectScore: 0.9, want: Flag},
+		{name: "at reject score", score: 0.9, flagScore: 0.5, rejectScore: 0.9, want: Reject},
+		{name: "reject disabled", score: 0.95, flagScore: 0.5, want: Flag},
+		{name: "both disabled", score: 1, want: Allow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewClassifierFilter(stubClassifier(tt.score), tt.flagScore, tt.rejectScore)
+			got, err := filter.Check(context.Background(), Input{Content: "text"})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got.Verdict != tt.want {
+				t.Errorf("Check() verdict = %v, want %v", got.Verdict, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPipelineFromConfigUnknownAction(t *testing.T) {
+	config := DefaultConfig()
+	config.Profanity.Action = "delete"
+
+	if _, err := NewPipelineFromConfig(config, nil); err == nil {
+		t.Error("NewPipelineFromConfig() error = nil, want an error for an unknown action")
+	}
+}
@@ -1,9 +1,21 @@
 package rabbitmq
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/imhasandl/message-service/internal/metrics"
+	"github.com/imhasandl/message-service/internal/requestid"
+	"github.com/imhasandl/message-service/internal/tracing"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -13,46 +25,311 @@ const (
 	QueueName = "notification_service_queue"
 	// RoutingKey is the routing key used to bind the queue to the exchange for notification messages.
 	RoutingKey = "message-service.notification"
+	// MessageRequestRoutingKey is the routing key used for notifications about messages from users
+	// the receiver doesn't follow, which wait in the receiver's requests inbox.
+	MessageRequestRoutingKey = "message-service.message-request"
+	// MentionRoutingKey is the routing key used for notifications about messages that mention the
+	// receiver, which are delivered with a high priority.
+	MentionRoutingKey = "message-service.mention"
+	// ModerationRoutingKey is the routing key used for moderation events such as reported messages.
+	ModerationRoutingKey = "message-service.moderation"
 )
 
-// RabbitMQ encapsulates the RabbitMQ connection and channel.
+var (
+	// ErrNotConfirmed is returned when the broker rejected a published message.
+	ErrNotConfirmed = errors.New("rabbit mq didn't confirm the message")
+	// ErrChannelClosed is returned when the channel closed before the broker confirmed a message.
+	ErrChannelClosed = errors.New("rabbit mq channel is closed")
+	// ErrConnectionClosed is returned when the connection to the broker is closed.
+	ErrConnectionClosed = errors.New("rabbit mq connection is closed")
+)
+
+const (
+	// reconnectMinDelay is how long to wait before the first attempt to reconnect to the broker.
+	reconnectMinDelay = time.Second
+	// reconnectMaxDelay caps the wait between attempts, which doubles after every failed one.
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// RabbitMQ encapsulates the RabbitMQ connection and channel. When the connection or the channel
+// closes, it reconnects in the background and publishes fail until it succeeds.
 type RabbitMQ struct {
-	Conn    *amqp.Connection
-	Channel *amqp.Channel
+	url string
+
+	// mu guards the connection and the channel, which are replaced on every reconnect, and the
+	// state of the channel.
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *confirmChannel
+
+	done chan struct{}
+}
+
+// confirmChannel is a channel in confirm mode. Publishes are numbered in the order the broker
+// numbers them and wait for their confirmation, which handleConfirms hands over by delivery
+// tag, so concurrent publishes don't wait for each other's round trips.
+type confirmChannel struct {
+	channel     *amqp.Channel
+	deliveryTag uint64
+	pending     map[uint64]*pendingConfirm
+	closed      bool
+}
+
+// pendingConfirm is a published message waiting for the broker's confirmation.
+type pendingConfirm struct {
+	routingKey string
+	start      time.Time
+	result     chan error
 }
 
 // NewRabbitMQ creates a new RabbitMQ instance and establishes a connection and channel.
 // It takes the RabbitMQ server URL as input and returns a pointer to the RabbitMQ struct or an error if connection fails.
 func NewRabbitMQ(url string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(url)
+	r := &RabbitMQ{
+		url:  url,
+		done: make(chan struct{}),
+	}
+
+	connClosed, err := r.connect()
+	if err != nil {
+		slog.Error("can't connect to rabbit mq", "error", err)
+		return nil, err
+	}
+	go r.reconnect(connClosed)
+
+	return r, nil
+}
+
+// connect dials the broker and opens a channel in confirm mode, which replace the current ones.
+// It returns the channel that is closed when the new connection closes.
+func (r *RabbitMQ) connect() (chan *amqp.Error, error) {
+	conn, err := amqp.Dial(r.url)
 	if err != nil {
-		log.Printf("can't connect to rabbit mq: %v", err)
 		return nil, err
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
-		log.Printf("can't connect to the channel: %v", err)
+		conn.Close()
+		return nil, fmt.Errorf("can't open channel: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("can't put channel in confirm mode: %w", err)
+	}
+
+	channel := &confirmChannel{
+		channel: ch,
+		pending: make(map[uint64]*pendingConfirm),
+	}
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go r.handleConfirms(conn, channel, ch.NotifyPublish(make(chan amqp.Confirmation, 16)))
+
+	r.mu.Lock()
+	r.conn, r.channel = conn, channel
+	r.mu.Unlock()
+
+	return connClosed, nil
+}
+
+// reconnect connects again whenever the connection closes, until Close is called. Failed
+// attempts are retried with a doubling delay.
+func (r *RabbitMQ) reconnect(connClosed chan *amqp.Error) {
+	for {
+		select {
+		case <-r.done:
+			return
+		case err := <-connClosed:
+			slog.Error("rabbit mq connection closed, reconnecting", "error", err)
+		}
+
+		delay := reconnectMinDelay
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-time.After(delay):
+			}
+
+			var err error
+			connClosed, err = r.connect()
+			if err == nil {
+				slog.Info("reconnected to rabbit mq")
+				break
+			}
+
+			slog.Warn("can't reconnect to rabbit mq", "error", err, "retry_in", delay)
+			delay = min(delay*2, reconnectMaxDelay)
+		}
+	}
+}
+
+// PublishJSON marshals the payload to JSON and publishes it to the notifications exchange
+// with the given routing key. The request id of the context is sent in the x-request-id header,
+// so a notification can be traced back to the call that caused it, and the trace context in the
+// traceparent and tracestate headers, so the consumer continues the trace. It returns once the
+// broker confirmed the message.
+func (r *RabbitMQ) PublishJSON(ctx context.Context, routingKey string, payload interface{}) (err error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := tracing.Tracer.Start(ctx, ExchangeName+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(ExchangeName),
+			semconv.MessagingRabbitmqDestinationRoutingKey(routingKey),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	pending, err := r.publish(routingKey, amqp.Publishing{
+		ContentType: "application/json",
+		Headers:     publishHeaders(ctx),
+		Body:        body,
+	})
+	if err != nil {
+		metrics.RabbitMQPublishFailed(routingKey)
+		return err
+	}
+
+	// A publish that stops waiting still gets its confirmation recorded by handleConfirms.
+	select {
+	case err = <-pending.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publish sends the message to the notifications exchange and registers it for its
+// confirmation. The lock is held only while the message is numbered and sent, not while the
+// broker confirms it.
+func (r *RabbitMQ) publish(routingKey string, msg amqp.Publishing) (*pendingConfirm, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	channel := r.channel
+	if channel.closed {
+		return nil, ErrChannelClosed
+	}
+
+	pending := &pendingConfirm{routingKey: routingKey, start: time.Now(), result: make(chan error, 1)}
+	err := channel.channel.Publish(
+		ExchangeName, // exchange
+		routingKey,   // routing key
+		false,        // mandatory
+		false,        // immediate
+		msg)
+	if err != nil {
 		return nil, err
 	}
+	channel.deliveryTag++
+	channel.pending[channel.deliveryTag] = pending
+
+	return pending, nil
+}
+
+// handleConfirms hands the broker's confirmations to the publishes waiting for them and records
+// the confirm latency. Once the channel closes, the publishes still waiting fail and the
+// connection is closed too, so a channel closed by the broker is replaced by reconnecting.
+func (r *RabbitMQ) handleConfirms(conn *amqp.Connection, channel *confirmChannel, confirms <-chan amqp.Confirmation) {
+	for confirmation := range confirms {
+		r.mu.Lock()
+		pending, ok := channel.pending[confirmation.DeliveryTag]
+		delete(channel.pending, confirmation.DeliveryTag)
+		r.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		if !confirmation.Ack {
+			metrics.RabbitMQPublishFailed(pending.routingKey)
+			pending.result <- ErrNotConfirmed
+			continue
+		}
+		metrics.ObserveRabbitMQConfirm(pending.routingKey, time.Since(pending.start))
+		pending.result <- nil
+	}
+
+	r.mu.Lock()
+	channel.closed = true
+	for deliveryTag, pending := range channel.pending {
+		metrics.RabbitMQPublishFailed(pending.routingKey)
+		pending.result <- ErrChannelClosed
+		delete(channel.pending, deliveryTag)
+	}
+	r.mu.Unlock()
+
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		slog.Warn("can't close rabbit mq connection", "error", err)
+	}
+}
+
+// Check returns an error while the connection or the channel to the broker is closed, until
+// the connection is reestablished.
+func (r *RabbitMQ) Check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn.IsClosed() {
+		return ErrConnectionClosed
+	}
+	if r.channel.closed {
+		return ErrChannelClosed
+	}
+	return nil
+}
+
+// publishHeaders returns the headers of a message published within the context: the request
+// id and the trace context of the publish span.
+func publishHeaders(ctx context.Context) amqp.Table {
+	headers := amqp.Table{}
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		headers[requestid.Header] = requestID
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	return headers
+}
 
-	return &RabbitMQ{
-		Conn:    conn,
-		Channel: ch,
-	}, nil
+// headerCarrier lets the trace context propagator read and write AMQP headers.
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
 }
 
-// Close cleanly closes the RabbitMQ channel and connection.
+// Close stops reconnecting and cleanly closes the RabbitMQ channel and connection.
 // It logs any errors encountered during the closing process.
 func (r *RabbitMQ) Close() {
-	if r.Channel != nil {
-		if err := r.Channel.Close(); err != nil {
-			log.Printf("error closing channel: %v", err)
-		}
+	close(r.done)
+
+	r.mu.Lock()
+	conn, channel := r.conn, r.channel
+	r.mu.Unlock()
+
+	if err := channel.channel.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		slog.Error("can't close rabbit mq channel", "error", err)
 	}
-	if r.Conn != nil {
-		if err := r.Conn.Close(); err != nil {
-			log.Printf("error closing connection: %v", err)
-		}
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		slog.Error("can't close rabbit mq connection", "error", err)
 	}
 }
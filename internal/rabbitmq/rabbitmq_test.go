@@ -0,0 +1,64 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/imhasandl/message-service/internal/requestid"
+	"github.com/imhasandl/message-service/internal/tracing"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestMain installs the trace context propagator and a tracer provider, as main does.
+func TestMain(m *testing.M) {
+	stop, err := tracing.Start(context.Background(), tracetest.NewInMemoryExporter())
+	if err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	stop(context.Background())
+	os.Exit(code)
+}
+
+func TestPublishHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		traced    bool
+	}{
+		{name: "request id and trace", requestID: "req-1", traced: true},
+		{name: "trace only", traced: true},
+		{name: "request id only", requestID: "req-2"},
+		{name: "neither"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.requestID != "" {
+				ctx = requestid.NewContext(ctx, tt.requestID)
+			}
+			wantTraceparent := ""
+			if tt.traced {
+				var span trace.Span
+				ctx, span = tracing.Tracer.Start(ctx, ExchangeName+" publish")
+				defer span.End()
+
+				spanContext := span.SpanContext()
+				wantTraceparent = fmt.Sprintf("00-%s-%s-01", spanContext.TraceID(), spanContext.SpanID())
+			}
+
+			headers := publishHeaders(ctx)
+			if got, _ := headers[requestid.Header].(string); got != tt.requestID {
+				t.Errorf("%s header = %q, want %q", requestid.Header, got, tt.requestID)
+			}
+			if got, _ := headers["traceparent"].(string); got != wantTraceparent {
+				t.Errorf("traceparent header = %q, want %q", got, wantTraceparent)
+			}
+		})
+	}
+}
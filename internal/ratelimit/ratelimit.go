@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Limit allows Requests requests in every WindowSeconds long sliding window. A zero limit is not enforced.
+type Limit struct {
+	Requests      int64 `json:"requests"`
+	WindowSeconds int   `json:"window_seconds"`
+}
+
+// Window returns the length of the sliding window.
+func (l Limit) Window() time.Duration {
+	return time.Duration(l.WindowSeconds) * time.Second
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.WindowSeconds > 0
+}
+
+// MethodLimits are the limits applied to a single gRPC method. Premium limits replace the
+// regular ones for users with users.is_premium set.
+type MethodLimits struct {
+	User        Limit `json:"user"`
+	PremiumUser Limit `json:"premium_user"`
+	Pair        Limit `json:"pair"`
+	PremiumPair Limit `json:"premium_pair"`
+	Global      Limit `json:"global"`
+}
+
+// Config maps gRPC method names, such as "SendMessage", to their limits.
+type Config map[string]MethodLimits
+
+// DefaultConfig is used when no config file is given.
+func DefaultConfig() Config {
+	return Config{
+		"SendMessage": {
+			User:        Limit{Requests: 30, WindowSeconds: 60},
+			PremiumUser: Limit{Requests: 120, WindowSeconds: 60},
+			Pair:        Limit{Requests: 10, WindowSeconds: 60},
+			PremiumPair: Limit{Requests: 30, WindowSeconds: 60},
+			Global:      Limit{Requests: 5000, WindowSeconds: 60},
+		},
+		"GetPreKeyBundles": {
+			User:   Limit{Requests: 20, WindowSeconds: 60},
+			Global: Limit{Requests: 2000, WindowSeconds: 60},
+		},
+	}
+}
+
+// LoadConfig reads the config from a JSON file. An empty path returns DefaultConfig.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 -- the path comes from the service configuration
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Window is a sliding window a request is counted in.
+type Window struct {
+	Key    string
+	Limit  int64
+	Length time.Duration
+}
+
+// Store records requests in sliding windows shared by every replica of the service. Allow
+// checks all windows at once and records the request in them only when every window has room,
+// so a rejected request doesn't use up any window.
+type Store interface {
+	Allow(ctx context.Context, windows []Window) (bool, time.Duration, error)
+}
+
+// StoreFunc adapts a function to the Store interface.
+type StoreFunc func(ctx context.Context, windows []Window) (bool, time.Duration, error)
+
+// Allow calls f(ctx, windows).
+func (f StoreFunc) Allow(ctx context.Context, windows []Window) (bool, time.Duration, error) {
+	return f(ctx, windows)
+}
+
+// Request describes the call being limited. CallerID and ReceiverID are empty when unknown.
+type Request struct {
+	Method     string
+	CallerID   string
+	ReceiverID string
+	Premium    bool
+}
+
+// Limiter applies the configured limits to requests.
+type Limiter struct {
+	config Config
+	store  Store
+}
+
+// NewLimiter creates a limiter that keeps its windows in the store.
+func NewLimiter(config Config, store Store) *Limiter {
+	return &Limiter{config: config, store: store}
+}
+
+// Limits reports whether any limit is configured for the method.
+func (l *Limiter) Limits(method string) bool {
+	_, ok := l.config[method]
+	return ok
+}
+
+// Allow checks the global, per caller and per caller and receiver pair limits of the request.
+// When a limit is exceeded it returns false and the time to wait before retrying, and the
+// request isn't counted in any window.
+func (l *Limiter) Allow(ctx context.Context, req Request) (bool, time.Duration, error) {
+	limits, ok := l.config[req.Method]
+	if !ok {
+		return true, 0, nil
+	}
+
+	var windows []Window
+	for _, check := range limits.checks(req) {
+		if check.limit.enabled() {
+			windows = append(windows, Window{Key: check.key, Limit: check.limit.Requests, Length: check.limit.Window()})
+		}
+	}
+	if len(windows) == 0 {
+		return true, 0, nil
+	}
+
+	return l.store.Allow(ctx, windows)
+}
+
+type limitCheck struct {
+	key   string
+	limit Limit
+}
+
+// checks returns the windows the request is counted in. Premium limits are used for premium
+// users when they are configured.
+func (m MethodLimits) checks(req Request) []limitCheck {
+	userLimit, pairLimit := m.User, m.Pair
+	if req.Premium && m.PremiumUser.enabled() {
+		userLimit = m.PremiumUser
+	}
+	if req.Premium && m.PremiumPair.enabled() {
+		pairLimit = m.PremiumPair
+	}
+
+	checks := []limitCheck{{fmt.Sprintf("global:%s", req.Method), m.Global}}
+	if req.CallerID == "" {
+		return checks
+	}
+
+	checks = append(checks, limitCheck{fmt.Sprintf("user:%s:%s", req.Method, req.CallerID), userLimit})
+	if req.ReceiverID != "" {
+		checks = append(checks, limitCheck{fmt.Sprintf("pair:%s:%s:%s", req.Method, req.CallerID, req.ReceiverID), pairLimit})
+	}
+
+	return checks
+}
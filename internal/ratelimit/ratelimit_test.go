@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	config := Config{
+		"SendMessage": {
+			User:        Limit{Requests: 30, WindowSeconds: 60},
+			PremiumUser: Limit{Requests: 120, WindowSeconds: 60},
+			Pair:        Limit{Requests: 10, WindowSeconds: 60},
+			Global:      Limit{Requests: 5000, WindowSeconds: 1},
+		},
+		"ChangeMessage": {
+			User: Limit{Requests: 5, WindowSeconds: 10},
+			Pair: Limit{Requests: 0, WindowSeconds: 10},
+		},
+		"DeleteMessage": {},
+	}
+
+	tests := []struct {
+		name        string
+		req         Request
+		wantWindows []Window
+	}{
+		{
+			name: "unlimited method",
+			req:  Request{Method: "GetMessages", CallerID: "alice"},
+		},
+		{
+			name: "every limit disabled",
+			req:  Request{Method: "DeleteMessage", CallerID: "alice", ReceiverID: "bob"},
+		},
+		{
+			name: "unknown caller",
+			req:  Request{Method: "SendMessage"},
+			wantWindows: []Window{
+				{Key: "global:SendMessage", Limit: 5000, Length: time.Second},
+			},
+		},
+		{
+			name: "caller without receiver",
+			req:  Request{Method: "SendMessage", CallerID: "alice"},
+			wantWindows: []Window{
+				{Key: "global:SendMessage", Limit: 5000, Length: time.Second},
+				{Key: "user:SendMessage:alice", Limit: 30, Length: time.Minute},
+			},
+		},
+		{
+			name: "caller and receiver",
+			req:  Request{Method: "SendMessage", CallerID: "alice", ReceiverID: "bob"},
+			wantWindows: []Window{
+				{Key: "global:SendMessage", Limit: 5000, Length: time.Second},
+				{Key: "user:SendMessage:alice", Limit: 30, Length: time.Minute},
+				{Key: "pair:SendMessage:alice:bob", Limit: 10, Length: time.Minute},
+			},
+		},
+		{
+			name: "premium caller",
+			req:  Request{Method: "SendMessage", CallerID: "alice", ReceiverID: "bob", Premium: true},
+			wantWindows: []Window{
+				{Key: "global:SendMessage", Limit: 5000, Length: time.Second},
+				{Key: "user:SendMessage:alice", Limit: 120, Length: time.Minute},
+				{Key: "pair:SendMessage:alice:bob", Limit: 10, Length: time.Minute},
+			},
+		},
+		{
+			name: "disabled limits are skipped",
+			req:  Request{Method: "ChangeMessage", CallerID: "alice", ReceiverID: "bob", Premium: true},
+			wantWindows: []Window{
+				{Key: "user:ChangeMessage:alice", Limit: 5, Length: 10 * time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotWindows []Window
+			store := StoreFunc(func(_ context.Context, windows []Window) (bool, time.Duration, error) {
+				gotWindows = windows
+				return true, 0, nil
+			})
+
+			allowed, _, err := NewLimiter(config, store).Allow(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("Allow() error = %v", err)
+			}
+			if !allowed {
+				t.Error("Allow() = false, want true")
+			}
+			if !reflect.DeepEqual(gotWindows, tt.wantWindows) {
+				t.Errorf("Allow() windows = %+v, want %+v", gotWindows, tt.wantWindows)
+			}
+		})
+	}
+}
+
+func TestLimiterAllowReturnsStoreAnswer(t *testing.T) {
+	storeErr := errors.New("unavailable")
+
+	tests := []struct {
+		name        string
+		allowed     bool
+		retryAfter  time.Duration
+		err         error
+		wantAllowed bool
+	}{
+		{name: "allowed", allowed: true, wantAllowed: true},
+		{name: "limited", retryAfter: 3 * time.Second},
+		{name: "store fails", err: storeErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := StoreFunc(func(context.Context, []Window) (bool, time.Duration, error) {
+				return tt.allowed, tt.retryAfter, tt.err
+			})
+
+			limiter := NewLimiter(DefaultConfig(), store)
+			allowed, retryAfter, err := limiter.Allow(context.Background(), Request{Method: "SendMessage", CallerID: "alice"})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Allow() error = %v, want %v", err, tt.err)
+			}
+			if allowed != tt.wantAllowed || retryAfter != tt.retryAfter {
+				t.Errorf("Allow() = %v, %v, want %v, %v", allowed, retryAfter, tt.wantAllowed, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestLimiterLimits(t *testing.T) {
+	limiter := NewLimiter(DefaultConfig(), nil)
+
+	if !limiter.Limits("SendMessage") {
+		t.Error(`Limits("SendMessage") = false, want true`)
+	}
+	if !limiter.Limits("GetPreKeyBundles") {
+		t.Error(`Limits("GetPreKeyBundles") = false, want true`)
+	}
+	if limiter.Limits("GetMessages") {
+		t.Error(`Limits("GetMessages") = true, want false`)
+	}
+}
@@ -39,4 +39,12 @@ func InitRedisClient(cfg *RedisConfig) {
 	if err != nil {
 		panic("Redis client is not working: " + err.Error())
 	}
+
+	Client = client
+}
+
+// Ping checks that Redis is reachable. It isn't traced, the health checker pings every few
+// seconds.
+func Ping(ctx context.Context) error {
+	return Client.WithContext(ctx).Ping().Err()
 }
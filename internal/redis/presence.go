@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// OnlineTTL is how long a user stays online after their last activity.
+	OnlineTTL = time.Minute
+	// lastSeenTTL is how long the last seen time of a user is kept.
+	lastSeenTTL = 24 * time.Hour
+)
+
+// EventMessage is a real-time event received from a subscribed channel.
+type EventMessage = redis.Message
+
+// Presence is the presence of a user as stored in Redis. LastSeen is zero when the user
+// wasn't seen within the last seen TTL.
+type Presence struct {
+	Online   bool
+	LastSeen time.Time
+}
+
+// closeConnectionScript removes a connection from the sorted set of the open connections of a
+// user, scored by when they expire, together with the expired ones. KEYS are the connections,
+// online and last seen keys and ARGV the connection id, the current time in milliseconds and
+// seconds and the last seen TTL in seconds. It returns 1 when no connection is left and the
+// user went offline, and 0 otherwise.
+var closeConnectionScript = redis.NewScript(`
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[2])
+redis.call("SET", KEYS[3], ARGV[3], "EX", ARGV[4])
+
+if redis.call("ZCARD", KEYS[1]) > 0 then
+	return 0
+end
+
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+// TouchPresence marks the user as online and stores the time as their last seen time. A
+// connection, such as an event stream, is kept open for another OnlineTTL when its id isn't
+// empty. It reports whether the user was offline before, so callers can announce the change.
+func TouchPresence(ctx context.Context, userID, connectionID string, now time.Time) (bool, error) {
+	onlineKey := fmt.Sprintf("online:%s", userID)
+	pipe := client(ctx).TxPipeline()
+	wentOnline := pipe.SetNX(onlineKey, 1, OnlineTTL)
+	pipe.Expire(onlineKey, OnlineTTL)
+	pipe.Set(fmt.Sprintf("last_seen:%s", userID), now.Unix(), lastSeenTTL)
+	if connectionID != "" {
+		connectionsKey := fmt.Sprintf("connections:%s", userID)
+		pipe.ZAdd(connectionsKey, redis.Z{Score: float64(now.Add(OnlineTTL).UnixMilli()), Member: connectionID})
+		pipe.Expire(connectionsKey, OnlineTTL)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return false, err
+	}
+	return wentOnline.Val(), nil
+}
+
+// CloseConnection closes a connection of the user and stores the time as their last seen time.
+// The user goes offline only when no other connection is open; connections that weren't touched
+// within OnlineTTL, such as the ones of a crashed replica, don't count. It reports whether the
+// user went offline.
+func CloseConnection(ctx context.Context, userID, connectionID string, now time.Time) (bool, error) {
+	keys := []string{
+		fmt.Sprintf("connections:%s", userID),
+		fmt.Sprintf("online:%s", userID),
+		fmt.Sprintf("last_seen:%s", userID),
+	}
+
+	wentOffline, err := closeConnectionScript.Run(client(ctx), keys, connectionID, now.UnixMilli(), now.Unix(), int64(lastSeenTTL.Seconds())).Int64()
+	if err != nil {
+		return false, err
+	}
+	return wentOffline == 1, nil
+}
+
+// GetPresence retrieves the presence of the users, in the same order
+func GetPresence(ctx context.Context, userIDs []string) ([]Presence, error) {
+	pipe := client(ctx).Pipeline()
+	online := make([]*redis.IntCmd, len(userIDs))
+	lastSeen := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		online[i] = pipe.Exists(fmt.Sprintf("online:%s", userID))
+		lastSeen[i] = pipe.Get(fmt.Sprintf("last_seen:%s", userID))
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	presences := make([]Presence, len(userIDs))
+	for i := range userIDs {
+		presences[i].Online = online[i].Val() > 0
+		if seconds, err := strconv.ParseInt(lastSeen[i].Val(), 10, 64); err == nil {
+			presences[i].LastSeen = time.Unix(seconds, 0)
+		}
+	}
+	return presences, nil
+}
+
+// PublishEvent publishes a real-time event to the subscribers of the channel
+func PublishEvent(ctx context.Context, channel string, event []byte) error {
+	return client(ctx).Publish(channel, event).Err()
+}
+
+// SubscribeEvents subscribes to real-time events published to the channels
+func SubscribeEvents(channels ...string) *redis.PubSub {
+	return Client.Subscribe(channels...)
+}
+
+// UserEventsChannel is the channel of the events addressed to the user, such as typing
+func UserEventsChannel(userID string) string {
+	return fmt.Sprintf("events:%s", userID)
+}
+
+// PresenceEventsChannel is the channel of the presence changes of the user
+func PresenceEventsChannel(userID string) string {
+	return fmt.Sprintf("presence_events:%s", userID)
+}
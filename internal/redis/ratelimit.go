@@ -0,0 +1,70 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/imhasandl/message-service/internal/ratelimit"
+)
+
+// slidingWindowScript keeps the timestamps of the requests made in the current window of every
+// key in a sorted set. ARGV holds the current time and the member to add, followed by the window
+// length and the limit of every key. The request is recorded only when it fits in every window:
+// it returns {1, 0} then and {0, retry_after_ms} otherwise, waiting for the fullest window.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local allowed = true
+local retry_after = 0
+
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[1 + i * 2])
+	local limit = tonumber(ARGV[2 + i * 2])
+
+	redis.call("ZREMRANGEBYSCORE", key, 0, now - window)
+	if redis.call("ZCARD", key) >= limit then
+		allowed = false
+		local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+		retry_after = math.max(retry_after, tonumber(oldest[2]) + window - now)
+	end
+end
+
+if not allowed then
+	return {0, retry_after}
+end
+
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[1 + i * 2])
+	redis.call("ZADD", key, now, ARGV[2])
+	redis.call("PEXPIRE", key, window)
+end
+return {1, 0}
+`)
+
+// SlidingWindowAllow records a request in the windows when it fits in all of their limits and
+// reports whether it did, together with the time to wait before retrying when it doesn't
+func SlidingWindowAllow(ctx context.Context, windows []ratelimit.Window) (bool, time.Duration, error) {
+	keys := make([]string, len(windows))
+	args := []interface{}{time.Now().UnixMilli(), uuid.NewString()}
+	for i, window := range windows {
+		keys[i] = fmt.Sprintf("rate_limit:%s", window.Key)
+		args = append(args, window.Length.Milliseconds(), window.Limit)
+	}
+
+	result, err := slidingWindowScript.Run(client(ctx), keys, args...).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+
+	allowed, _ := values[0].(int64)
+	retryAfter, _ := values[1].(int64)
+
+	return allowed == 1, time.Duration(retryAfter) * time.Millisecond, nil
+}
@@ -1,25 +1,29 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/imhasandl/message-service/internal/metrics"
 )
 
 // CacheMessages stores messages between two users in Redis
-func CacheMessages(senderID, receiverID string, messages interface{}) error {
+func CacheMessages(ctx context.Context, senderID, receiverID string, messages interface{}) error {
 	key := fmt.Sprintf("messages:%s:%s", senderID, receiverID)
 	data, err := json.Marshal(messages)
 	if err != nil {
 		return err
 	}
-	return Client.Set(key, data, 10*time.Minute).Err()
+	return client(ctx).Set(key, data, 10*time.Minute).Err()
 }
 
 // GetCachedMessages retrieves cached messages between two users
-func GetCachedMessages(senderID, receiverID string, result interface{}) error {
+func GetCachedMessages(ctx context.Context, senderID, receiverID string, result interface{}) error {
 	key := fmt.Sprintf("messages:%s:%s", senderID, receiverID)
-	data, err := Client.Get(key).Result()
+	data, err := get(ctx, "messages", key).Result()
 	if err != nil {
 		return err
 	}
@@ -27,26 +31,26 @@ func GetCachedMessages(senderID, receiverID string, result interface{}) error {
 }
 
 // InvalidateMessagesCache removes cached messages for both directions of conversation
-func InvalidateMessagesCache(senderID, receiverID string) error {
+func InvalidateMessagesCache(ctx context.Context, senderID, receiverID string) error {
 	key1 := fmt.Sprintf("messages:%s:%s", senderID, receiverID)
 	key2 := fmt.Sprintf("messages:%s:%s", receiverID, senderID)
-	return Client.Del(key1, key2).Err()
+	return client(ctx).Del(key1, key2).Err()
 }
 
 // CacheUser stores user data in Redis
-func CacheUser(userID string, userData interface{}) error {
+func CacheUser(ctx context.Context, userID string, userData interface{}) error {
 	key := fmt.Sprintf("user_data:%s", userID)
 	data, err := json.Marshal(userData)
 	if err != nil {
 		return err
 	}
-	return Client.Set(key, data, 30*time.Minute).Err()
+	return client(ctx).Set(key, data, 30*time.Minute).Err()
 }
 
 // GetCachedUser retrieves cached user data
-func GetCachedUser(userID string, result interface{}) error {
+func GetCachedUser(ctx context.Context, userID string, result interface{}) error {
 	key := fmt.Sprintf("user_data:%s", userID)
-	data, err := Client.Get(key).Result()
+	data, err := get(ctx, "user_data", key).Result()
 	if err != nil {
 		return err
 	}
@@ -54,44 +58,231 @@ func GetCachedUser(userID string, result interface{}) error {
 }
 
 // DeleteCachedUser removes cached user data
-func DeleteCachedUser(userID string) error {
+func DeleteCachedUser(ctx context.Context, userID string) error {
 	key := fmt.Sprintf("user_data:%s", userID)
-	return Client.Del(key).Err()
+	return client(ctx).Del(key).Err()
+}
+
+// CacheBlockedUsers stores the list of users blocked by the given user
+func CacheBlockedUsers(ctx context.Context, userID string, blockedUsers interface{}) error {
+	key := fmt.Sprintf("user_blocks:%s", userID)
+	data, err := json.Marshal(blockedUsers)
+	if err != nil {
+		return err
+	}
+	return client(ctx).Set(key, data, 30*time.Minute).Err()
+}
+
+// GetCachedBlockedUsers retrieves the cached list of users blocked by the given user
+func GetCachedBlockedUsers(ctx context.Context, userID string, result interface{}) error {
+	key := fmt.Sprintf("user_blocks:%s", userID)
+	data, err := get(ctx, "user_blocks", key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), result)
+}
+
+// InvalidateBlockedUsers removes the cached block list of the given user
+func InvalidateBlockedUsers(ctx context.Context, userID string) error {
+	key := fmt.Sprintf("user_blocks:%s", userID)
+	return client(ctx).Del(key).Err()
+}
+
+// CachePrivacySettings stores the user's privacy settings
+func CachePrivacySettings(ctx context.Context, userID string, settings interface{}) error {
+	key := fmt.Sprintf("privacy_settings:%s", userID)
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return client(ctx).Set(key, data, 30*time.Minute).Err()
+}
+
+// GetCachedPrivacySettings retrieves the user's cached privacy settings
+func GetCachedPrivacySettings(ctx context.Context, userID string, result interface{}) error {
+	key := fmt.Sprintf("privacy_settings:%s", userID)
+	data, err := get(ctx, "privacy_settings", key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), result)
+}
+
+// InvalidatePrivacySettings removes the user's cached privacy settings
+func InvalidatePrivacySettings(ctx context.Context, userID string) error {
+	key := fmt.Sprintf("privacy_settings:%s", userID)
+	return client(ctx).Del(key).Err()
+}
+
+// CacheConversationSettings stores the settings of the conversation between two users
+func CacheConversationSettings(ctx context.Context, userLow, userHigh string, settings interface{}) error {
+	key := fmt.Sprintf("conversation_settings:%s:%s", userLow, userHigh)
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return client(ctx).Set(key, data, 30*time.Minute).Err()
+}
+
+// GetCachedConversationSettings retrieves the cached settings of the conversation between two users
+func GetCachedConversationSettings(ctx context.Context, userLow, userHigh string, result interface{}) error {
+	key := fmt.Sprintf("conversation_settings:%s:%s", userLow, userHigh)
+	data, err := get(ctx, "conversation_settings", key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), result)
+}
+
+// InvalidateConversationSettings removes the cached settings of the conversation between two users
+func InvalidateConversationSettings(ctx context.Context, userLow, userHigh string) error {
+	key := fmt.Sprintf("conversation_settings:%s:%s", userLow, userHigh)
+	return client(ctx).Del(key).Err()
+}
+
+// CacheDrafts stores the user's message drafts
+func CacheDrafts(ctx context.Context, userID string, drafts interface{}) error {
+	key := fmt.Sprintf("drafts:%s", userID)
+	data, err := json.Marshal(drafts)
+	if err != nil {
+		return err
+	}
+	return client(ctx).Set(key, data, 30*time.Minute).Err()
+}
+
+// GetCachedDrafts retrieves the user's cached message drafts
+func GetCachedDrafts(ctx context.Context, userID string, result interface{}) error {
+	key := fmt.Sprintf("drafts:%s", userID)
+	data, err := get(ctx, "drafts", key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), result)
+}
+
+// InvalidateDrafts removes the user's cached message drafts
+func InvalidateDrafts(ctx context.Context, userID string) error {
+	key := fmt.Sprintf("drafts:%s", userID)
+	return client(ctx).Del(key).Err()
+}
+
+// CachePinnedMessageIDs stores the ids of the pinned messages of the conversation between two users
+func CachePinnedMessageIDs(ctx context.Context, userLow, userHigh string, messageIDs interface{}) error {
+	key := fmt.Sprintf("pinned_messages:%s:%s", userLow, userHigh)
+	data, err := json.Marshal(messageIDs)
+	if err != nil {
+		return err
+	}
+	return client(ctx).Set(key, data, 30*time.Minute).Err()
+}
+
+// GetCachedPinnedMessageIDs retrieves the cached pinned message ids of the conversation between two users
+func GetCachedPinnedMessageIDs(ctx context.Context, userLow, userHigh string, result interface{}) error {
+	key := fmt.Sprintf("pinned_messages:%s:%s", userLow, userHigh)
+	data, err := get(ctx, "pinned_messages", key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), result)
+}
+
+// InvalidatePinnedMessageIDs removes the cached pinned message ids of the conversation between two users
+func InvalidatePinnedMessageIDs(ctx context.Context, userLow, userHigh string) error {
+	key := fmt.Sprintf("pinned_messages:%s:%s", userLow, userHigh)
+	return client(ctx).Del(key).Err()
+}
+
+// pollTTL is how long the state of a poll and the version of its latest change are cached.
+const pollTTL = 30 * time.Minute
+
+// cachePollScript caches the state of a poll unless a newer change was recorded. KEYS are the
+// poll and poll version keys and ARGV the version of the state, the state and the TTL in seconds.
+var cachePollScript = redis.NewScript(`
+local latest = tonumber(redis.call("GET", KEYS[2]))
+if latest and latest > tonumber(ARGV[1]) then
+	return 0
+end
+
+redis.call("SET", KEYS[1], ARGV[2], "EX", ARGV[3])
+return 1
+`)
+
+// invalidatePollScript removes the cached state of a poll and records the version of the
+// change, unless a newer one is recorded. KEYS are the poll and poll version keys and ARGV the
+// version and the TTL in seconds.
+var invalidatePollScript = redis.NewScript(`
+local latest = tonumber(redis.call("GET", KEYS[2]))
+if not latest or latest < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[2], ARGV[1], "EX", ARGV[2])
+end
+
+redis.call("DEL", KEYS[1])
+return 1
+`)
+
+// CachePoll stores the state and ballots of the poll in the message, read at the given
+// version. A state older than the latest change passed to InvalidatePoll isn't stored, so a
+// slow read can't cache the results from before a vote.
+func CachePoll(ctx context.Context, messageID string, version int64, poll interface{}) error {
+	data, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+	keys := []string{fmt.Sprintf("poll:%s", messageID), fmt.Sprintf("poll_version:%s", messageID)}
+	return cachePollScript.Run(client(ctx), keys, version, data, int64(pollTTL.Seconds())).Err()
+}
+
+// GetCachedPoll retrieves the cached state and ballots of the poll in the message
+func GetCachedPoll(ctx context.Context, messageID string, result interface{}) error {
+	key := fmt.Sprintf("poll:%s", messageID)
+	data, err := get(ctx, "poll", key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), result)
+}
+
+// InvalidatePoll removes the cached state and ballots of the poll in the message after a change
+// with the given version
+func InvalidatePoll(ctx context.Context, messageID string, version int64) error {
+	keys := []string{fmt.Sprintf("poll:%s", messageID), fmt.Sprintf("poll_version:%s", messageID)}
+	return invalidatePollScript.Run(client(ctx), keys, version, int64(pollTTL.Seconds())).Err()
 }
 
 // CacheMessageCount stores message count for pagination
-func CacheMessageCount(senderID, receiverID string, count int64) error {
+func CacheMessageCount(ctx context.Context, senderID, receiverID string, count int64) error {
 	key := fmt.Sprintf("message_count:%s:%s", senderID, receiverID)
-	return Client.Set(key, count, 5*time.Minute).Err()
+	return client(ctx).Set(key, count, 5*time.Minute).Err()
 }
 
 // GetCachedMessageCount retrieves cached message count
-func GetCachedMessageCount(senderID, receiverID string) (int64, error) {
+func GetCachedMessageCount(ctx context.Context, senderID, receiverID string) (int64, error) {
 	key := fmt.Sprintf("message_count:%s:%s", senderID, receiverID)
-	return Client.Get(key).Int64()
+	return get(ctx, "message_count", key).Int64()
 }
 
 // DeleteMessageCount removes cached message count
-func DeleteMessageCount(senderID, receiverID string) error {
+func DeleteMessageCount(ctx context.Context, senderID, receiverID string) error {
 	key1 := fmt.Sprintf("message_count:%s:%s", senderID, receiverID)
 	key2 := fmt.Sprintf("message_count:%s:%s", receiverID, senderID)
-	return Client.Del(key1, key2).Err()
+	return client(ctx).Del(key1, key2).Err()
 }
 
 // CacheConversationList stores user's conversation list
-func CacheConversationList(userID string, conversations interface{}) error {
+func CacheConversationList(ctx context.Context, userID string, conversations interface{}) error {
 	key := fmt.Sprintf("conversations:%s", userID)
 	data, err := json.Marshal(conversations)
 	if err != nil {
 		return err
 	}
-	return Client.Set(key, data, 15*time.Minute).Err()
+	return client(ctx).Set(key, data, 15*time.Minute).Err()
 }
 
 // GetCachedConversationList retrieves cached conversation list
-func GetCachedConversationList(userID string, result interface{}) error {
+func GetCachedConversationList(ctx context.Context, userID string, result interface{}) error {
 	key := fmt.Sprintf("conversations:%s", userID)
-	data, err := Client.Get(key).Result()
+	data, err := get(ctx, "conversations", key).Result()
 	if err != nil {
 		return err
 	}
@@ -99,25 +290,25 @@ func GetCachedConversationList(userID string, result interface{}) error {
 }
 
 // InvalidateConversationList removes cached conversation list
-func InvalidateConversationList(userID string) error {
+func InvalidateConversationList(ctx context.Context, userID string) error {
 	key := fmt.Sprintf("conversations:%s", userID)
-	return Client.Del(key).Err()
+	return client(ctx).Del(key).Err()
 }
 
 // CacheLastMessage stores the last message in a conversation
-func CacheLastMessage(senderID, receiverID string, message interface{}) error {
+func CacheLastMessage(ctx context.Context, senderID, receiverID string, message interface{}) error {
 	key := fmt.Sprintf("last_message:%s:%s", senderID, receiverID)
 	data, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
-	return Client.Set(key, data, 20*time.Minute).Err()
+	return client(ctx).Set(key, data, 20*time.Minute).Err()
 }
 
 // GetCachedLastMessage retrieves the last message in a conversation
-func GetCachedLastMessage(senderID, receiverID string, result interface{}) error {
+func GetCachedLastMessage(ctx context.Context, senderID, receiverID string, result interface{}) error {
 	key := fmt.Sprintf("last_message:%s:%s", senderID, receiverID)
-	data, err := Client.Get(key).Result()
+	data, err := get(ctx, "last_message", key).Result()
 	if err != nil {
 		return err
 	}
@@ -125,8 +316,35 @@ func GetCachedLastMessage(senderID, receiverID string, result interface{}) error
 }
 
 // InvalidateLastMessage removes cached last message
-func InvalidateLastMessage(senderID, receiverID string) error {
+func InvalidateLastMessage(ctx context.Context, senderID, receiverID string) error {
 	key1 := fmt.Sprintf("last_message:%s:%s", senderID, receiverID)
 	key2 := fmt.Sprintf("last_message:%s:%s", receiverID, senderID)
-	return Client.Del(key1, key2).Err()
+	return client(ctx).Del(key1, key2).Err()
+}
+
+// get reads the key and records the lookup as a hit or a miss of its key family
+func get(ctx context.Context, family, key string) *redis.StringCmd {
+	cmd := client(ctx).Get(key)
+	switch err := cmd.Err(); {
+	case err == nil:
+		metrics.ObserveCacheLookup(family, metrics.CacheHit)
+	case err == redis.Nil:
+		metrics.ObserveCacheLookup(family, metrics.CacheMiss)
+	default:
+		metrics.ObserveCacheLookup(family, metrics.CacheError)
+	}
+	return cmd
+}
+
+// IncrementContentRepeat counts how many times the sender sent content with the given hash,
+// the counter expires once the sender doesn't repeat the content for the whole window
+func IncrementContentRepeat(ctx context.Context, senderID, contentHash string, window time.Duration) (int64, error) {
+	key := fmt.Sprintf("moderation_repeats:%s:%s", senderID, contentHash)
+	pipe := client(ctx).TxPipeline()
+	incr := pipe.Incr(key)
+	pipe.Expire(key, window)
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
 }
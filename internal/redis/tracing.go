@@ -0,0 +1,58 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/imhasandl/message-service/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// client returns the client to run commands with. It records a span for every command and
+// pipeline as a child of the span in the context.
+func client(ctx context.Context) *redis.Client {
+	c := Client.WithContext(ctx)
+	c.WrapProcess(func(process func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			name := strings.ToUpper(cmd.Name())
+			_, span := startCommand(ctx, name, name)
+			err := process(cmd)
+			tracing.End(span, commandError(err))
+			return err
+		}
+	})
+	c.WrapProcessPipeline(func(process func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			names := make([]string, len(cmds))
+			for i, cmd := range cmds {
+				names[i] = strings.ToUpper(cmd.Name())
+			}
+			_, span := startCommand(ctx, "PIPELINE", strings.Join(names, " "))
+			err := process(cmds)
+			tracing.End(span, commandError(err))
+			return err
+		}
+	})
+
+	return c
+}
+
+func startCommand(ctx context.Context, spanName, operation string) (context.Context, trace.Span) {
+	return tracing.Tracer.Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperationName(operation),
+		),
+	)
+}
+
+// commandError returns the error of a command unless it only reports a missing key.
+func commandError(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
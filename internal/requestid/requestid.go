@@ -0,0 +1,38 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header is the gRPC metadata key and the AMQP header that carry the id of a request.
+const Header = "x-request-id"
+
+// maxLength limits the request ids taken from the caller's metadata.
+const maxLength = 128
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context that carries the request id.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// FromContext returns the id of the request the context belongs to, or an empty string when it has none.
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
+
+// FromIncoming returns the request id sent by the caller, or a new one when the caller sent none
+// or one longer than 128 characters.
+func FromIncoming(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(Header); len(values) > 0 && values[0] != "" && len(values[0]) <= maxLength {
+		return values[0]
+	}
+
+	return uuid.NewString()
+}
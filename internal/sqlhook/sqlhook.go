@@ -0,0 +1,158 @@
+// Package sqlhook wraps a database/sql driver, so code such as metrics and tracing can observe
+// every query the sqlc queries run, including the ones in transactions, until its rows are read.
+package sqlhook
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// Hook is called before a query runs. It returns the context the query runs with and a function
+// that is called with the error of the query once it is done. For queries that return rows that
+// is when the rows are closed, so reading them is part of the query.
+type Hook func(ctx context.Context, query string) (context.Context, func(err error))
+
+// Wrap returns a connector whose connections run the hooks around every query.
+func Wrap(connector driver.Connector, hooks ...Hook) driver.Connector {
+	return &hookConnector{connector: connector, hooks: hooks}
+}
+
+type hookConnector struct {
+	connector driver.Connector
+	hooks     []Hook
+}
+
+func (c *hookConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &hookConn{conn: conn, hooks: c.hooks}, nil
+}
+
+func (c *hookConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// hookConn runs the hooks around the queries of a connection. The optional driver interfaces
+// are passed through to the wrapped connection, which must implement the context variants.
+type hookConn struct {
+	conn  driver.Conn
+	hooks []Hook
+}
+
+// before runs the hooks for the query and returns the context to run it with and the function
+// that ends them.
+func (c *hookConn) before(ctx context.Context, query string) (context.Context, func(err error)) {
+	afters := make([]func(err error), len(c.hooks))
+	for i, hook := range c.hooks {
+		ctx, afters[i] = hook(ctx, query)
+	}
+
+	return ctx, func(err error) {
+		for i := len(afters) - 1; i >= 0; i-- {
+			afters[i](err)
+		}
+	}
+}
+
+func (c *hookConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, after := c.before(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		after(err)
+		return nil, err
+	}
+	return &hookRows{Rows: rows, after: after}, nil
+}
+
+func (c *hookConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, after := c.before(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	after(err)
+	return result, err
+}
+
+func (c *hookConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(query)
+}
+
+func (c *hookConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.conn.Prepare(query)
+}
+
+func (c *hookConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *hookConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return nil, errors.New("sqlhook: driver doesn't support BeginTx")
+}
+
+func (c *hookConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *hookConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *hookConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *hookConn) IsValid() bool {
+	if validator, ok := c.conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// hookRows ends the hooks of its query once the rows are closed, with the first error met
+// while reading them.
+type hookRows struct {
+	driver.Rows
+	after func(err error)
+	err   error
+}
+
+func (r *hookRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && !errors.Is(err, io.EOF) && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *hookRows) Close() error {
+	err := r.Rows.Close()
+	if r.after != nil {
+		r.after(errors.Join(r.err, err))
+		r.after = nil
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/imhasandl/message-service/internal/metrics"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryHook records a span for every query, named after the sqlc query. It is an sqlhook.Hook,
+// so the span of a query that returns rows lasts until they are read and records the errors
+// met while reading them.
+func QueryHook(ctx context.Context, query string) (context.Context, func(err error)) {
+	name := metrics.QueryName(query)
+	ctx, span := Tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(name),
+			semconv.DBQueryText(query),
+		),
+	)
+	return ctx, func(err error) {
+		End(span, err)
+	}
+}
@@ -0,0 +1,83 @@
+// Package tracing sets up OpenTelemetry tracing: the exporter spans are sent to, the W3C trace
+// context propagation and the spans of the Postgres queries.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the name the spans of the service are reported under, unless OTEL_SERVICE_NAME
+// is set.
+const ServiceName = "message-service"
+
+// Tracer creates the spans of the service. It uses the tracer provider installed by Start and
+// records nothing before that.
+var Tracer = otel.Tracer("github.com/imhasandl/message-service")
+
+// NewExporter creates the span exporter with the name: otlp sends spans to the OTLP gRPC endpoint
+// configured by the OTEL_EXPORTER_OTLP_* environment variables, stdout writes them to stdout and
+// none or an empty name disables tracing and returns a nil exporter.
+func NewExporter(ctx context.Context, name string) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		return otlptracegrpc.New(ctx)
+	case "stdout":
+		return stdouttrace.New()
+	}
+
+	return nil, fmt.Errorf("unknown traces exporter %q", name)
+}
+
+// Start installs the W3C trace context and baggage propagators and a tracer provider that sends
+// the spans to the exporter in batches. Tests can pass a tracetest.InMemoryExporter and read the
+// spans it recorded after calling the returned function, which flushes the spans and stops the
+// provider. With a nil exporter no spans are recorded, but the trace context of incoming calls is
+// still passed on to RabbitMQ.
+func Start(ctx context.Context, exporter sdktrace.SpanExporter) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
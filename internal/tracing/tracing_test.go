@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var exporter = tracetest.NewInMemoryExporter()
+
+// TestMain starts tracing once, because Tracer keeps using the first tracer provider installed.
+func TestMain(m *testing.M) {
+	stop, err := Start(context.Background(), exporter)
+	if err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	stop(context.Background())
+	os.Exit(code)
+}
+
+// recordedSpans flushes the batched spans and returns the ones recorded since the last call.
+func recordedSpans(t *testing.T) tracetest.SpanStubs {
+	t.Helper()
+
+	provider := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	exporter.Reset()
+	return spans
+}
+
+func TestQueryHook(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		err        error
+		wantName   string
+		wantStatus codes.Code
+	}{
+		{
+			name:       "named query",
+			query:      "-- name: GetMessageByID :one\nSELECT * FROM messages WHERE id = $1",
+			wantName:   "GetMessageByID",
+			wantStatus: codes.Unset,
+		},
+		{
+			name:       "failed query",
+			query:      "-- name: DeleteMessage :exec\nDELETE FROM messages WHERE id = $1",
+			err:        errors.New("connection reset"),
+			wantName:   "DeleteMessage",
+			wantStatus: codes.Error,
+		},
+		{
+			name:       "query without a name",
+			query:      "SELECT 1",
+			wantName:   "unknown",
+			wantStatus: codes.Unset,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, parent := Tracer.Start(context.Background(), "SendMessage")
+			queryCtx, after := QueryHook(ctx, tt.query)
+			after(tt.err)
+			parent.End()
+
+			spans := recordedSpans(t)
+			if len(spans) != 2 {
+				t.Fatalf("recorded %d spans, want 2", len(spans))
+			}
+
+			span := spans[0]
+			if span.Name != tt.wantName {
+				t.Errorf("span name = %q, want %q", span.Name, tt.wantName)
+			}
+			if span.SpanKind != trace.SpanKindClient {
+				t.Errorf("span kind = %v, want %v", span.SpanKind, trace.SpanKindClient)
+			}
+			if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("span parent = %v, want %v", span.Parent.SpanID(), parent.SpanContext().SpanID())
+			}
+			if got := trace.SpanContextFromContext(queryCtx); got.SpanID() != span.SpanContext.SpanID() {
+				t.Errorf("query context span = %v, want the query span %v", got.SpanID(), span.SpanContext.SpanID())
+			}
+			if span.Status.Code != tt.wantStatus {
+				t.Errorf("span status = %v, want %v", span.Status.Code, tt.wantStatus)
+			}
+
+			wantAttributes := []attribute.KeyValue{
+				semconv.DBSystemPostgreSQL,
+				semconv.DBOperationName(tt.wantName),
+				semconv.DBQueryText(tt.query),
+			}
+			for _, want := range wantAttributes {
+				if !hasAttribute(span.Attributes, want) {
+					t.Errorf("span attributes = %v, want %v", span.Attributes, want)
+				}
+			}
+		})
+	}
+}
+
+func hasAttribute(attributes []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attributes {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
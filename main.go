@@ -1,34 +1,69 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"net"
-
-	_ "github.com/lib/pq" // Import the postgres driver
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/imhasandl/message-service/cmd/helper"
 	"github.com/imhasandl/message-service/cmd/server"
+	"github.com/imhasandl/message-service/internal/content"
 	"github.com/imhasandl/message-service/internal/database"
+	"github.com/imhasandl/message-service/internal/encryption"
+	"github.com/imhasandl/message-service/internal/health"
+	"github.com/imhasandl/message-service/internal/linkpreview"
+	"github.com/imhasandl/message-service/internal/metrics"
+	"github.com/imhasandl/message-service/internal/moderation"
 	"github.com/imhasandl/message-service/internal/rabbitmq"
+	"github.com/imhasandl/message-service/internal/ratelimit"
 	"github.com/imhasandl/message-service/internal/redis"
+	"github.com/imhasandl/message-service/internal/sqlhook"
+	"github.com/imhasandl/message-service/internal/tracing"
 	pb "github.com/imhasandl/message-service/protos"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
+// shutdownTimeout is how long a shutdown waits for the running calls to end.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	slog.SetDefault(helper.NewLogger(""))
+
 	env := helper.GetENVSecrets()
+	slog.SetDefault(helper.NewLogger(env.LogLevel))
+
+	traceExporter, err := tracing.NewExporter(context.Background(), env.TracesExporter)
+	if err != nil {
+		helper.Fatal("can't create trace exporter", "error", err)
+	}
+	stopTracing, err := tracing.Start(context.Background(), traceExporter)
+	if err != nil {
+		helper.Fatal("can't start tracing", "error", err)
+	}
+	defer stopTracing(context.Background())
 
 	lis, err := net.Listen("tcp", env.Port)
 	if err != nil {
-		log.Fatalf("failed to listed: %v", err)
+		helper.Fatal("can't listen", "address", env.Port, "error", err)
 	}
 
-	dbConn, err := sql.Open("postgres", env.DBURL)
+	connector, err := pq.NewConnector(env.DBURL)
 	if err != nil {
-		log.Fatalf("Error opening database: %s", err)
+		helper.Fatal("can't open database", "error", err)
 	}
+	// Every query, also in transactions, is recorded in the metrics and traces.
+	dbConn := sql.OpenDB(sqlhook.Wrap(connector, tracing.QueryHook, metrics.QueryHook))
 	dbQueries := database.New(dbConn)
 	defer dbConn.Close()
 
@@ -37,19 +72,162 @@ func main() {
 
 	rabbitmq, err := rabbitmq.NewRabbitMQ(env.RabbitMQ)
 	if err != nil {
-		log.Fatalf("error initializing rabbit mq: %v", err)
+		helper.Fatal("can't initialize rabbit mq", "error", err)
 	}
 	defer rabbitmq.Close()
 
-	server := server.NewServer(dbQueries, env.TokenSecret, rabbitmq)
+	moderationConfig, err := moderation.LoadConfig(env.ModerationConfig)
+	if err != nil {
+		helper.Fatal("can't load moderation config", "error", err)
+	}
+
+	repeatCounter := moderation.RepeatCounterFunc(func(ctx context.Context, senderID, contentHash string) (int64, error) {
+		return redis.IncrementContentRepeat(ctx, senderID, contentHash, moderationConfig.Spam.RepeatWindow())
+	})
+
+	moderationPipeline, err := moderation.NewPipelineFromConfig(moderationConfig, repeatCounter)
+	if err != nil {
+		helper.Fatal("can't build moderation pipeline", "error", err)
+	}
+
+	rateLimitConfig, err := ratelimit.LoadConfig(env.RateLimitConfig)
+	if err != nil {
+		helper.Fatal("can't load rate limit config", "error", err)
+	}
+	limiter := ratelimit.NewLimiter(rateLimitConfig, ratelimit.StoreFunc(redis.SlidingWindowAllow))
+
+	encryptor, err := newEncryptor(env)
+	if err != nil {
+		helper.Fatal("can't load encryption keys", "error", err)
+	}
+	if encryptor == nil {
+		slog.Warn("no encryption keys configured, message content is stored as plaintext")
+	}
+
+	requestIDInterceptor := server.NewRequestIDInterceptor()
+	loggingInterceptor := server.NewLoggingInterceptor(env.TokenSecret)
+	metricsInterceptor := server.NewMetricsInterceptor()
+	metricsStreamInterceptor := server.NewMetricsStreamInterceptor()
+	rateLimitInterceptor := server.NewRateLimitInterceptor(dbQueries, env.TokenSecret, limiter)
+	presenceInterceptor := server.NewPresenceInterceptor(env.TokenSecret)
+
+	server := server.NewServer(dbConn, env.TokenSecret, rabbitmq, server.Options{
+		ModeratorIDs:        env.ModeratorIDs,
+		Moderation:          moderationPipeline,
+		MessageRequestLimit: env.MessageRequestLimit,
+		PinnedMessagesLimit: env.PinnedMessagesLimit,
+		Encryptor:           encryptor,
+		ContentLimits: content.Limits{
+			MaxRunes: int(env.MaxMessageRunes),
+			MaxBytes: int(env.MaxMessageBytes),
+		},
+		LinkPreviewFetcher: linkpreview.NewHTTPFetcher(linkpreview.Options{
+			Timeout:      5 * time.Second,
+			MaxBodyBytes: 1 << 20,
+		}),
+	})
+
+	// SIGTERM and SIGINT stop the background jobs and shut the server down gracefully.
+	jobsCtx, stopJobs := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopJobs()
+	go server.RunBackgroundJobs(jobsCtx)
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithFilter(filters.Not(filters.HealthCheck())),
+		)),
+		grpc.ChainUnaryInterceptor(
+			requestIDInterceptor,
+			loggingInterceptor,
+			metricsInterceptor,
+			rateLimitInterceptor,
+			presenceInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			metricsStreamInterceptor,
+		),
+	)
 	pb.RegisterMessageServiceServer(s, server)
 
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	healthChecker := health.NewChecker(healthServer, []health.Dependency{
+		{Name: "postgres", Check: dbConn.PingContext},
+		{Name: "redis", Check: redis.Ping},
+		{Name: "rabbitmq", Check: func(context.Context) error { return rabbitmq.Check() }},
+	}, "", pb.MessageService_ServiceDesc.ServiceName)
+	go healthChecker.Run(jobsCtx)
+
 	reflection.Register(s)
-	log.Printf("Server listening on %v", lis.Addr())
+
+	go serveMetrics(env.MetricsPort)
+
+	go shutdownOnSignal(jobsCtx, s, healthServer)
+
+	slog.Info("server listening", "address", lis.Addr().String())
 
 	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to lister: %v", err)
+		helper.Fatal("can't serve", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// shutdownOnSignal waits until the context is cancelled by a signal, marks the server as not
+// serving, so load balancers stop sending calls, and stops it gracefully. Calls that don't end
+// within shutdownTimeout, such as event streams, are cancelled.
+func shutdownOnSignal(ctx context.Context, s *grpc.Server, healthServer *grpchealth.Server) {
+	<-ctx.Done()
+	slog.Info("shutting down")
+
+	healthServer.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		slog.Warn("calls didn't end in time, stopping the server", "timeout", shutdownTimeout)
+		s.Stop()
 	}
 }
+
+// serveMetrics serves the Prometheus metrics on /metrics of the address.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	slog.Info("metrics listening", "address", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		helper.Fatal("can't serve metrics", "address", address, "error", err)
+	}
+}
+
+// newEncryptor builds the encryptor for message content from the keys file or the keys in the
+// environment. It returns nil when no keys are configured.
+func newEncryptor(env helper.EnvConfig) (*encryption.Encryptor, error) {
+	var keys map[string][]byte
+	var err error
+	currentKeyID := env.EncryptionCurrentKeyID
+	switch {
+	case env.EncryptionKeysFile != "":
+		keys, currentKeyID, err = encryption.LoadKeysFile(env.EncryptionKeysFile)
+	case env.EncryptionKeys != "":
+		keys, err = encryption.ParseKeys(env.EncryptionKeys)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := encryption.NewLocalKeyProvider(keys, currentKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryption.NewEncryptor(provider), nil
+}
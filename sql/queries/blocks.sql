@@ -0,0 +1,17 @@
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id, blocked_at)
+VALUES (
+   $1,
+   $2,
+   NOW()
+)
+ON CONFLICT (blocker_id, blocked_id) DO NOTHING;
+
+-- name: UnblockUser :exec
+DELETE FROM user_blocks
+WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: ListBlockedUsers :many
+SELECT * FROM user_blocks
+WHERE blocker_id = $1
+ORDER BY blocked_at DESC;
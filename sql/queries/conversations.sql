@@ -0,0 +1,35 @@
+-- name: GetConversationSettings :one
+SELECT * FROM conversation_settings
+WHERE user_low = $1 AND user_high = $2;
+
+-- name: UpsertDisappearingSettings :one
+INSERT INTO conversation_settings (user_low, user_high, disappear_after_seconds, disappear_mode, updated_by, updated_at)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   NOW()
+)
+ON CONFLICT (user_low, user_high) DO UPDATE
+SET disappear_after_seconds = EXCLUDED.disappear_after_seconds, disappear_mode = EXCLUDED.disappear_mode, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+RETURNING *;
+
+-- name: ListConversations :many
+SELECT conversations.partner_id, users.username AS partner_username, MAX(conversations.sent_at)::timestamp AS last_message_at
+FROM (
+   SELECT receiver_id AS partner_id, sent_at FROM messages
+   WHERE sender_id = $1 AND send_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+   UNION ALL
+   SELECT sender_id AS partner_id, sent_at FROM messages
+   WHERE receiver_id = $1 AND send_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+) AS conversations
+JOIN users ON users.id = conversations.partner_id
+WHERE NOT EXISTS (
+   SELECT 1 FROM message_requests
+   WHERE message_requests.sender_id = conversations.partner_id AND message_requests.receiver_id = $1 AND message_requests.status <> 'accepted'
+)
+GROUP BY conversations.partner_id, users.username
+ORDER BY last_message_at DESC
+LIMIT $2;
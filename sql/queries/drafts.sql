@@ -0,0 +1,52 @@
+-- name: SaveDraft :one
+INSERT INTO drafts (user_id, partner_id, content, content_ciphertext, content_data_key, content_key_id, version, updated_at)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6,
+   $7,
+   NOW()
+)
+ON CONFLICT (user_id, partner_id) DO UPDATE
+SET content = EXCLUDED.content, content_ciphertext = EXCLUDED.content_ciphertext, content_data_key = EXCLUDED.content_data_key,
+   content_key_id = EXCLUDED.content_key_id, version = EXCLUDED.version, updated_at = NOW()
+WHERE drafts.version < EXCLUDED.version
+RETURNING *;
+
+-- name: GetDraft :one
+SELECT * FROM drafts
+WHERE user_id = $1 AND partner_id = $2;
+
+-- name: ListDrafts :many
+SELECT * FROM drafts
+WHERE user_id = $1
+ORDER BY updated_at DESC;
+
+-- name: DeleteDraft :execrows
+-- The row is kept empty with the version, so a save from a device that hasn't seen the delete
+-- loses.
+UPDATE drafts
+SET content = '', content_ciphertext = NULL, content_data_key = NULL, content_key_id = NULL, version = $3, updated_at = NOW()
+WHERE user_id = $1 AND partner_id = $2 AND version <= $3;
+
+-- name: ClearDraft :exec
+-- Like DeleteDraft, but the version is moved past the last saved one.
+UPDATE drafts
+SET content = '', content_ciphertext = NULL, content_data_key = NULL, content_key_id = NULL, version = version + 1, updated_at = NOW()
+WHERE user_id = $1 AND partner_id = $2;
+
+-- name: ListDraftsToReencrypt :many
+SELECT * FROM drafts
+WHERE content_key_id IS DISTINCT FROM sqlc.arg(content_key_id)
+   AND (content <> '' OR content_ciphertext IS NOT NULL)
+   AND (user_id, partner_id) > (sqlc.arg(user_id)::uuid, sqlc.arg(partner_id)::uuid)
+ORDER BY user_id, partner_id
+LIMIT sqlc.arg(limit_count);
+
+-- name: ReencryptDraft :execrows
+UPDATE drafts
+SET content = '', content_ciphertext = sqlc.arg(content_ciphertext), content_data_key = sqlc.arg(content_data_key), content_key_id = sqlc.arg(content_key_id)
+WHERE user_id = sqlc.arg(user_id) AND partner_id = sqlc.arg(partner_id) AND version = sqlc.arg(version);
@@ -0,0 +1,44 @@
+-- name: CreateMessageEntity :exec
+INSERT INTO message_entities (message_id, start_offset, length, kind, value, user_id)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6
+);
+
+-- name: DeleteMessageEntities :exec
+DELETE FROM message_entities
+WHERE message_id = $1;
+
+-- name: ListMessageEntities :many
+SELECT message_entities.message_id, message_entities.start_offset, message_entities.length, message_entities.kind, message_entities.value, message_entities.user_id,
+   link_previews.status AS preview_status, link_previews.title AS preview_title, link_previews.description AS preview_description, link_previews.image_url AS preview_image_url
+FROM message_entities
+LEFT JOIN link_previews ON message_entities.kind = 'url' AND link_previews.url = message_entities.value
+WHERE message_entities.message_id = ANY(sqlc.arg(message_ids)::uuid[])
+ORDER BY message_entities.message_id, message_entities.start_offset;
+
+-- name: CreateLinkPreview :exec
+INSERT INTO link_previews (url)
+VALUES ($1)
+ON CONFLICT (url) DO NOTHING;
+
+-- name: ClaimLinkPreviews :many
+UPDATE link_previews
+SET status = 'fetching', attempts = attempts + 1, fetched_at = NOW()
+WHERE url IN (
+   SELECT url FROM link_previews
+   WHERE status = 'pending' OR (status = 'fetching' AND fetched_at < NOW() - INTERVAL '5 minutes' AND attempts < 3)
+   ORDER BY created_at
+   LIMIT $1
+   FOR UPDATE SKIP LOCKED
+)
+RETURNING url;
+
+-- name: UpdateLinkPreview :exec
+UPDATE link_previews
+SET status = $2, title = $3, description = $4, image_url = $5, fetched_at = NOW()
+WHERE url = $1;
@@ -0,0 +1,53 @@
+-- name: GetDeviceToken :one
+SELECT * FROM device_tokens
+WHERE id = $1;
+
+-- name: UpsertDeviceIdentityKey :one
+INSERT INTO device_identity_keys (device_id, user_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature, created_at, updated_at)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6,
+   NOW(),
+   NOW()
+)
+ON CONFLICT (device_id) DO UPDATE
+SET identity_key = EXCLUDED.identity_key,
+   signed_prekey_id = EXCLUDED.signed_prekey_id,
+   signed_prekey = EXCLUDED.signed_prekey,
+   signed_prekey_signature = EXCLUDED.signed_prekey_signature,
+   updated_at = NOW()
+RETURNING *;
+
+-- name: AddOneTimePrekey :exec
+INSERT INTO device_one_time_prekeys (device_id, key_id, public_key, created_at)
+VALUES (
+   $1,
+   $2,
+   $3,
+   NOW()
+)
+ON CONFLICT (device_id, key_id) DO NOTHING;
+
+-- name: CountOneTimePrekeys :one
+SELECT COUNT(*) FROM device_one_time_prekeys
+WHERE device_id = $1;
+
+-- name: ListDeviceIdentityKeys :many
+SELECT * FROM device_identity_keys
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: ClaimOneTimePrekey :one
+DELETE FROM device_one_time_prekeys
+WHERE (device_id, key_id) = (
+   SELECT p.device_id, p.key_id FROM device_one_time_prekeys p
+   WHERE p.device_id = $1
+   ORDER BY p.key_id
+   LIMIT 1
+   FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
@@ -1,17 +1,35 @@
 -- name: SendMessage :one
-INSERT INTO messages (id, sent_at, sender_id, receiver_id, content) 
+INSERT INTO messages (id, sent_at, sender_id, receiver_id, content, client_message_id, is_encrypted, encrypted_payload, encryption_headers, content_ciphertext, content_data_key, content_key_id, send_at, expires_at, disappear_after_seconds, disappear_after_read, system_event, forwarded_from_sender_id, forwarded_from_sent_at, kind, payload, payload_ciphertext) 
 VALUES (
    $1, 
    NOW(),
    $2,
    $3,
-   $4
+   $4,
+   $5,
+   $6,
+   $7,
+   $8,
+   $9,
+   $10,
+   $11,
+   $12,
+   $13,
+   $14,
+   $15,
+   $16,
+   $17,
+   $18,
+   $19,
+   $20,
+   $21
 )
+ON CONFLICT (sender_id, client_message_id) DO NOTHING
 RETURNING *;
 
 -- name: GetMessages :many
 SELECT * FROM messages
-WHERE sender_id = $1 and receiver_id = $2
+WHERE sender_id = $1 and receiver_id = $2 AND send_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
 ORDER BY sent_at;
 
 -- name: DeleteMessage :exec
@@ -20,6 +38,89 @@ WHERE id = $1;
 
 -- name: ChangeMessage :one
 UPDATE messages
-SET content = $2
-WHERE id = $1
-RETURNING *;
\ No newline at end of file
+SET content = $2, content_ciphertext = $3, content_data_key = $4, content_key_id = $5, payload = $6, payload_ciphertext = $7
+WHERE id = $1 AND is_encrypted = FALSE
+RETURNING *;
+
+-- name: GetMessageByID :one
+SELECT * FROM messages
+WHERE id = $1;
+
+
+-- name: GetMessageByClientID :one
+SELECT * FROM messages
+WHERE sender_id = $1 AND client_message_id = $2;
+
+-- name: ListMessagesToReencrypt :many
+SELECT * FROM messages
+WHERE is_encrypted = FALSE
+   AND (content_key_id IS DISTINCT FROM $1 OR (payload_ciphertext IS NULL AND payload <> '{}'))
+   AND id > $2
+ORDER BY id
+LIMIT $3;
+
+-- name: ReencryptMessage :execrows
+UPDATE messages
+SET content = '', content_ciphertext = sqlc.arg(content_ciphertext), content_data_key = sqlc.arg(content_data_key), content_key_id = sqlc.arg(content_key_id),
+   payload = sqlc.arg(payload), payload_ciphertext = sqlc.arg(payload_ciphertext)
+WHERE id = sqlc.arg(id)
+   AND content = sqlc.arg(old_content)
+   AND content_ciphertext IS NOT DISTINCT FROM sqlc.arg(old_content_ciphertext);
+
+-- name: ListScheduledMessages :many
+SELECT * FROM messages
+WHERE sender_id = $1 AND send_at IS NOT NULL
+ORDER BY send_at;
+
+-- name: RescheduleMessage :one
+UPDATE messages
+SET send_at = $3
+WHERE id = $1 AND sender_id = $2 AND send_at IS NOT NULL
+RETURNING *;
+
+-- name: CancelScheduledMessage :execrows
+DELETE FROM messages
+WHERE id = $1 AND sender_id = $2 AND send_at IS NOT NULL;
+
+-- name: ListDueScheduledMessages :many
+SELECT id FROM messages
+WHERE send_at <= NOW()
+ORDER BY send_at
+LIMIT $1;
+
+-- name: ClaimScheduledMessage :one
+-- The claimed row stays locked until the transaction ends, and other replicas skip it.
+UPDATE messages
+SET sent_at = NOW(), send_at = NULL, notified_at = NOW(), expires_at = CASE
+   WHEN disappear_after_seconds IS NOT NULL AND NOT disappear_after_read THEN NOW() + disappear_after_seconds * INTERVAL '1 second'
+END
+WHERE id = (
+   SELECT id FROM messages
+   WHERE id = $1 AND send_at <= NOW()
+   FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkMessageNotified :exec
+UPDATE messages
+SET notified_at = NOW()
+WHERE id = $1;
+
+-- name: MarkMessagesRead :execrows
+UPDATE messages
+SET read_at = NOW(), expires_at = CASE
+   WHEN disappear_after_read THEN NOW() + disappear_after_seconds * INTERVAL '1 second'
+   ELSE expires_at
+END
+WHERE sender_id = $1 AND receiver_id = $2 AND read_at IS NULL AND send_at IS NULL;
+
+-- name: DeleteExpiredMessages :many
+DELETE FROM messages
+WHERE id IN (
+   SELECT id FROM messages
+   WHERE expires_at <= NOW()
+   ORDER BY expires_at
+   LIMIT $1
+   FOR UPDATE SKIP LOCKED
+)
+RETURNING id, sender_id, receiver_id;
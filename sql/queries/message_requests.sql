@@ -0,0 +1,36 @@
+-- name: GetMessageRequest :one
+SELECT * FROM message_requests
+WHERE sender_id = $1 AND receiver_id = $2;
+
+-- name: RecordMessageRequest :one
+INSERT INTO message_requests (id, sender_id, receiver_id, status, message_count, created_at, updated_at)
+VALUES (
+   sqlc.arg(id),
+   sqlc.arg(sender_id),
+   sqlc.arg(receiver_id),
+   'pending',
+   1,
+   NOW(),
+   NOW()
+)
+ON CONFLICT (sender_id, receiver_id) DO UPDATE
+SET message_count = message_requests.message_count + 1, updated_at = NOW()
+WHERE message_requests.status = 'pending' AND message_requests.message_count < sqlc.arg(message_limit)::int
+RETURNING *;
+
+-- name: ListMessageRequests :many
+SELECT * FROM message_requests
+WHERE receiver_id = $1 AND status = 'pending'
+ORDER BY updated_at DESC;
+
+-- name: UpdateMessageRequestStatus :one
+UPDATE message_requests
+SET status = $3, updated_at = NOW()
+WHERE id = $1 AND receiver_id = $2 AND status = 'pending'
+RETURNING *;
+
+-- name: HasSentMessage :one
+SELECT EXISTS (
+   SELECT 1 FROM messages
+   WHERE sender_id = $1 AND receiver_id = $2 AND send_at IS NULL
+);
@@ -0,0 +1,26 @@
+-- name: PinMessage :one
+INSERT INTO pinned_messages (message_id, user_low, user_high, slot, pinned_by, pinned_at)
+SELECT sqlc.arg(message_id), sqlc.arg(user_low), sqlc.arg(user_high), free_slots.slot, sqlc.arg(pinned_by), NOW()
+FROM generate_series(1, sqlc.arg(pin_limit)::int) AS free_slots(slot)
+WHERE free_slots.slot NOT IN (
+   SELECT pinned_messages.slot FROM pinned_messages
+   WHERE pinned_messages.user_low = sqlc.arg(user_low) AND pinned_messages.user_high = sqlc.arg(user_high)
+)
+ORDER BY free_slots.slot
+LIMIT 1
+RETURNING *;
+
+-- name: UnpinMessage :execrows
+DELETE FROM pinned_messages
+WHERE message_id = $1;
+
+-- name: ListPinnedMessageIDs :many
+SELECT message_id FROM pinned_messages
+WHERE user_low = $1 AND user_high = $2;
+
+-- name: ListPinnedMessages :many
+SELECT messages.* FROM messages
+JOIN pinned_messages ON pinned_messages.message_id = messages.id
+WHERE pinned_messages.user_low = $1 AND pinned_messages.user_high = $2
+   AND (messages.expires_at IS NULL OR messages.expires_at > NOW())
+ORDER BY pinned_messages.pinned_at DESC;
@@ -0,0 +1,45 @@
+-- name: CreatePoll :exec
+INSERT INTO polls (message_id, option_count, multiple_choice, anonymous)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetPoll :one
+SELECT * FROM polls
+WHERE message_id = $1;
+
+-- name: ListPolls :many
+SELECT * FROM polls
+WHERE message_id = ANY(sqlc.arg(message_ids)::uuid[]);
+
+-- name: BumpPollVersion :one
+-- Locks the open poll for the rest of the transaction, so its changes are applied one at a time.
+UPDATE polls SET version = version + 1
+WHERE message_id = $1 AND closed_at IS NULL
+RETURNING version;
+
+-- name: CastVote :execrows
+-- The poll row is share locked, so a vote either lands before ClosePoll or sees the poll closed.
+INSERT INTO poll_ballots (message_id, user_id, options, voted_at)
+SELECT polls.message_id, sqlc.arg(user_id)::uuid, sqlc.arg(options)::int[], NOW()
+FROM polls
+WHERE polls.message_id = sqlc.arg(message_id) AND polls.closed_at IS NULL
+FOR SHARE
+ON CONFLICT (message_id, user_id) DO UPDATE
+SET options = EXCLUDED.options, voted_at = EXCLUDED.voted_at;
+
+-- name: RetractVote :execrows
+DELETE FROM poll_ballots
+WHERE poll_ballots.message_id = sqlc.arg(message_id) AND poll_ballots.user_id = sqlc.arg(user_id)
+   AND EXISTS (
+      SELECT 1 FROM polls
+      WHERE polls.message_id = sqlc.arg(message_id) AND polls.closed_at IS NULL
+      FOR SHARE
+   );
+
+-- name: ClosePoll :execrows
+UPDATE polls SET closed_at = NOW()
+WHERE message_id = $1 AND closed_at IS NULL;
+
+-- name: ListPollBallots :many
+SELECT * FROM poll_ballots
+WHERE message_id = ANY(sqlc.arg(message_ids)::uuid[])
+ORDER BY voted_at;
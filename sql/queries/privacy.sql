@@ -0,0 +1,16 @@
+-- name: GetPrivacySettings :one
+SELECT * FROM user_privacy_settings
+WHERE user_id = $1;
+
+-- name: UpsertPrivacySettings :one
+INSERT INTO user_privacy_settings (user_id, messages_from, hide_last_seen, hide_forward_sender, updated_at)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   NOW()
+)
+ON CONFLICT (user_id) DO UPDATE
+SET messages_from = EXCLUDED.messages_from, hide_last_seen = EXCLUDED.hide_last_seen, hide_forward_sender = EXCLUDED.hide_forward_sender, updated_at = NOW()
+RETURNING *;
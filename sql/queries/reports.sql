@@ -0,0 +1,41 @@
+-- name: CreateReport :one
+INSERT INTO reports (id, reported_at, reported_by, reason, message_id, reported_user_id, category, content_snapshot,
+   content_snapshot_ciphertext, content_snapshot_data_key, content_snapshot_key_id)
+VALUES (
+   $1,
+   NOW(),
+   $2,
+   $3,
+   $4,
+   $5,
+   $6,
+   $7,
+   $8,
+   $9,
+   $10
+)
+RETURNING *;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE status = $1
+ORDER BY reported_at
+LIMIT $2 OFFSET $3;
+
+-- name: ResolveReport :one
+UPDATE reports
+SET status = $2, resolved_by = $3, resolved_at = NOW(), resolution_note = $4
+WHERE id = $1 AND status = 'open'
+RETURNING *;
+
+-- name: ListReportsToReencrypt :many
+SELECT * FROM reports
+WHERE content_snapshot_key_id IS DISTINCT FROM $1
+   AND id > $2
+ORDER BY id
+LIMIT $3;
+
+-- name: ReencryptReport :exec
+UPDATE reports
+SET content_snapshot = '', content_snapshot_ciphertext = $2, content_snapshot_data_key = $3, content_snapshot_key_id = $4
+WHERE id = $1;
@@ -0,0 +1,21 @@
+-- name: StarMessage :exec
+INSERT INTO starred_messages (user_id, message_id, starred_at)
+VALUES (
+   $1,
+   $2,
+   NOW()
+)
+ON CONFLICT (user_id, message_id) DO NOTHING;
+
+-- name: UnstarMessage :execrows
+DELETE FROM starred_messages
+WHERE user_id = $1 AND message_id = $2;
+
+-- name: ListStarredMessages :many
+SELECT sqlc.embed(messages), starred_messages.starred_at, users.username AS partner_username
+FROM starred_messages
+JOIN messages ON messages.id = starred_messages.message_id
+JOIN users ON users.id = CASE WHEN messages.sender_id = starred_messages.user_id THEN messages.receiver_id ELSE messages.sender_id END
+WHERE starred_messages.user_id = $1 AND (messages.expires_at IS NULL OR messages.expires_at > NOW())
+ORDER BY starred_messages.starred_at DESC, starred_messages.message_id
+LIMIT $2 OFFSET $3;
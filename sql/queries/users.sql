@@ -1,3 +1,7 @@
 -- name: GetUserByID :one
 SELECT * FROM users
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetUsersByUsernames :many
+SELECT * FROM users
+WHERE username = ANY(sqlc.arg(usernames)::text[]);
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE user_blocks (
+   blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   blocked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (blocker_id, blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked_id ON user_blocks(blocked_id);
+
+-- +goose Down
+DROP INDEX idx_user_blocks_blocked_id;
+DROP TABLE user_blocks;
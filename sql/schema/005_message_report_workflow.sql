@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE reports
+   ADD COLUMN message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+   ADD COLUMN reported_user_id UUID,
+   ADD COLUMN category TEXT NOT NULL DEFAULT 'other',
+   ADD COLUMN content_snapshot TEXT NOT NULL DEFAULT '',
+   ADD COLUMN status TEXT NOT NULL DEFAULT 'open',
+   ADD COLUMN resolved_by UUID,
+   ADD COLUMN resolved_at TIMESTAMP,
+   ADD COLUMN resolution_note TEXT;
+
+CREATE UNIQUE INDEX idx_reports_message_reporter ON reports(message_id, reported_by);
+CREATE INDEX idx_reports_status ON reports(status, reported_at);
+
+-- +goose Down
+DROP INDEX idx_reports_status;
+DROP INDEX idx_reports_message_reporter;
+ALTER TABLE reports
+   DROP COLUMN resolution_note,
+   DROP COLUMN resolved_at,
+   DROP COLUMN resolved_by,
+   DROP COLUMN status,
+   DROP COLUMN content_snapshot,
+   DROP COLUMN category,
+   DROP COLUMN reported_user_id,
+   DROP COLUMN message_id;
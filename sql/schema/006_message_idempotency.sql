@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE messages ADD COLUMN client_message_id TEXT;
+
+ALTER TABLE messages ADD CONSTRAINT messages_sender_client_message_id_key UNIQUE (sender_id, client_message_id);
+
+-- +goose Down
+ALTER TABLE messages DROP CONSTRAINT messages_sender_client_message_id_key;
+ALTER TABLE messages DROP COLUMN client_message_id;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE user_privacy_settings (
+   user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+   messages_from TEXT NOT NULL DEFAULT 'everyone', -- e.g., 'everyone', 'subscriptions', 'subscribers', 'mutual'
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE user_privacy_settings;
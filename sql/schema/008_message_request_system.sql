@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE message_requests (
+   id UUID PRIMARY KEY,
+   sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   receiver_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   status TEXT NOT NULL DEFAULT 'pending', -- e.g., 'pending', 'accepted', 'declined'
+   message_count INT NOT NULL DEFAULT 0,
+   created_at TIMESTAMP NOT NULL,
+   updated_at TIMESTAMP NOT NULL,
+   UNIQUE(sender_id, receiver_id)
+);
+
+CREATE INDEX idx_message_requests_receiver_status ON message_requests(receiver_id, status);
+
+-- +goose Down
+DROP INDEX idx_message_requests_receiver_status;
+DROP TABLE message_requests;
@@ -0,0 +1,35 @@
+-- +goose Up
+CREATE TABLE device_identity_keys (
+    device_id UUID PRIMARY KEY REFERENCES device_tokens(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    identity_key BYTEA NOT NULL,
+    signed_prekey_id INT NOT NULL,
+    signed_prekey BYTEA NOT NULL,
+    signed_prekey_signature BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_device_identity_keys_user_id ON device_identity_keys(user_id);
+
+CREATE TABLE device_one_time_prekeys (
+    device_id UUID NOT NULL REFERENCES device_tokens(id) ON DELETE CASCADE,
+    key_id INT NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (device_id, key_id)
+);
+
+ALTER TABLE messages
+    ADD COLUMN is_encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN encrypted_payload BYTEA,
+    ADD COLUMN encryption_headers JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE messages
+    DROP COLUMN encryption_headers,
+    DROP COLUMN encrypted_payload,
+    DROP COLUMN is_encrypted;
+DROP TABLE device_one_time_prekeys;
+DROP INDEX idx_device_identity_keys_user_id;
+DROP TABLE device_identity_keys;
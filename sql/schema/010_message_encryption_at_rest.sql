@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE messages
+    ADD COLUMN content_ciphertext BYTEA,
+    ADD COLUMN content_data_key BYTEA,
+    ADD COLUMN content_key_id TEXT;
+
+CREATE INDEX idx_messages_content_key_id ON messages(content_key_id);
+
+-- +goose Down
+DROP INDEX idx_messages_content_key_id;
+ALTER TABLE messages
+    DROP COLUMN content_key_id,
+    DROP COLUMN content_data_key,
+    DROP COLUMN content_ciphertext;
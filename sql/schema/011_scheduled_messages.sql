@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE messages ADD COLUMN send_at TIMESTAMP;
+
+CREATE INDEX idx_messages_send_at ON messages(send_at) WHERE send_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_messages_send_at;
+ALTER TABLE messages DROP COLUMN send_at;
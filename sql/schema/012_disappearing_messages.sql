@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE conversation_settings (
+   user_low UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   user_high UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   disappear_after_seconds INT NOT NULL DEFAULT 0, -- 0 turns disappearing messages off
+   disappear_mode TEXT NOT NULL DEFAULT 'after_send', -- e.g., 'after_send', 'after_read'
+   updated_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_low, user_high),
+   CHECK (user_low < user_high)
+);
+
+ALTER TABLE messages
+   ADD COLUMN read_at TIMESTAMP,
+   ADD COLUMN expires_at TIMESTAMP,
+   ADD COLUMN disappear_after_seconds INT,
+   ADD COLUMN disappear_after_read BOOLEAN NOT NULL DEFAULT FALSE,
+   ADD COLUMN system_event TEXT;
+
+CREATE INDEX idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_messages_expires_at;
+ALTER TABLE messages
+   DROP COLUMN system_event,
+   DROP COLUMN disappear_after_read,
+   DROP COLUMN disappear_after_seconds,
+   DROP COLUMN expires_at,
+   DROP COLUMN read_at;
+DROP TABLE conversation_settings;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE user_privacy_settings ADD COLUMN hide_last_seen BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE user_privacy_settings DROP COLUMN hide_last_seen;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE drafts (
+   user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   partner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   content TEXT NOT NULL,
+   version BIGINT NOT NULL,
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, partner_id)
+);
+
+-- +goose Down
+DROP TABLE drafts;
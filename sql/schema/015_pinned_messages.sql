@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE pinned_messages (
+   message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+   user_low UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   user_high UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   slot INT NOT NULL, -- 1 to the pin limit, taken by one pin at a time
+   pinned_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   pinned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   UNIQUE (user_low, user_high, slot)
+);
+
+-- +goose Down
+DROP TABLE pinned_messages;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE starred_messages (
+   user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+   starred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX starred_messages_user_id_starred_at_idx ON starred_messages (user_id, starred_at DESC);
+
+-- +goose Down
+DROP TABLE starred_messages;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE messages
+   ADD COLUMN forwarded_from_sender_id UUID REFERENCES users(id) ON DELETE SET NULL, -- NULL when the original sender hides it
+   ADD COLUMN forwarded_from_sent_at TIMESTAMP; -- set for every forwarded message
+
+ALTER TABLE user_privacy_settings ADD COLUMN hide_forward_sender BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE user_privacy_settings DROP COLUMN hide_forward_sender;
+ALTER TABLE messages
+   DROP COLUMN forwarded_from_sent_at,
+   DROP COLUMN forwarded_from_sender_id;
@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE message_entities (
+   message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+   start_offset INT NOT NULL, -- in Unicode code points
+   length INT NOT NULL,
+   kind TEXT NOT NULL, -- e.g., 'mention', 'url', 'hashtag'
+   value TEXT NOT NULL,
+   user_id UUID REFERENCES users(id) ON DELETE SET NULL, -- the mentioned user
+   PRIMARY KEY (message_id, start_offset)
+);
+
+CREATE TABLE link_previews (
+   url TEXT PRIMARY KEY,
+   status TEXT NOT NULL DEFAULT 'pending', -- e.g., 'pending', 'fetching', 'ready', 'failed'
+   title TEXT NOT NULL DEFAULT '',
+   description TEXT NOT NULL DEFAULT '',
+   image_url TEXT NOT NULL DEFAULT '',
+   attempts INT NOT NULL DEFAULT 0,
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   fetched_at TIMESTAMP
+);
+
+CREATE INDEX idx_link_previews_pending ON link_previews(created_at) WHERE status IN ('pending', 'fetching');
+
+-- +goose Down
+DROP TABLE link_previews;
+DROP TABLE message_entities;
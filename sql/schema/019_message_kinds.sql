@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE messages
+   ADD COLUMN kind TEXT NOT NULL DEFAULT 'text', -- e.g., 'text', 'markdown', 'system', 'location', 'contact', 'poll'
+   ADD COLUMN payload JSONB NOT NULL DEFAULT '{}'; -- the typed payload of the kind
+
+UPDATE messages SET kind = 'system' WHERE system_event IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE messages
+   DROP COLUMN payload,
+   DROP COLUMN kind;
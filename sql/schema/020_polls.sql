@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE polls (
+   message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+   option_count INT NOT NULL,
+   multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+   anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+   closed_at TIMESTAMP
+);
+
+-- Every voter has one ballot with all the options they chose, so changing a vote replaces it atomically.
+CREATE TABLE poll_ballots (
+   message_id UUID NOT NULL REFERENCES polls(message_id) ON DELETE CASCADE,
+   user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   options INT[] NOT NULL,
+   voted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (message_id, user_id)
+);
+
+INSERT INTO polls (message_id, option_count, multiple_choice, anonymous)
+SELECT id,
+   jsonb_array_length(COALESCE(payload->'options', '[]')),
+   COALESCE((payload->>'multipleChoice')::BOOLEAN, FALSE),
+   COALESCE((payload->>'anonymous')::BOOLEAN, FALSE)
+FROM messages
+WHERE kind = 'poll';
+
+-- +goose Down
+DROP TABLE poll_ballots;
+DROP TABLE polls;
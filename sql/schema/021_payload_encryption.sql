@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE messages
+    ADD COLUMN payload_ciphertext BYTEA;
+
+-- +goose Down
+ALTER TABLE messages
+    DROP COLUMN payload_ciphertext;
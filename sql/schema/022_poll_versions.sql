@@ -0,0 +1,7 @@
+-- +goose Up
+-- Every vote, retraction and close bumps the version, so a cached poll state can be compared
+-- with the latest change.
+ALTER TABLE polls ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE polls DROP COLUMN version;
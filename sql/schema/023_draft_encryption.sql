@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE drafts
+    ADD COLUMN content_ciphertext BYTEA,
+    ADD COLUMN content_data_key BYTEA,
+    ADD COLUMN content_key_id TEXT;
+
+-- +goose Down
+ALTER TABLE drafts
+    DROP COLUMN content_key_id,
+    DROP COLUMN content_data_key,
+    DROP COLUMN content_ciphertext;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE reports
+    ADD COLUMN content_snapshot_ciphertext BYTEA,
+    ADD COLUMN content_snapshot_data_key BYTEA,
+    ADD COLUMN content_snapshot_key_id TEXT;
+
+-- +goose Down
+ALTER TABLE reports
+    DROP COLUMN content_snapshot_key_id,
+    DROP COLUMN content_snapshot_data_key,
+    DROP COLUMN content_snapshot_ciphertext;
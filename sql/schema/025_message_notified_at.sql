@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE messages
+    ADD COLUMN notified_at TIMESTAMP;
+
+-- Messages delivered before the column existed were notified when they were sent.
+UPDATE messages SET notified_at = sent_at WHERE send_at IS NULL;
+
+-- +goose Down
+ALTER TABLE messages
+    DROP COLUMN notified_at;